@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spf13/cobra"
+)
+
+// cannedACLs are the canned ACLs which can be applied to objects
+var cannedACLs = []string{
+	s3.ObjectCannedACLPrivate,
+	s3.ObjectCannedACLPublicRead,
+	s3.ObjectCannedACLPublicReadWrite,
+	s3.ObjectCannedACLAuthenticatedRead,
+	s3.ObjectCannedACLAwsExecRead,
+	s3.ObjectCannedACLBucketOwnerRead,
+	s3.ObjectCannedACLBucketOwnerFullControl,
+}
+
+// aclCmd represents the acl command
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Inspect and modify object ACLs",
+	Long:  ``,
+}
+
+// aclGetCmd represents the acl get command
+var aclGetCmd = &cobra.Command{
+	Use:   "get <S3 URIs>",
+	Short: "Print the ACLs of S3 objects",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := GetACLs(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// aclSetCmd represents the acl set command
+var aclSetCmd = &cobra.Command{
+	Use:   "set <S3 URIs>",
+	Short: "Apply a canned ACL to S3 objects",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		acl, err := cmd.Flags().GetString("acl")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := validateCannedACL(acl); err != nil {
+			log.Fatal(err)
+		}
+		if err := SetACLs(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex, acl); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// GetACLs prints the ACLs of S3 objects using svc, s3Uris is a list of prefixes/keys, recurse tells whether or not to
+// use everything under the prefixes, delimiter tells the delimiter to use when listing, searchDepth determines the number
+// of prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys
+func GetACLs(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string) error {
+	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	wrap, err := newS3Wrapper(svc).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("acl get", dataOut)
+	for obj := range wrap.GetACLs(listCh) {
+		text := fmt.Sprintf("%s\n  %-12s %s\n", obj.FullKey, "OWNER", obj.Owner)
+		for _, grant := range obj.Grants {
+			text += fmt.Sprintf("  %-12s %s\n", grant.Permission, grant.Grantee)
+		}
+		results.ACLResult("acl", obj, text)
+	}
+	results.Summary()
+	return wrap.Err()
+}
+
+// SetACLs applies the canned acl to S3 objects using svc, s3Uris is a list of prefixes/keys, recurse tells whether
+// or not to use everything under the prefixes, delimiter tells the delimiter to use when listing, searchDepth determines
+// the number of prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys
+func SetACLs(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, acl string) error {
+	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	wrap, err := newS3Wrapper(svc).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("acl set", statusOut)
+	stopProgress := reportProgress(results)
+	for obj := range wrap.PutACLs(listCh, acl) {
+		results.Result("acl", obj, "", fmt.Sprintf("Applied %s to %s\n", acl, obj.FullKey))
+	}
+	stopProgress()
+	results.Summary()
+	return wrap.Err()
+}
+
+// validateCannedACL checks acl is one of the canned ACLs
+func validateCannedACL(acl string) error {
+	for _, cannedACL := range cannedACLs {
+		if acl == cannedACL {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ACL %q, expected one of %s", acl, strings.Join(cannedACLs, ", "))
+}
+
+func init() {
+	rootCmd.AddCommand(aclCmd)
+	aclCmd.AddCommand(aclGetCmd)
+	aclCmd.AddCommand(aclSetCmd)
+
+	aclGetCmd.Flags().BoolP("recursive", "r", false, "Get the ACLs of all keys for this prefix")
+	aclSetCmd.Flags().BoolP("recursive", "r", false, "Set the ACLs of all keys for this prefix")
+	aclSetCmd.Flags().String("acl", "", "Canned ACL to apply, one of "+strings.Join(cannedACLs, ", "))
+	aclSetCmd.MarkFlagRequired("acl")
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// benchmarkCmd represents the benchmark command
+var benchmarkCmd = &cobra.Command{
+	Use:   "benchmark <S3 URIs>",
+	Short: "Measure the list and get throughput of S3 prefixes at several concurrency levels",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		levels, err := cmd.Flags().GetIntSlice("levels")
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, level := range levels {
+			if level < 1 {
+				log.Fatalf("invalid concurrency level %d, levels must be at least 1", level)
+			}
+		}
+		noGet, err := cmd.Flags().GetBool("no-get")
+		if err != nil {
+			log.Fatal(err)
+		}
+		maxObjects, err := cmd.Flags().GetInt("max-objects")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := Benchmark(GetS3Client(), args, delimiter, searchDepth, keyRegex, levels, noGet, maxObjects); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// benchmarkRun is the throughput of one pass at one concurrency level
+type benchmarkRun struct {
+	pass        string
+	concurrency int
+	objects     int64
+	bytes       int64
+	errors      int64
+	elapsed     time.Duration
+}
+
+// Benchmark lists everything under s3Uris using svc, then gets up to maxObjects of the listed objects, once for each of
+// the concurrency levels, and writes the objects and bytes per second of every pass as a table. delimiter tells the
+// delimiter to use when listing, searchDepth determines the number of prefixes to list before parallelizing list calls,
+// which is what lets listing use more than one call per uri, keyRegex is a regex filter on keys, noGet skips the get
+// passes. Nothing is written to S3 or to the local filesystem.
+func Benchmark(svc *s3.S3, s3Uris []string, delimiter string, searchDepth int, keyRegex string, levels []int, noGet bool, maxObjects int) error {
+	s3Uris = expandS3URIs(s3Uris)
+	var runs []*benchmarkRun
+	var objects []*s3wrapper.ListOutput
+	for _, level := range levels {
+		wrap, err := newS3Wrapper(svc).WithMaxConcurrency(level).WithRegionFrom(s3Uris[0])
+		if err != nil {
+			return err
+		}
+		run := &benchmarkRun{pass: "list", concurrency: level}
+		listed := benchmarkList(wrap, s3Uris, delimiter, searchDepth, keyRegex, run)
+		if objects == nil {
+			objects = listed
+		}
+		runs = append(runs, run)
+	}
+	if len(objects) > maxObjects && maxObjects > 0 {
+		objects = objects[:maxObjects]
+	}
+
+	if !noGet {
+		for _, level := range levels {
+			wrap, err := newS3Wrapper(svc).WithMaxConcurrency(level).WithRegionFrom(s3Uris[0])
+			if err != nil {
+				return err
+			}
+			run := &benchmarkRun{pass: "get", concurrency: level}
+			benchmarkGet(wrap, objects, level, run)
+			runs = append(runs, run)
+		}
+	}
+
+	table := tabwriter.NewWriter(dataOut, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "pass\tconcurrency\tobjects\tbytes\terrors\tseconds\tobjects/s\tbytes/s\t")
+	for _, run := range runs {
+		seconds := run.elapsed.Seconds()
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\t%d\t%.2f\t%.0f\t%s\t\n",
+			run.pass,
+			run.concurrency,
+			humanize.Comma(run.objects),
+			humanize.Bytes(uint64(run.bytes)),
+			run.errors,
+			seconds,
+			float64(run.objects)/seconds,
+			humanize.Bytes(uint64(float64(run.bytes)/seconds)))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	return wrapperErrs.Err()
+}
+
+// benchmarkList lists everything under s3Uris with wrap like Ls does, first
+// listing searchDepth levels of prefixes so they are listed in parallel, and
+// records its throughput in run. The listed objects are returned.
+func benchmarkList(wrap *s3wrapper.S3Wrapper, s3Uris []string, delimiter string, searchDepth int, keyRegex string, run *benchmarkRun) []*s3wrapper.ListOutput {
+	var objects []*s3wrapper.ListOutput
+	start := time.Now()
+	for i := 0; i < searchDepth; i++ {
+		prefixes := make([]string, 0)
+		for itm := range wrap.ListAll(s3Uris, false, delimiter, keyRegex) {
+			if itm.IsPrefix {
+				prefixes = append(prefixes, strings.TrimSuffix(itm.FullKey, delimiter)+delimiter)
+			} else {
+				objects = append(objects, itm)
+			}
+		}
+		s3Uris = prefixes
+	}
+	for itm := range wrap.ListAll(s3Uris, true, delimiter, keyRegex) {
+		objects = append(objects, itm)
+	}
+	run.elapsed = time.Since(start)
+	run.objects = int64(len(objects))
+	for _, obj := range objects {
+		run.bytes += obj.Size
+	}
+	return objects
+}
+
+// benchmarkGet reads objects with wrap, concurrency at a time, discarding
+// their content, and records its throughput in run
+func benchmarkGet(wrap *s3wrapper.S3Wrapper, objects []*s3wrapper.ListOutput, concurrency int, run *benchmarkRun) {
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	start := time.Now()
+	for _, obj := range objects {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(obj *s3wrapper.ListOutput) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			reader, err := wrap.GetReader(obj.Bucket, obj.Key)
+			if err != nil {
+				atomic.AddInt64(&run.errors, 1)
+				return
+			}
+			defer reader.Close()
+			n, err := io.Copy(ioutil.Discard, reader)
+			atomic.AddInt64(&run.bytes, n)
+			if err != nil {
+				atomic.AddInt64(&run.errors, 1)
+				return
+			}
+			atomic.AddInt64(&run.objects, 1)
+		}(obj)
+	}
+	wg.Wait()
+	run.elapsed = time.Since(start)
+}
+
+func init() {
+	rootCmd.AddCommand(benchmarkCmd)
+
+	benchmarkCmd.Flags().IntSlice("levels", []int{8, 32, 128}, "Concurrency levels to measure, comma separated")
+	benchmarkCmd.Flags().Bool("no-get", false, "Only measure listing, without reading any object")
+	benchmarkCmd.Flags().Int("max-objects", 1000, "Maximum number of the listed objects to read in each get pass, 0 reads them all")
+}
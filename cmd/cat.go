@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// catCmd represents the cat command
+var catCmd = &cobra.Command{
+	Use:   "cat <S3 URIs>",
+	Short: "Write the contents of S3 objects to STDOUT one after the other, as is",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := Cat(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// Cat writes the bytes of the objects under s3Uris to stdout using svc, without decompressing or splitting them into
+// lines, recurse tells whether or not to write everything under s3Uris, delimiter tells the delimiter to use when
+// listing, searchDepth determines how many prefixes to list before parallelizing list calls, keyRegex is a regex
+// filter on keys. The uris are written in the order they were given and the objects of each uri sorted by key, one
+// object at a time so no more than a copy buffer is held in memory. Cat stops at the first object it can't write
+// since the output would be missing it.
+func Cat(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string) error {
+	wrap, err := newS3Wrapper(svc).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("cat", statusOut)
+	for _, uri := range expandS3URIs(s3Uris) {
+		listCh, err := Ls(svc, []string{uri}, recurse, delimiter, searchDepth, keyRegex)
+		if err != nil {
+			return err
+		}
+		var keys []*s3wrapper.ListOutput
+		for itm := range listCh {
+			if !itm.IsPrefix {
+				keys = append(keys, itm)
+			}
+		}
+		// a partial listing would leave objects out of the output
+		if err := wrap.Err(); err != nil {
+			return err
+		}
+		// parallel listings send the keys in no particular order
+		sort.Slice(keys, func(i, j int) bool { return keys[i].FullKey < keys[j].FullKey })
+
+		for _, key := range keys {
+			if err := catObject(wrap, key); err != nil {
+				return err
+			}
+			results.Count(key)
+		}
+	}
+	results.Summary()
+	return wrap.Err()
+}
+
+// catObject copies the body of key to dataOut
+func catObject(wrap *s3wrapper.S3Wrapper, key *s3wrapper.ListOutput) error {
+	reader, err := wrap.GetReader(key.Bucket, key.Key)
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %s", key.FullKey, err)
+	}
+	defer reader.Close()
+	if _, err := io.Copy(dataOut, reader); err != nil {
+		return fmt.Errorf("unable to read %s: %s", key.FullKey, err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(catCmd)
+
+	catCmd.Flags().BoolP("recursive", "r", false, "Write all keys for this prefix")
+}
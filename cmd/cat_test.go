@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCat(t *testing.T) {
+	stdout, _ := withCleanGlobals(t)
+	// binary bodies without a trailing newline, which cat writes as is
+	objects := map[string]string{
+		"bk/cat/a.bin": "\x00\xff\r\na",
+		"bk/cat/b.bin": "b\x00\x1f\x8b",
+	}
+	_, svc := newFakeS3(t, objects)
+	// the uris are written in the order they were given, not by key
+	if err := Cat(svc, []string{"s3://bk/cat/b.bin", "s3://bk/cat/a.bin"}, false, "/", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), objects["bk/cat/b.bin"]+objects["bk/cat/a.bin"]; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCatRegions(t *testing.T) {
+	stdout, _ := withCleanGlobals(t)
+	// the buckets are only used by this test so their regions are looked up
+	fake, svc := newRegionalFakeS3(t, map[string]string{
+		"cat-west/a.bin": "\x00a",
+		"cat-eu/b.bin":   "\x00b",
+	}, map[string]string{"cat-west": "us-west-2", "cat-eu": "eu-west-1"})
+	if err := Cat(svc, []string{"s3://cat-west/a.bin", "s3://cat-eu/b.bin"}, false, "/", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "\x00a\x00b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// every object is read in the region of its bucket, not the one of the
+	// first uri
+	if got, want := fake.servedIn("us-west-2"), []string{"GET cat-west/a.bin", "HEAD cat-west/a.bin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in us-west-2, want %v", got, want)
+	}
+	if got, want := fake.servedIn("eu-west-1"), []string{"GET cat-eu/b.bin", "HEAD cat-eu/b.bin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in eu-west-1, want %v", got, want)
+	}
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+const groupByPrefix = "prefix"
+
+// countCmd represents the count command
+var countCmd = &cobra.Command{
+	Use:   "count <S3 URIs>",
+	Short: "Count the objects under S3 prefixes",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		groupBy, err := cmd.Flags().GetString("group-by")
+		if err != nil {
+			log.Fatal(err)
+		}
+		depth, err := cmd.Flags().GetInt("depth")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if groupBy != "" && groupBy != groupByPrefix {
+			log.Fatalf("unknown group %q, expected %s", groupBy, groupByPrefix)
+		}
+		if depth < 1 {
+			log.Fatal("--depth must be at least 1")
+		}
+		if groupBy == "" {
+			depth = 0
+		}
+		if err := Count(GetS3Client(), args, delimiter, searchDepth, keyRegex, depth); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// prefixCount is the number of objects under a prefix
+type prefixCount struct {
+	Prefix  string
+	Objects int64
+}
+
+// Count prints the number of objects under s3Uris using svc, delimiter tells the delimiter to use when listing,
+// searchDepth determines the number of prefixes to list before parallelizing list calls, keyRegex is a regex filter on
+// keys, a groupDepth above 0 breaks the count down by the first groupDepth path segments below each of the s3Uris
+func Count(svc *s3.S3, s3Uris []string, delimiter string, searchDepth int, keyRegex string, groupDepth int) error {
+	listCh, err := Ls(svc, s3Uris, true, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	// longest uris first so keys are grouped below the most specific one
+	bases := expandS3URIs(s3Uris)
+	sort.Slice(bases, func(i, j int) bool { return len(bases[i]) > len(bases[j]) })
+
+	results := newEmitter("count", dataOut)
+	counts := make(map[string]int64)
+	for obj := range listCh {
+		if obj.IsPrefix {
+			continue
+		}
+		results.Count(obj)
+		if groupDepth > 0 {
+			counts[groupPrefix(obj.FullKey, obj.Bucket, bases, delimiter, groupDepth)]++
+		}
+	}
+
+	if groupDepth == 0 {
+		objects, _ := results.totals()
+		if !results.json {
+			fmt.Fprintf(dataOut, "%s\n", humanize.Comma(objects))
+		}
+	}
+
+	groups := make([]*prefixCount, 0, len(counts))
+	for prefix, objects := range counts {
+		groups = append(groups, &prefixCount{Prefix: prefix, Objects: objects})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Objects != groups[j].Objects {
+			return groups[i].Objects > groups[j].Objects
+		}
+		return groups[i].Prefix < groups[j].Prefix
+	})
+	for _, group := range groups {
+		results.GroupResult("count", group.Prefix, group.Objects, 0, fmt.Sprintf("%15s %s\n", humanize.Comma(group.Objects), group.Prefix))
+	}
+	results.Summary()
+	return wrapperErrs.Err()
+}
+
+// groupPrefix returns the prefix made of the first depth path segments of
+// fullKey below the longest matching base uri, keys fewer than depth segments
+// deep are grouped under the prefix they are in
+func groupPrefix(fullKey string, bucket string, bases []string, delimiter string, depth int) string {
+	base := fmt.Sprintf("s3://%s/", bucket)
+	for _, uri := range bases {
+		if strings.HasPrefix(fullKey, uri) {
+			base = uri
+			break
+		}
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(fullKey, base), delimiter, depth+1)
+	// drop the object name, or everything past depth
+	segments = segments[:len(segments)-1]
+	if len(segments) == 0 {
+		return base
+	}
+	return base + strings.Join(segments, delimiter) + delimiter
+}
+
+func init() {
+	rootCmd.AddCommand(countCmd)
+
+	countCmd.Flags().String("group-by", "", "Break the count down, prefix counts the objects under each sub-prefix")
+	countCmd.Flags().Int("depth", 1, "Number of path segments below the S3 URIs to group by with --group-by prefix")
+}
@@ -3,11 +3,10 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"strings"
 
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/spf13/cobra"
 	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
 )
 
 // cpCmd represents the cp command
@@ -25,36 +24,187 @@ var cpCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = Cp(s3Client, args, recursive, delimiter, searchDepth, keyRegex, flat)
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+		headers, err := headerFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		encryption, err := sseFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		onCollision, err := cmd.Flags().GetString("on-collision")
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch onCollision {
+		case s3wrapper.CollisionOverwrite, s3wrapper.CollisionSkip, s3wrapper.CollisionRename, s3wrapper.CollisionError:
+		default:
+			log.Fatalf("unknown collision strategy %q, expected one of %s, %s, %s or %s", onCollision,
+				s3wrapper.CollisionOverwrite, s3wrapper.CollisionSkip, s3wrapper.CollisionRename, s3wrapper.CollisionError)
+		}
+		verifyMetadata, err := cmd.Flags().GetBool("verify-metadata")
+		if err != nil {
+			log.Fatal(err)
+		}
+		noClobber, err := cmd.Flags().GetBool("no-clobber")
+		if err != nil {
+			log.Fatal(err)
+		}
+		trimPrefix, err := cmd.Flags().GetString("trim-prefix")
+		if err != nil {
+			log.Fatal(err)
+		}
+		checksumAlgorithm, err := cmd.Flags().GetString("checksum-algorithm")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if checksumAlgorithm != "" {
+			if checksumAlgorithm, err = s3wrapper.ParseChecksumAlgorithm(checksumAlgorithm); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if trimPrefix != "" && flat {
+			log.Fatal("--trim-prefix can't be used with --flat")
+		}
+		copyOpts := s3wrapper.CopyOptions{
+			Flat:              flat,
+			Headers:           headers,
+			OnCollision:       onCollision,
+			VerifyMetadata:    verifyMetadata,
+			NoClobber:         noClobber,
+			TrimPrefix:        trimPrefix,
+			Encryption:        encryption,
+			ChecksumAlgorithm: checksumAlgorithm,
+		}
+		if err := regionFlags(cmd, args[0], args[1]); err != nil {
+			log.Fatal(err)
+		}
+		failuresFile, retryFailed, err := failuresFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts := CpOptions{
+			CopyOptions:  copyOpts,
+			DryRun:       dryRun,
+			FailuresFile: failuresFile,
+			RetryFailed:  retryFailed,
+		}
+		err = Cp(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
 	},
 }
 
+// CpOptions are the options of Cp
+type CpOptions struct {
+	// CopyOptions are the options of the copies
+	s3wrapper.CopyOptions
+	// DryRun prints what would be copied without copying
+	DryRun bool
+	// FailuresFile is where the objects which failed are written when non
+	// empty, only the objects in RetryFailed are copied instead of listing
+	// the source when it is non empty
+	FailuresFile string
+	RetryFailed  string
+}
+
 // Cp copies files from one s3 location to another using svc, s3Uris is a list of source and dest s3 URIs, recurse tells
 // whether to list all keys under the source prefix,  delimiter tells the delimiter to use when listing, searchDepth determines
-// the number of prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys, when flat is
-// true it only takes the last part of the prefix as the filename.
-func Cp(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, flat bool) error {
-	listCh, err := Ls(svc, []string{s3Uris[0]}, recurse, delimiter, searchDepth, keyRegex)
+// the number of prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys, opts are the
+// options of the copies.
+func Cp(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, opts CpOptions) error {
+	var listCh chan *s3wrapper.ListOutput
+	var err error
+	if opts.RetryFailed != "" {
+		// the source is still needed to map the keys to the destination
+		listCh, _, err = retryFailedKeys(opts.RetryFailed, s3Uris)
+	} else {
+		listCh, err = Ls(svc, []string{s3Uris[0]}, recurse, delimiter, searchDepth, keyRegex)
+	}
 	if err != nil {
 		return err
 	}
 
-	wrap := s3wrapper.New(svc, maxParallel)
+	// the wrapper points at the region of the source, CopyAll sends the
+	// copies to the region of the destination
+	failures := &s3wrapper.Failures{}
+	wrap, err := newS3Wrapper(svc).WithDryRun(opts.DryRun).WithFailures(failures).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
 
-	copiedFiles := wrap.CopyAll(listCh, s3Uris[0], s3Uris[1], delimiter, recurse, flat)
+	destBucket, _ := s3wrapper.ParseS3Uri(s3Uris[1])
+	results := newEmitter("cp", statusOut)
+	results.dryRun = opts.DryRun
+	stopProgress := reportProgress(results)
+	copiedFiles := wrap.CopyAll(listCh, s3Uris[0], s3Uris[1], delimiter, recurse, opts.CopyOptions)
 	for file := range copiedFiles {
-		fmt.Printf("Copied %s -> %s%s%s\n", file.FullKey, strings.TrimRight(s3Uris[1], delimiter), delimiter, file.Key)
+		dest := s3wrapper.FormatS3Uri(destBucket, file.Key)
+		if opts.DryRun {
+			results.Result("copy", file, dest, fmt.Sprintf("Would copy %s -> %s\n", file.FullKey, dest))
+		} else {
+			results.Result("copy", file, dest, fmt.Sprintf("Copied %s -> %s%s\n", file.FullKey, dest, formatChecksum(file)))
+		}
 	}
+	stopProgress()
+	results.Summary()
 
+	if opts.FailuresFile != "" {
+		if err := writeFailures(opts.FailuresFile, failures); err != nil {
+			return err
+		}
+	}
+	return wrap.Err()
+}
+
+// regionFlags points the requests about the buckets of source and dest at
+// the regions given with --source-region and --dest-region, the region of a
+// bucket whose flag is unset is autodetected
+func regionFlags(cmd *cobra.Command, source string, dest string) error {
+	for flag, uri := range map[string]string{"source-region": source, "dest-region": dest} {
+		region, err := cmd.Flags().GetString(flag)
+		if err != nil {
+			return err
+		}
+		if region != "" {
+			bucket, _ := s3wrapper.ParseS3Uri(uri)
+			if bucketRegions == nil {
+				bucketRegions = make(map[string]string)
+			}
+			bucketRegions[bucket] = region
+		}
+	}
 	return nil
 }
 
+// formatChecksum formats the additional checksum of a copy to follow its
+// status, it is empty when the copy has none
+func formatChecksum(obj *s3wrapper.ListOutput) string {
+	if obj.Checksum == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s %s)", obj.ChecksumAlgorithm, obj.Checksum)
+}
+
 func init() {
 	rootCmd.AddCommand(cpCmd)
 
 	cpCmd.Flags().BoolP("recursive", "r", false, "Copy all keys for this prefix.")
 	cpCmd.Flags().BoolP("flat", "f", false, "Copy all source files into a flat destination folder (vs. corresponding subfolders)")
+	cpCmd.Flags().BoolP("dry-run", "n", false, "Print what would be copied without copying anything")
+	cpCmd.Flags().String("on-collision", s3wrapper.CollisionOverwrite, "What to do with --flat when several keys have the same name, one of overwrite (the last one wins), skip (the first one wins), rename (add a numeric suffix) or error (stop copying)")
+	cpCmd.Flags().String("trim-prefix", "", "Remove exactly this prefix from every source key to get its path under the destination, instead of the source prefix")
+	cpCmd.Flags().Bool("no-clobber", false, "Skip the keys whose destination already exists instead of overwriting it")
+	cpCmd.Flags().Bool("verify-metadata", false, "HEAD every copy and its source afterwards and fail the objects whose size, content type or ETag differ")
+	cpCmd.Flags().String("checksum-algorithm", "", "Have S3 compute and store the additional checksum of every copy with this algorithm, one of CRC32, CRC32C, SHA1 or SHA256, and print it")
+	cpCmd.Flags().String("source-region", "", "Region of the source bucket, which is autodetected when unset, this is needed without the s3:GetBucketLocation permission")
+	cpCmd.Flags().String("dest-region", "", "Region of the destination bucket, which is autodetected when unset, this is needed without the s3:GetBucketLocation permission")
+	addHeaderFlags(cpCmd)
+	addSSEFlags(cpCmd)
+	addFailuresFlags(cpCmd)
 }
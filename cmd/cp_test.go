@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+func TestCpDelimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		recursive bool
+		flat      bool
+		want      []string
+	}{
+		// every delimiter maps the keys the way / does, without --recursive
+		// keys are copied with their full key
+		{"slash", "/", false, false, []string{"dst/backup/logs/c"}},
+		{"slash recursive", "/", true, false, []string{"dst/backup/2020/a", "dst/backup/2020/b", "dst/backup/c"}},
+		{"pipe", "|", false, false, []string{"dst/backup|logs|c"}},
+		{"pipe recursive", "|", true, false, []string{"dst/backup|2020|a", "dst/backup|2020|b", "dst/backup|c"}},
+		{"pipe flat", "|", true, true, []string{"dst/backup|a", "dst/backup|b", "dst/backup|c"}},
+		{"multi character", "::", false, false, []string{"dst/backup::logs::c"}},
+		{"multi character recursive", "::", true, false, []string{"dst/backup::2020::a", "dst/backup::2020::b", "dst/backup::c"}},
+		{"multi character flat", "::", true, true, []string{"dst/backup::a", "dst/backup::b", "dst/backup::c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			d := test.delimiter
+			// other/logs contains the source prefix but isn't under it
+			fake, svc := newFakeS3(t, map[string]string{
+				"src/logs" + d + "2020" + d + "a": "a",
+				"src/logs" + d + "2020" + d + "b": "b",
+				"src/logs" + d + "c":              "c",
+				"src/other/logs" + d + "d":        "d",
+			})
+			err := Cp(svc, []string{"s3://src/logs" + d, "s3://dst/backup" + d}, test.recursive, d, 0, "", CpOptions{CopyOptions: s3wrapper.CopyOptions{Flat: test.flat}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, key := range fake.keys() {
+				if strings.HasPrefix(key, "dst/") {
+					got = append(got, key)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCpRegions(t *testing.T) {
+	withCleanGlobals(t)
+	// the buckets are only used by this test so their regions are looked up
+	fake, svc := newRegionalFakeS3(t, map[string]string{
+		"cp-eu/logs/a.txt": "a",
+		"cp-eu/logs/b.txt": "b",
+	}, map[string]string{"cp-eu": "eu-west-1", "cp-us": "us-west-2"})
+	err := Cp(svc, []string{"s3://cp-eu/logs/", "s3://cp-us/backup/"}, true, "/", 0, "", CpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fake.keys(), []string{"cp-eu/logs/a.txt", "cp-eu/logs/b.txt", "cp-us/backup/a.txt", "cp-us/backup/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// the source is listed in its region, the copies are made in the region
+	// of the destination
+	if got, want := fake.servedIn("eu-west-1"), []string{"GET cp-eu/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in eu-west-1, want %v", got, want)
+	}
+	if got, want := fake.servedIn("us-west-2"), []string{"PUT cp-us/backup/a.txt", "PUT cp-us/backup/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in us-west-2, want %v", got, want)
+	}
+	if got := fake.servedIn("us-east-1"); len(got) != 0 {
+		t.Errorf("got %v in us-east-1, want none", got)
+	}
+}
+
+func TestCpRegionFlags(t *testing.T) {
+	withCleanGlobals(t)
+	// the regions of the buckets can't be looked up, as without the
+	// s3:GetBucketLocation permission
+	fake, svc := newRegionalFakeS3(t, map[string]string{
+		"cp-flag-src/logs/a.txt": "a",
+	}, nil)
+	for flag, region := range map[string]string{"source-region": "eu-central-1", "dest-region": "ap-south-1"} {
+		if err := cpCmd.Flags().Set(flag, region); err != nil {
+			t.Fatal(err)
+		}
+		flag := flag
+		t.Cleanup(func() { cpCmd.Flags().Set(flag, "") })
+	}
+	if err := regionFlags(cpCmd, "s3://cp-flag-src/logs/", "s3://cp-flag-dest/"); err != nil {
+		t.Fatal(err)
+	}
+	err := Cp(svc, []string{"s3://cp-flag-src/logs/", "s3://cp-flag-dest/"}, true, "/", 0, "", CpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookups := fake.served("HEAD "); len(lookups) != 0 {
+		t.Errorf("looked up %v", lookups)
+	}
+	if got, want := fake.servedIn("eu-central-1"), []string{"GET cp-flag-src/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in eu-central-1, want %v", got, want)
+	}
+	if got, want := fake.servedIn("ap-south-1"), []string{"PUT cp-flag-dest/a.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in ap-south-1, want %v", got, want)
+	}
+	if got := fake.servedIn("us-east-1"); len(got) != 0 {
+		t.Errorf("got %v in us-east-1, want none", got)
+	}
+}
+
+func TestCpNoClobberDenied(t *testing.T) {
+	withCleanGlobals(t)
+	fake, svc := newFakeS3(t, map[string]string{
+		"src/logs/a.txt": "new a",
+		"src/logs/b.txt": "new b",
+		"dst/logs/a.txt": "old a",
+	})
+	// a.txt may not be looked at, so it can't be told whether it exists
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodHead && r.URL.Path == "/dst/logs/a.txt" {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	err := Cp(svc, []string{"s3://src/logs/", "s3://dst/logs/"}, true, "/", 0, "", CpOptions{CopyOptions: s3wrapper.CopyOptions{NoClobber: true}})
+	if err == nil || !strings.Contains(err.Error(), "unable to copy s3://src/logs/a.txt") {
+		t.Errorf("got %v, want the copy of a.txt to fail", err)
+	}
+	if got, want := fake.served("PUT "), []string{"PUT dst/logs/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	webIdentityTokenFileEnvVar = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	roleARNEnvVar              = "AWS_ROLE_ARN"
+	roleSessionNameEnvVar      = "AWS_ROLE_SESSION_NAME"
+	accessKeyIDEnvVar          = "AWS_ACCESS_KEY_ID"
+
+	// webIdentityExpiryWindow refreshes the credentials this long
+	// before they actually expire
+	webIdentityExpiryWindow = time.Minute
+)
+
+// webIdentityRoleProvider retrieves credentials by assuming a role with the
+// web identity token in a file, as done for IAM roles for service accounts
+// (IRSA) in EKS. The vendored SDK predates its own provider for this so
+// the default credential chain never finds these credentials.
+type webIdentityRoleProvider struct {
+	credentials.Expiry
+	client          *sts.STS
+	roleARN         string
+	roleSessionName string
+	tokenFile       string
+}
+
+// Retrieve assumes the role using the current contents of the token file,
+// the file is re-read every time since the token is rotated
+func (p *webIdentityRoleProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("unable to read web identity token file %s: %s", p.tokenFile, err)
+	}
+
+	resp, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.roleSessionName),
+		WebIdentityToken: aws.String(string(token)),
+	})
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("unable to assume role %s with web identity: %s", p.roleARN, err)
+	}
+
+	p.SetExpiration(aws.TimeValue(resp.Credentials.Expiration), webIdentityExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(resp.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(resp.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(resp.Credentials.SessionToken),
+		ProviderName:    "WebIdentityRoleProvider",
+	}, nil
+}
+
+// webIdentityCredentials returns credentials from the web identity token file
+// when AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN are set, static credentials
+// from the environment take precedence like they do in newer SDKs. nil is
+// returned when the default credential chain should be used.
+func webIdentityCredentials(sess *session.Session) *credentials.Credentials {
+	tokenFile := os.Getenv(webIdentityTokenFileEnvVar)
+	roleARN := os.Getenv(roleARNEnvVar)
+	if tokenFile == "" || roleARN == "" || os.Getenv(accessKeyIDEnvVar) != "" {
+		return nil
+	}
+
+	roleSessionName := os.Getenv(roleSessionNameEnvVar)
+	if roleSessionName == "" {
+		roleSessionName = "fasts3-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+
+	return credentials.NewCredentials(&webIdentityRoleProvider{
+		client:          sts.New(sess),
+		roleARN:         roleARN,
+		roleSessionName: roleSessionName,
+		tokenFile:       tokenFile,
+	})
+}
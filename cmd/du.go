@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// compressionExts are the extensions which are combined with the one before
+// them by du --combine-extensions
+var compressionExts = map[string]bool{
+	".gz":     true,
+	".gzip":   true,
+	".bz2":    true,
+	".zst":    true,
+	".snappy": true,
+}
+
+// duCmd represents the du command
+var duCmd = &cobra.Command{
+	Use:   "du <S3 URIs>",
+	Short: "Summarize the storage used under S3 prefixes",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		humanReadable, err := cmd.Flags().GetBool("human-readable")
+		if err != nil {
+			log.Fatal(err)
+		}
+		groupByExtension, err := cmd.Flags().GetBool("group-by-extension")
+		if err != nil {
+			log.Fatal(err)
+		}
+		combineExtensions, err := cmd.Flags().GetBool("combine-extensions")
+		if err != nil {
+			log.Fatal(err)
+		}
+		summarize, err := cmd.Flags().GetBool("summarize")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if summarize && groupByExtension {
+			log.Fatal("--summarize can't be used with --group-by-extension, it only prints the total")
+		}
+		if err := Du(GetS3Client(), args, delimiter, searchDepth, keyRegex, humanReadable, groupByExtension, combineExtensions, summarize); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// duGroup is the number and total size of the objects in a group
+type duGroup struct {
+	Name    string
+	Objects int64
+	Bytes   int64
+}
+
+// Du prints the number and total size of the objects under s3Uris using svc, delimiter tells the delimiter to use when
+// listing, searchDepth determines the number of prefixes to list before parallelizing list calls, keyRegex is a regex
+// filter on keys, humanReadable prints sizes in human-readable units. The total is broken down by the prefixes right
+// under s3Uris, or by the extension of the keys when groupByExtension, in which case combineExtensions keeps the
+// extension before a compression extension, e.g. .json.gz, summarize only prints the total
+func Du(svc *s3.S3, s3Uris []string, delimiter string, searchDepth int, keyRegex string, humanReadable bool, groupByExtension bool, combineExtensions bool, summarize bool) error {
+	listCh, err := Ls(svc, s3Uris, true, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	// prefixes are the prefixes of the listed uris by bucket
+	prefixes := make(map[string][]string)
+	for _, uri := range expandS3URIs(s3Uris) {
+		bucket, prefix := s3wrapper.ParseS3Uri(uri)
+		prefixes[bucket] = append(prefixes[bucket], prefix)
+	}
+
+	results := newEmitter("du", dataOut)
+	groups := make(map[string]*duGroup)
+	for obj := range listCh {
+		if obj.IsPrefix {
+			continue
+		}
+		results.Count(obj)
+		if summarize {
+			continue
+		}
+		var name string
+		if groupByExtension {
+			name = keyExtension(obj.Key, delimiter, combineExtensions)
+		} else if name = subPrefix(obj, prefixes, delimiter); name == "" {
+			// the objects right under s3Uris are only in the total
+			continue
+		}
+		group, ok := groups[name]
+		if !ok {
+			group = &duGroup{Name: name}
+			groups[name] = group
+		}
+		group.Objects++
+		group.Bytes += obj.Size
+	}
+
+	sorted := make([]*duGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Bytes != sorted[j].Bytes {
+			return sorted[i].Bytes > sorted[j].Bytes
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	for _, group := range sorted {
+		results.GroupResult("du", group.Name, group.Objects, group.Bytes, formatDuLine(group.Name, group.Objects, group.Bytes, humanReadable))
+	}
+
+	if !results.json {
+		objects, bytes := results.totals()
+		fmt.Fprint(dataOut, formatDuLine("total", objects, bytes, humanReadable))
+	}
+	results.Summary()
+	return wrapperErrs.Err()
+}
+
+// subPrefix returns the uri of the prefix right under the longest of prefixes
+// obj is under, or an empty string when obj is right under it
+func subPrefix(obj *s3wrapper.ListOutput, prefixes map[string][]string, delimiter string) string {
+	var longest string
+	for _, prefix := range prefixes[obj.Bucket] {
+		if strings.HasPrefix(obj.Key, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if delimiter == "" {
+		return ""
+	}
+	i := strings.Index(obj.Key[len(longest):], delimiter)
+	if i < 0 {
+		return ""
+	}
+	return "s3://" + obj.Bucket + "/" + obj.Key[:len(longest)+i+len(delimiter)]
+}
+
+// keyExtension returns the lower case extension of the basename of key, or
+// (none), combineExtensions includes the extension before a compression
+// extension
+func keyExtension(key string, delimiter string, combineExtensions bool) string {
+	name := key
+	if i := strings.LastIndex(key, delimiter); delimiter != "" && i >= 0 {
+		name = key[i+len(delimiter):]
+	}
+	name = strings.ToLower(name)
+	ext := path.Ext(name)
+	if combineExtensions && compressionExts[ext] {
+		ext = path.Ext(strings.TrimSuffix(name, ext)) + ext
+	}
+	if ext == "" {
+		return "(none)"
+	}
+	return ext
+}
+
+// formatDuLine formats the total size and number of objects of a group
+func formatDuLine(name string, objects int64, bytes int64, humanReadable bool) string {
+	var size string
+	if humanReadable {
+		size = fmt.Sprintf("%10s", humanize.Bytes(uint64(bytes)))
+	} else {
+		size = fmt.Sprintf("%15d", bytes)
+	}
+	return fmt.Sprintf("%s %12s objects %s\n", size, humanize.Comma(objects), name)
+}
+
+func init() {
+	rootCmd.AddCommand(duCmd)
+
+	duCmd.Flags().BoolP("human-readable", "H", false, "Output human-readable sizes")
+	duCmd.Flags().BoolP("summarize", "s", false, "Only print the total, not the prefixes right under the S3 URIs")
+	duCmd.Flags().Bool("group-by-extension", false, "Break the total down by the extension of the keys instead of by prefix")
+	duCmd.Flags().Bool("combine-extensions", false, "Keep the extension before a compression extension with --group-by-extension, e.g. .json.gz")
+}
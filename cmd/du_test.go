@@ -0,0 +1,25 @@
+package cmd
+
+import "testing"
+
+func TestKeyExtension(t *testing.T) {
+	tests := []struct {
+		key       string
+		delimiter string
+		combine   bool
+		want      string
+	}{
+		{"logs/2020/a.GZ", "/", false, ".gz"},
+		{"logs/2020/a.tar.gz", "/", true, ".tar.gz"},
+		{"logs::a.tar.gz", "::", true, ".tar.gz"},
+		// the extension of a directory isn't the one of the name
+		{"logs.d::a", "::", false, "(none)"},
+		{"a.csv", "::", false, ".csv"},
+		{"logs.d/a", "", false, "(none)"},
+	}
+	for _, test := range tests {
+		if got := keyExtension(test.key, test.delimiter, test.combine); got != test.want {
+			t.Errorf("keyExtension(%q, %q, %t) = %q, want %q", test.key, test.delimiter, test.combine, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// validateEndpoint checks --endpoint is either a bare host, which the SDK
+// reaches over https, or a http:// or https:// URL
+func validateEndpoint(endpoint string) error {
+	if endpoint == "" || !strings.Contains(endpoint, "://") {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid --endpoint %q: %s", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid --endpoint %q, only http:// and https:// endpoints are supported", endpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid --endpoint %q, it has no host", endpoint)
+	}
+	return nil
+}
+
+// tlsHTTPClient returns the HTTP client implementing --insecure-skip-verify
+// and --ca-bundle, or nil to keep the SDK's default one when neither is set
+func tlsHTTPClient(insecureSkipVerify bool, caBundle string) (*http.Client, error) {
+	if !insecureSkipVerify && caBundle == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read --ca-bundle: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid --ca-bundle %s, it holds no PEM encoded certificate", caBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// addFailuresFlags adds the --failures-file and --retry-failed flags to cmd
+func addFailuresFlags(cmd *cobra.Command) {
+	cmd.Flags().String("failures-file", "", "Write the S3 uris of the objects which failed to this file, one per line, to retry them with --retry-failed")
+	cmd.Flags().String("retry-failed", "", "Only operate on the objects listed in this file, written by --failures-file, instead of listing")
+}
+
+// failuresFlags returns the values of the --failures-file and --retry-failed
+// flags
+func failuresFlags(cmd *cobra.Command) (failuresFile string, retryFailed string, err error) {
+	if failuresFile, err = cmd.Flags().GetString("failures-file"); err != nil {
+		return "", "", err
+	}
+	if retryFailed, err = cmd.Flags().GetString("retry-failed"); err != nil {
+		return "", "", err
+	}
+	return failuresFile, retryFailed, nil
+}
+
+// writeFailures writes the S3 uris of the keys in failures to path, one per
+// line, path is emptied when nothing failed
+func writeFailures(path string, failures *s3wrapper.Failures) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to write the failures to %s: %s", path, err)
+	}
+	defer file.Close()
+
+	out := bufio.NewWriter(file)
+	for _, k := range failures.Keys() {
+		fmt.Fprintln(out, k.FullKey)
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("unable to write the failures to %s: %s", path, err)
+	}
+	return file.Close()
+}
+
+// loadFailures reads the S3 uris written by writeFailures to path, blank
+// lines and # comments are ignored. Their size isn't known so it is left at 0.
+func loadFailures(path string) ([]*s3wrapper.ListOutput, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the failures to retry: %s", err)
+	}
+	defer file.Close()
+
+	keys := make([]*s3wrapper.ListOutput, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		uri := strings.TrimSpace(scanner.Text())
+		if uri == "" || strings.HasPrefix(uri, "#") {
+			continue
+		}
+		invalid := fmt.Errorf("invalid failure %q in %s, expected the S3 uri of an object", uri, path)
+		if !strings.HasPrefix(uri, "s3://") {
+			return nil, invalid
+		}
+		bucket, key := s3wrapper.ParseS3Uri(uri)
+		if key == "" {
+			return nil, invalid
+		}
+		keys = append(keys, &s3wrapper.ListOutput{
+			Key:     key,
+			FullKey: uri,
+			Bucket:  bucket,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read the failures to retry: %s", err)
+	}
+
+	return keys, nil
+}
+
+// retryFailedKeys loads the keys of --retry-failed from retryFailed and
+// returns them as a listing, s3Uris is returned as is unless it is empty, in
+// which case it holds the uri of the first key to pick the region from
+func retryFailedKeys(retryFailed string, s3Uris []string) (chan *s3wrapper.ListOutput, []string, error) {
+	keys, err := loadFailures(retryFailed)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(s3Uris) == 0 && len(keys) > 0 {
+		s3Uris = []string{keys[0].FullKey}
+	}
+	ch := make(chan *s3wrapper.ListOutput, len(keys))
+	for _, k := range keys {
+		ch <- k
+	}
+	close(ch)
+	return ch, s3Uris, nil
+}
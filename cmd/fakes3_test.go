@@ -0,0 +1,463 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+// fakeObject is an object stored by fakeS3
+type fakeObject struct {
+	body     []byte
+	modified time.Time
+	// partsETag is the ETag of objects uploaded in parts, without quotes,
+	// the ETag is the MD5 of body when empty
+	partsETag string
+	// headers are the headers the object was written with which S3 stores
+	// and returns when it is read
+	headers http.Header
+}
+
+// storedHeaders returns the headers of r which S3 stores with the object r
+// writes. Copies keep the metadata of source unless r replaces it, but
+// like S3 they take their storage class, encryption and redirect from r.
+func storedHeaders(r *http.Request, source *fakeObject) http.Header {
+	replace := source == nil || r.Header.Get("X-Amz-Metadata-Directive") == s3.MetadataDirectiveReplace
+	headers := make(http.Header)
+	if !replace {
+		for name, values := range source.headers {
+			if isFakeMetadata(name) {
+				headers[name] = values
+			}
+		}
+	}
+	for name, values := range r.Header {
+		switch name {
+		case "X-Amz-Storage-Class", "X-Amz-Server-Side-Encryption", "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", "X-Amz-Website-Redirect-Location":
+			headers[name] = values
+		default:
+			if replace && isFakeMetadata(name) {
+				headers[name] = values
+			}
+		}
+	}
+	return headers
+}
+
+// isFakeMetadata tells whether the header name is metadata of the object
+func isFakeMetadata(name string) bool {
+	switch name {
+	case "Content-Type", "Cache-Control", "Content-Disposition", "Content-Encoding", "Content-Language", "Expires":
+		return true
+	}
+	return strings.HasPrefix(name, "X-Amz-Meta-")
+}
+
+// fakeS3 is an in-memory S3 serving the path-style calls the commands make:
+// ListObjectsV2 in a single page, GetObject, HeadObject, PutObject,
+// CopyObject and DeleteObjects
+type fakeS3 struct {
+	sync.Mutex
+	// objects maps bucket/key to its object
+	objects map[string]*fakeObject
+	// requests holds "<method> <bucket>/<key>" for every call served
+	requests []string
+	// requestRegions holds the region whose endpoint each call of requests
+	// was sent to, when made by a client of newRegionalFakeS3
+	requestRegions []string
+	// regions maps buckets to the region HEAD bucket answers for them
+	regions map[string]string
+	// fail is called before serving each call, a non zero status makes the
+	// call fail with that status instead
+	fail func(r *http.Request) int
+}
+
+// newFakeS3 starts a fakeS3 holding objects, keyed by bucket/key, and returns
+// it with a client of it, which has a custom endpoint so the region of the
+// buckets is never looked up
+func newFakeS3(t *testing.T, objects map[string]string) (*fakeS3, *s3.S3) {
+	fake := &fakeS3{objects: make(map[string]*fakeObject)}
+	modified := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	for name, body := range objects {
+		fake.objects[name] = &fakeObject{body: []byte(body), modified: modified}
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, s3.New(fakeSession(t, srv.URL, "us-east-1"))
+}
+
+// newRegionalFakeS3 starts a fakeS3 holding objects whose buckets are in
+// regions, it returns it with a client of the real S3 endpoints of us-east-1
+// whose calls are served by the fake, so the regions of buckets are looked up
+// and the calls are sent to the endpoints of their region like they are
+// against S3
+func newRegionalFakeS3(t *testing.T, objects map[string]string, regions map[string]string) (*fakeS3, *s3.S3) {
+	fake := &fakeS3{objects: make(map[string]*fakeObject), regions: regions}
+	modified := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	for name, body := range objects {
+		fake.objects[name] = &fakeObject{body: []byte(body), modified: modified}
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sessions can't load a CA bundle into a custom transport, nor is one
+	// needed to reach the fake
+	t.Setenv("AWS_CA_BUNDLE", "")
+	sess, err := session.NewSession(aws.NewConfig().
+		WithS3ForcePathStyle(true).
+		WithRegion("us-east-1").
+		WithMaxRetries(0).
+		WithHTTPClient(&http.Client{Transport: regionalTransport{target: target}}).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, s3.New(sess)
+}
+
+// regionalTransport sends the calls made to the S3 endpoints to target
+// instead, with the region of their endpoint in the X-Fake-Region header
+type regionalTransport struct {
+	target *url.URL
+}
+
+func (rt regionalTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	region := "us-east-1"
+	if host := strings.TrimSuffix(r.URL.Hostname(), ".amazonaws.com"); host != "s3" {
+		region = strings.TrimPrefix(host, "s3.")
+	}
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host, r.Host = rt.target.Scheme, rt.target.Host, ""
+	r.Header.Set("X-Fake-Region", region)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// fakeSession returns a session making path-style calls to endpoint without
+// retrying them
+func fakeSession(t *testing.T, endpoint string, region string) *session.Session {
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(endpoint).
+		WithS3ForcePathStyle(true).
+		WithRegion(region).
+		WithMaxRetries(0).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sess
+}
+
+// keys returns the sorted bucket/key of every object
+func (f *fakeS3) keys() []string {
+	f.Lock()
+	defer f.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for name := range f.objects {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// servedIn returns the distinct calls served so far which were sent to the
+// endpoint of region, sorted, leaving out the lookups of bucket regions which
+// every wrapper makes once per bucket
+func (f *fakeS3) servedIn(region string) []string {
+	f.Lock()
+	defer f.Unlock()
+	var calls []string
+	seen := make(map[string]bool)
+	for i, call := range f.requests {
+		if f.requestRegions[i] == region && !seen[call] && !(strings.HasPrefix(call, "HEAD ") && strings.HasSuffix(call, "/")) {
+			seen[call] = true
+			calls = append(calls, call)
+		}
+	}
+	sort.Strings(calls)
+	return calls
+}
+
+// served returns the calls served so far whose "<method> <bucket>/<key>"
+// starts with prefix
+func (f *fakeS3) served(prefix string) []string {
+	f.Lock()
+	defer f.Unlock()
+	var calls []string
+	for _, call := range f.requests {
+		if strings.HasPrefix(call, prefix) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+type fakeContents struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type fakeCommonPrefix struct {
+	Prefix string
+}
+
+type fakeListResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	KeyCount       int
+	IsTruncated    bool
+	Contents       []fakeContents
+	CommonPrefixes []fakeCommonPrefix
+}
+
+type fakeDelete struct {
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type fakeDeleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Deleted []struct {
+		Key string
+	}
+}
+
+type fakeCopyResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string
+	LastModified time.Time
+}
+
+func (o *fakeObject) etag() string {
+	if o.partsETag != "" {
+		return `"` + o.partsETag + `"`
+	}
+	sum := md5.Sum(o.body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.requests = append(f.requests, r.Method+" "+bucket+"/"+key)
+	f.requestRegions = append(f.requestRegions, r.Header.Get("X-Fake-Region"))
+	if f.fail != nil {
+		if status := f.fail(r); status != 0 {
+			writeFakeError(w, status)
+			return
+		}
+	}
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodHead && key == "":
+		region, ok := f.regions[bucket]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Amz-Bucket-Region", region)
+	case r.Method == http.MethodGet && key == "" && query.Get("list-type") == "2":
+		f.list(w, bucket, query.Get("prefix"), query.Get("delimiter"))
+	case r.Method == http.MethodPost && key == "" && hasQuery(r.URL, "delete"):
+		f.delete(w, r, bucket)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copy(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest)
+			return
+		}
+		obj := &fakeObject{body: body, modified: time.Now().UTC(), headers: storedHeaders(r, nil)}
+		f.objects[bucket+"/"+key] = obj
+		w.Header().Set("ETag", obj.etag())
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[bucket+"/"+key]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound)
+			return
+		}
+		for name, values := range obj.headers {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.body)))
+		w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		w.Header().Set("ETag", obj.etag())
+		if r.Method == http.MethodGet {
+			w.Write(obj.body)
+		}
+	default:
+		writeFakeError(w, http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, prefix string, delimiter string) {
+	result := fakeListResult{Name: bucket, Prefix: prefix}
+	var names []string
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	seenPrefixes := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, bucket+"/"+prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(name, bucket+"/")
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if !seenPrefixes[common] {
+					seenPrefixes[common] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, fakeCommonPrefix{Prefix: common})
+				}
+				continue
+			}
+		}
+		obj := f.objects[name]
+		result.Contents = append(result.Contents, fakeContents{
+			Key:          key,
+			LastModified: obj.modified,
+			ETag:         obj.etag(),
+			Size:         len(obj.body),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	writeFakeXML(w, result)
+}
+
+func (f *fakeS3) delete(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req fakeDelete
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeFakeError(w, http.StatusBadRequest)
+		return
+	}
+	var result fakeDeleteResult
+	for _, obj := range req.Objects {
+		delete(f.objects, bucket+"/"+obj.Key)
+		result.Deleted = append(result.Deleted, struct{ Key string }{obj.Key})
+	}
+	writeFakeXML(w, result)
+}
+
+func (f *fakeS3) copy(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest)
+		return
+	}
+	obj, ok := f.objects[source]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound)
+		return
+	}
+	copied := &fakeObject{body: obj.body, modified: time.Now().UTC(), headers: storedHeaders(r, obj)}
+	f.objects[bucket+"/"+key] = copied
+	writeFakeXML(w, fakeCopyResult{ETag: copied.etag(), LastModified: copied.modified})
+}
+
+// hasQuery tells whether u has the query parameter name, even without value
+func hasQuery(u *url.URL, name string) bool {
+	_, ok := u.Query()[name]
+	return ok
+}
+
+func writeFakeXML(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		writeFakeError(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(buf.Bytes())
+}
+
+// writeFakeError answers with status and the S3 error code matching it
+func writeFakeError(w http.ResponseWriter, status int) {
+	code := map[int]string{
+		http.StatusNotFound:           "NoSuchKey",
+		http.StatusForbidden:          "AccessDenied",
+		http.StatusServiceUnavailable: "SlowDown",
+	}[status]
+	if code == "" {
+		code = "InternalError"
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>fake %s</Message></Error>", xml.Header, code, code)
+}
+
+// syncBuffer is a bytes.Buffer which may be written from several goroutines
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+// withCleanGlobals resets the errors, outputs and flags the commands share
+// for the duration of the test, returning the buffers standing for stdout and
+// stderr
+func withCleanGlobals(t *testing.T) (stdout *syncBuffer, stderr *syncBuffer) {
+	stdout, stderr = &syncBuffer{}, &syncBuffer{}
+	oldErrs, oldDataOut, oldStatusOut, oldLogger := wrapperErrs, dataOut, statusOut, logger
+	oldLimit, oldGlobs, oldPrefetch, oldRetries := limit, keyGlobs, prefetch, maxRetries
+	oldRegions := bucketRegions
+	wrapperErrs = &s3wrapper.Errors{}
+	dataOut, statusOut = stdout, stderr
+	logger = log.New(stderr, "", 0)
+	limit, keyGlobs, prefetch, maxRetries = 0, nil, s3wrapper.DefaultPrefetch, 0
+	bucketRegions = nil
+	if maxParallel == 0 {
+		maxParallel = 10
+	}
+	t.Cleanup(func() {
+		wrapperErrs, dataOut, statusOut, logger = oldErrs, oldDataOut, oldStatusOut, oldLogger
+		limit, keyGlobs, prefetch, maxRetries = oldLimit, oldGlobs, oldPrefetch, oldRetries
+		bucketRegions = oldRegions
+	})
+	return stdout, stderr
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// findCmd represents the find command
+var findCmd = &cobra.Command{
+	Use:   "find <S3 URIs>",
+	Short: "Find S3 objects and print them in a custom format",
+	Long: `Recursively lists the objects under S3 prefixes and prints each of them using
+--printf, which supports the following tokens:
+
+  %p   full S3 uri
+  %k   key
+  %f   basename of the key
+  %s   size in bytes
+  %h   human-readable size
+  %t   last modified date in RFC3339
+  %T@  last modified date in seconds since the epoch
+  %%   a literal %
+  \n   newline
+  \t   tab
+  \\   a literal \`,
+	Args: validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := cmd.Flags().GetString("printf")
+		if err != nil {
+			log.Fatal(err)
+		}
+		formatter, err := parsePrintf(format, delimiter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := Find(GetS3Client(), args, delimiter, searchDepth, keyRegex, formatter); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// printfToken formats a single token of a --printf format for an object
+type printfToken func(obj *s3wrapper.ListOutput) string
+
+// printfFormatter formats objects according to a parsed --printf format
+type printfFormatter []printfToken
+
+// Format formats obj by concatenating every token
+func (f printfFormatter) Format(obj *s3wrapper.ListOutput) string {
+	var sb strings.Builder
+	for _, token := range f {
+		sb.WriteString(token(obj))
+	}
+	return sb.String()
+}
+
+// literal is a token which always formats as s
+func literal(s string) printfToken {
+	return func(*s3wrapper.ListOutput) string { return s }
+}
+
+// parsePrintf tokenizes a --printf format, delimiter is used to find the
+// basename of keys
+func parsePrintf(format string, delimiter string) (printfFormatter, error) {
+	var formatter printfFormatter
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			formatter = append(formatter, literal(lit.String()))
+			lit.Reset()
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' && c != '\\' {
+			lit.WriteByte(c)
+			continue
+		}
+		if i+1 == len(format) {
+			return nil, fmt.Errorf("--printf format %q ends with an incomplete %c", format, c)
+		}
+		i++
+		if c == '\\' {
+			switch format[i] {
+			case 'n':
+				lit.WriteByte('\n')
+			case 't':
+				lit.WriteByte('\t')
+			case '\\':
+				lit.WriteByte('\\')
+			default:
+				return nil, fmt.Errorf("unknown escape \\%c in --printf format %q", format[i], format)
+			}
+			continue
+		}
+
+		var token printfToken
+		switch format[i] {
+		case '%':
+			lit.WriteByte('%')
+			continue
+		case 'p':
+			token = func(obj *s3wrapper.ListOutput) string { return obj.FullKey }
+		case 'k':
+			token = func(obj *s3wrapper.ListOutput) string { return obj.Key }
+		case 'f':
+			token = func(obj *s3wrapper.ListOutput) string {
+				// keys without a delimiter are their own name
+				if i := strings.LastIndex(obj.Key, delimiter); delimiter != "" && i >= 0 {
+					return obj.Key[i+len(delimiter):]
+				}
+				return obj.Key
+			}
+		case 's':
+			token = func(obj *s3wrapper.ListOutput) string { return strconv.FormatInt(obj.Size, 10) }
+		case 'h':
+			token = func(obj *s3wrapper.ListOutput) string { return humanize.Bytes(uint64(obj.Size)) }
+		case 't':
+			token = func(obj *s3wrapper.ListOutput) string { return obj.LastModified.Format("2006-01-02T15:04:05Z07:00") }
+		case 'T':
+			if i+1 == len(format) || format[i+1] != '@' {
+				return nil, fmt.Errorf("%%T must be followed by @ in --printf format %q", format)
+			}
+			i++
+			token = func(obj *s3wrapper.ListOutput) string { return strconv.FormatInt(obj.LastModified.Unix(), 10) }
+		default:
+			return nil, fmt.Errorf("unknown token %%%c in --printf format %q", format[i], format)
+		}
+		flush()
+		formatter = append(formatter, token)
+	}
+	flush()
+	return formatter, nil
+}
+
+// Find prints every object under s3Uris using svc formatted by formatter, delimiter tells the delimiter to use when
+// listing, searchDepth determines the number of prefixes to list before parallelizing list calls, keyRegex is a regex
+// filter on keys
+func Find(svc *s3.S3, s3Uris []string, delimiter string, searchDepth int, keyRegex string, formatter printfFormatter) error {
+	listCh, err := Ls(svc, s3Uris, true, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("find", dataOut)
+	for obj := range listCh {
+		if obj.IsPrefix {
+			continue
+		}
+		results.Result("find", obj, "", formatter.Format(obj))
+	}
+	results.Summary()
+	return wrapperErrs.Err()
+}
+
+func init() {
+	rootCmd.AddCommand(findCmd)
+
+	findCmd.Flags().String("printf", `%p\n`, "Format to print every object with, see the tokens above")
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+func TestPrintfName(t *testing.T) {
+	tests := []struct {
+		key       string
+		delimiter string
+		want      string
+	}{
+		{"logs/2020/a.gz", "/", "a.gz"},
+		{"logs::2020::a.gz", "::", "a.gz"},
+		{"logs|a.gz", "|", "a.gz"},
+		{"a.gz", "::", "a.gz"},
+		{"logs/a.gz", "", "logs/a.gz"},
+	}
+	for _, test := range tests {
+		formatter, err := parsePrintf("%f", test.delimiter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatter.Format(&s3wrapper.ListOutput{Key: test.key}); got != test.want {
+			t.Errorf("%%f of %q with delimiter %q = %q, want %q", test.key, test.delimiter, got, test.want)
+		}
+	}
+}
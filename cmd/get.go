@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/metaverse/fasts3/s3wrapper"
 	"github.com/spf13/cobra"
 )
@@ -13,7 +17,13 @@ var getCmd = &cobra.Command{
 	Use:   "get <S3 URIs>",
 	Short: "Download files from S3",
 	Long:  ``,
-	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Args: func(cmd *cobra.Command, args []string) error {
+		// the objects to retry are read from --retry-failed
+		if retryFailed, _ := cmd.Flags().GetString("retry-failed"); retryFailed != "" {
+			return validateS3URIs()(cmd, args)
+		}
+		return validateS3URIs(cobra.MinimumNArgs(1))(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		recursive, err := cmd.Flags().GetBool("recursive")
 		if err != nil {
@@ -23,7 +33,82 @@ var getCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = Get(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex, skipExisting)
+		decompress, err := cmd.Flags().GetBool("decompress")
+		if err != nil {
+			log.Fatal(err)
+		}
+		atomic, err := cmd.Flags().GetBool("atomic")
+		if err != nil {
+			log.Fatal(err)
+		}
+		noOverwrite, err := cmd.Flags().GetBool("no-overwrite")
+		if err != nil {
+			log.Fatal(err)
+		}
+		noOverwriteError, err := cmd.Flags().GetBool("no-overwrite-error")
+		if err != nil {
+			log.Fatal(err)
+		}
+		delimiterAsPathSep, err := cmd.Flags().GetBool("delimiter-as-path-sep")
+		if err != nil {
+			log.Fatal(err)
+		}
+		preserveStructureFrom, err := cmd.Flags().GetString("preserve-structure-from")
+		if err != nil {
+			log.Fatal(err)
+		}
+		checksumMode, err := checksumModeFlag(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		checksumRetries, err := cmd.Flags().GetInt("retry-on-checksum-mismatch")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if checksumRetries > 0 && !checksumMode {
+			log.Fatalf("--retry-on-checksum-mismatch requires --checksum-mode %s", checksumModeEnabled)
+		}
+		maxSize, err := maxObjectSizeFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		checksumManifest, err := cmd.Flags().GetString("checksum-manifest")
+		if err != nil {
+			log.Fatal(err)
+		}
+		failuresFile, retryFailed, err := failuresFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		progressThreshold, err := cmd.Flags().GetString("file-progress-threshold")
+		if err != nil {
+			log.Fatal(err)
+		}
+		var fileProgressThreshold int64
+		if progressThreshold != "" {
+			parsed, err := humanize.ParseBytes(progressThreshold)
+			if err != nil {
+				log.Fatalf("invalid --file-progress-threshold %q: %s", progressThreshold, err)
+			}
+			fileProgressThreshold = int64(parsed)
+		}
+		opts := GetOptions{
+			SkipExisting:          skipExisting,
+			Decompress:            decompress,
+			Atomic:                atomic,
+			NoOverwrite:           noOverwrite || noOverwriteError,
+			NoOverwriteError:      noOverwriteError,
+			DelimiterAsPathSep:    delimiterAsPathSep,
+			PreserveStructureFrom: preserveStructureFrom,
+			ChecksumMode:          checksumMode,
+			ChecksumRetries:       checksumRetries,
+			MaxSize:               maxSize,
+			ChecksumManifest:      checksumManifest,
+			FailuresFile:          failuresFile,
+			RetryFailed:           retryFailed,
+			FileProgressThreshold: fileProgressThreshold,
+		}
+		err = Get(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex, opts)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -35,29 +120,245 @@ func init() {
 
 	getCmd.Flags().BoolP("recursive", "r", false, "Get all keys for this prefix")
 	getCmd.Flags().BoolP("skip-existing", "x", false, "Skips downloading keys which already exist on the local file system")
+	getCmd.Flags().Bool("atomic", true, "Write every file to <path>.fasts3tmp and rename it once fully written, so interrupted downloads never leave partial files, --atomic=false writes the files in place")
+	getCmd.Flags().Bool("no-overwrite", false, "Skip the keys whose local file already exists with a warning instead of overwriting it, which is the default")
+	getCmd.Flags().Bool("no-overwrite-error", false, "Fail the keys whose local file already exists instead of skipping them, implies --no-overwrite")
+	getCmd.Flags().Bool("delimiter-as-path-sep", true, "Lay the files out in the local directories separated by --delimiter in their keys, --delimiter-as-path-sep=false names every file after its whole key instead, replacing the characters not allowed in file names by _")
+	getCmd.Flags().String("preserve-structure-from", "", "Remove exactly this prefix from every key to get its local path, keeping only the structure below it, instead of the full key")
+	getCmd.Flags().Bool("decompress", false, "Decompress .gz, .bz2 and .zst keys like stream does and drop their extension, by default keys are downloaded as-is")
+	addChecksumModeFlag(getCmd)
+	getCmd.Flags().Int("retry-on-checksum-mismatch", 0, "Download the objects which don't match their checksum again up to this many times before failing them, requires --checksum-mode enabled")
+	addMaxObjectSizeFlags(getCmd)
+	addFailuresFlags(getCmd)
+	getCmd.Flags().String("file-progress-threshold", "1GB", "Also report the progress of every object of at least this size on its own, with its percentage and time left, empty or 0 disables it")
+	getCmd.Flags().String("checksum-manifest", "", "Write the SHA-256 of every downloaded file to this path, in the format of sha256sum so it can be checked with sha256sum -c")
+}
+
+// checksumModeEnabled is the --checksum-mode value validating checksums
+const checksumModeEnabled = "enabled"
+
+// addChecksumModeFlag adds the --checksum-mode flag to cmd
+func addChecksumModeFlag(cmd *cobra.Command) {
+	cmd.Flags().String("checksum-mode", "", "Set to enabled to validate the additional checksum S3 stored for each object while reading it, objects without one aren't validated")
+}
+
+// checksumModeFlag tells whether --checksum-mode enables validating checksums
+func checksumModeFlag(cmd *cobra.Command) (bool, error) {
+	checksumMode, err := cmd.Flags().GetString("checksum-mode")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(checksumMode) {
+	case "":
+		return false, nil
+	case checksumModeEnabled:
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown checksum mode %q, only %s is supported", checksumMode, checksumModeEnabled)
+}
+
+// maxObjectSize is the guard against objects too large to read
+type maxObjectSize struct {
+	// size is the size of the largest object to read, 0 means no limit
+	size int64
+	// abort stops the command at the first larger object instead of
+	// skipping it
+	abort bool
+}
+
+// addMaxObjectSizeFlags adds the --max-object-size flags to cmd
+func addMaxObjectSizeFlags(cmd *cobra.Command) {
+	cmd.Flags().String("max-object-size", "", "Skip the objects larger than this size, such as 10GB, with a warning")
+	cmd.Flags().Bool("max-object-size-error", false, "Stop at the first object larger than --max-object-size instead of skipping it")
+}
+
+// maxObjectSizeFlags returns the guard set by the --max-object-size flags
+func maxObjectSizeFlags(cmd *cobra.Command) (maxObjectSize, error) {
+	var maxSize maxObjectSize
+	size, err := cmd.Flags().GetString("max-object-size")
+	if err != nil {
+		return maxSize, err
+	}
+	if maxSize.abort, err = cmd.Flags().GetBool("max-object-size-error"); err != nil {
+		return maxSize, err
+	}
+	if size != "" {
+		parsed, err := humanize.ParseBytes(size)
+		if err != nil {
+			return maxSize, fmt.Errorf("invalid --max-object-size %q: %s", size, err)
+		}
+		maxSize.size = int64(parsed)
+	}
+	return maxSize, nil
+}
+
+// filter sends the objects of listCh which aren't larger than the guard to
+// the returned channel. Larger objects are skipped with a warning and tallied
+// by results or, when aborting, end the channel, the returned func then
+// returns the error to fail the command with.
+func (m maxObjectSize) filter(listCh chan *s3wrapper.ListOutput, results *emitter) (chan *s3wrapper.ListOutput, func() error) {
+	if m.size <= 0 {
+		return listCh, func() error { return nil }
+	}
+
+	filtered := make(chan *s3wrapper.ListOutput, 10000)
+	var abortErr error
+	go func() {
+		defer close(filtered)
+		for itm := range listCh {
+			// the rest of the listing is drained so its goroutines end
+			if abortErr != nil {
+				continue
+			}
+			if itm.IsPrefix || itm.Size <= m.size {
+				filtered <- itm
+				continue
+			}
+			if m.abort {
+				abortErr = fmt.Errorf("%s is %s, larger than --max-object-size %s", itm.FullKey, humanize.Bytes(uint64(itm.Size)), humanize.Bytes(uint64(m.size)))
+				continue
+			}
+			logger.Printf("Skipping %s, it is %s, larger than --max-object-size %s", itm.FullKey, humanize.Bytes(uint64(itm.Size)), humanize.Bytes(uint64(m.size)))
+			results.Skip()
+		}
+	}()
+	// abortErr is only read once filtered was closed and drained
+	return filtered, func() error { return abortErr }
+}
+
+// formatChecksumLine formats a line of a sha256sum checksums file, paths
+// containing a backslash or a newline are escaped and the line prefixed with
+// a backslash like sha256sum does
+func formatChecksumLine(hash string, path string) string {
+	if strings.ContainsAny(path, "\\\n") {
+		path = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(path)
+		return fmt.Sprintf("\\%s  %s\n", hash, path)
+	}
+	return fmt.Sprintf("%s  %s\n", hash, path)
+}
+
+// GetOptions are the options of Get
+type GetOptions struct {
+	// SkipExisting skips the keys whose file already exists
+	SkipExisting bool
+	// Decompress decompresses compressed keys while downloading them
+	Decompress bool
+	// Atomic writes every file to a temporary file which is renamed to the
+	// file once complete
+	Atomic bool
+	// NoOverwrite skips the keys whose file exists with a warning, or fails
+	// them when NoOverwriteError is set
+	NoOverwrite      bool
+	NoOverwriteError bool
+	// DelimiterAsPathSep lays the files out in the local directories
+	// separated by the delimiter in their keys, instead of naming them after
+	// their whole key
+	DelimiterAsPathSep bool
+	// PreserveStructureFrom is removed from every key to get its local path
+	// when non empty
+	PreserveStructureFrom string
+	// ChecksumMode validates the additional checksum of each object and
+	// removes the files which don't match it, which are downloaded again up
+	// to ChecksumRetries times
+	ChecksumMode    bool
+	ChecksumRetries int
+	// MaxSize skips or stops at the objects larger than its size
+	MaxSize maxObjectSize
+	// ChecksumManifest is where the SHA-256 of every downloaded file is
+	// written in the format of sha256sum when non empty
+	ChecksumManifest string
+	// FailuresFile is where the objects which failed are written when non
+	// empty, only the objects in RetryFailed are downloaded instead of
+	// listing the uris when it is non empty
+	FailuresFile string
+	RetryFailed  string
+	// FileProgressThreshold is the size from which the progress of an object
+	// is also reported on its own, 0 disables it
+	FileProgressThreshold int64
 }
 
 // Get downloads a file to the local filesystem using svc, s3Uris specifies the
 // S3 Prefixes/Keys to download, recurse tells whether or not to download
 // everything under s3Uris, delimiter tells the delimiter to use when listing,
 // searchDepth determines how many prefixes to list before parallelizing list
-// calls, keyRegex is a regex filter on Keys, skipExisting skips files which
-// already exist on the filesystem.
-func Get(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, skipExisting bool) error {
-	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
-	if err != nil {
-		return err
+// calls, keyRegex is a regex filter on Keys, opts are the options of the
+// downloads
+func Get(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, opts GetOptions) error {
+	var listCh chan *s3wrapper.ListOutput
+	var err error
+	if opts.RetryFailed != "" {
+		listCh, s3Uris, err = retryFailedKeys(opts.RetryFailed, s3Uris)
+		if err != nil {
+			return err
+		}
+		if len(s3Uris) == 0 {
+			fmt.Fprintf(statusOut, "Nothing to retry in %s\n", opts.RetryFailed)
+			return nil
+		}
+	} else {
+		listCh, err = Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+		if err != nil {
+			return err
+		}
 	}
 
-	wrap, err := s3wrapper.New(svc, maxParallel).WithRegionFrom(s3Uris[0])
+	failures := &s3wrapper.Failures{}
+	wrap, err := newS3Wrapper(svc).WithChecksumMode(opts.ChecksumMode).WithFailures(failures).WithRegionFrom(s3Uris[0])
 	if err != nil {
 		return err
 	}
 
-	downloadedFiles := wrap.GetAll(listCh, skipExisting)
+	var manifest *os.File
+	if opts.ChecksumManifest != "" {
+		if manifest, err = os.Create(opts.ChecksumManifest); err != nil {
+			return err
+		}
+		defer manifest.Close()
+	}
+
+	results := newEmitter("get", statusOut)
+	stopProgress := reportProgress(results)
+	listCh, aborted := opts.MaxSize.filter(listCh, results)
+	pathDelimiter := ""
+	if opts.DelimiterAsPathSep {
+		pathDelimiter = delimiter
+	}
+	getOpts := s3wrapper.GetOptions{
+		SkipExisting:     opts.SkipExisting,
+		Decompress:       opts.Decompress,
+		HashFiles:        manifest != nil,
+		Atomic:           opts.Atomic,
+		NoOverwrite:      opts.NoOverwrite,
+		NoOverwriteError: opts.NoOverwriteError,
+		Progress:         fileProgressOver(opts.FileProgressThreshold),
+		PathDelimiter:    pathDelimiter,
+		TrimPrefix:       opts.PreserveStructureFrom,
+		ChecksumRetries:  opts.ChecksumRetries,
+	}
+	downloadedFiles := wrap.GetAll(listCh, getOpts)
 	for file := range downloadedFiles {
-		log.Printf("Downloaded %s -> %s\n", file.FullKey, file.Key)
+		if manifest != nil {
+			if _, err := fmt.Fprint(manifest, formatChecksumLine(file.SHA256, file.Key)); err != nil {
+				return fmt.Errorf("unable to write to %s: %s", opts.ChecksumManifest, err)
+			}
+		}
+		results.Result("download", file, file.Key, fmt.Sprintf("Downloaded %s -> %s\n", file.FullKey, file.Key))
 	}
+	stopProgress()
+	results.Summary()
 
-	return nil
+	if manifest != nil {
+		if err := manifest.Close(); err != nil {
+			return fmt.Errorf("unable to write to %s: %s", opts.ChecksumManifest, err)
+		}
+	}
+	if opts.FailuresFile != "" {
+		if err := writeFailures(opts.FailuresFile, failures); err != nil {
+			return err
+		}
+	}
+	if err := aborted(); err != nil {
+		return err
+	}
+	return wrap.Err()
 }
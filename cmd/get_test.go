@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+)
+
+// gzipped returns data compressed by gzip
+func gzipped(t *testing.T, data string) string {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestGetDelimiterAsPathSep(t *testing.T) {
+	tests := []struct {
+		name               string
+		delimiterAsPathSep bool
+		want               map[string]string
+	}{
+		{"split on delimiter", true, map[string]string{
+			"logs/2020/a.txt": "a",
+			"logs/2020/b.txt": "b",
+			"logs/c/d.txt":    "d",
+		}},
+		// without it keys are file names, only the slash is replaced
+		{"whole keys", false, map[string]string{
+			"logs|2020|a.txt": "a",
+			"logs|2020|b.txt": "b",
+			"logs|c_d.txt":    "d",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			_, svc := newFakeS3(t, map[string]string{
+				"bk/logs|2020|a.txt": "a",
+				"bk/logs|2020|b.txt": "b",
+				"bk/logs|c/d.txt":    "d",
+			})
+			dir := t.TempDir()
+			t.Chdir(dir)
+			err := Get(svc, []string{"s3://bk/logs|"}, true, "|", 0, "", GetOptions{DelimiterAsPathSep: test.delimiterAsPathSep})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readFiles(t, dir); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetDecompress(t *testing.T) {
+	gz := gzipped(t, "hello\n")
+	objects := map[string]string{
+		"bk/logs/a.json.gz": gz,
+		"bk/logs/b.txt":     "plain\n",
+		"bk/logs/FILE.GZ":   gz,
+	}
+	tests := []struct {
+		name       string
+		decompress bool
+		want       map[string]string
+	}{
+		{"raw", false, map[string]string{
+			"logs/a.json.gz": gz,
+			"logs/b.txt":     "plain\n",
+			"logs/FILE.GZ":   gz,
+		}},
+		{"decompressed", true, map[string]string{
+			"logs/a.json": "hello\n",
+			"logs/b.txt":  "plain\n",
+			"logs/FILE":   "hello\n",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			_, svc := newFakeS3(t, objects)
+			dir := t.TempDir()
+			t.Chdir(dir)
+			err := Get(svc, []string{"s3://bk/logs/"}, true, "/", 0, "", GetOptions{Decompress: test.decompress, DelimiterAsPathSep: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readFiles(t, dir); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+// keyGlob is a pattern of --include or --exclude
+type keyGlob struct {
+	pattern string
+	exclude bool
+}
+
+// keyGlobs holds the --include and --exclude patterns in the order they were
+// given, both flags append to it so that later patterns override earlier ones
+var keyGlobs []keyGlob
+
+// keyGlobFlag is the value of --include, or of --exclude when exclude is set
+type keyGlobFlag struct {
+	exclude bool
+}
+
+func (f *keyGlobFlag) Set(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+	keyGlobs = append(keyGlobs, keyGlob{pattern: pattern, exclude: f.exclude})
+	return nil
+}
+
+func (f *keyGlobFlag) String() string {
+	patterns := make([]string, 0, len(keyGlobs))
+	for _, glob := range keyGlobs {
+		if glob.exclude == f.exclude {
+			patterns = append(patterns, glob.pattern)
+		}
+	}
+	return "[" + strings.Join(patterns, ",") + "]"
+}
+
+func (f *keyGlobFlag) Type() string {
+	return "stringArray"
+}
+
+// matchesGlobs tells whether the key of listOutput passes --include and
+// --exclude, the last pattern matching it decides and keys no pattern
+// matches are included. Prefixes always pass.
+func matchesGlobs(listOutput *s3wrapper.ListOutput) bool {
+	if listOutput.IsPrefix {
+		return true
+	}
+	included := true
+	for _, glob := range keyGlobs {
+		// the patterns were validated when set
+		if matched, _ := path.Match(glob.pattern, listOutput.Key); matched {
+			included = !glob.exclude
+		}
+	}
+	return included
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+// setGlobs sets keyGlobs as --exclude and --include would, "-" patterns are
+// excludes and "+" patterns includes
+func setGlobs(t *testing.T, patterns ...string) {
+	keyGlobs = nil
+	for _, pattern := range patterns {
+		flag := &keyGlobFlag{exclude: pattern[0] == '-'}
+		if err := flag.Set(pattern[1:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatchesGlobs(t *testing.T) {
+	keys := []string{"a.csv", "a.log", "tmp/b.csv", "tmp/b.log", "tmp/keep/c.csv"}
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"no pattern", nil, keys},
+		{"exclude", []string{"-tmp/*"}, []string{"a.csv", "a.log", "tmp/keep/c.csv"}},
+		{"star doesn't match slashes", []string{"-*.csv"}, []string{"a.log", "tmp/b.csv", "tmp/b.log", "tmp/keep/c.csv"}},
+		{"include after exclude", []string{"-*", "-*/*", "+*.csv", "+tmp/*.csv"}, []string{"a.csv", "tmp/b.csv", "tmp/keep/c.csv"}},
+		// the last matching pattern decides, so an exclude after an include
+		// wins over it
+		{"exclude after include", []string{"+*.csv", "-a.*"}, []string{"tmp/b.csv", "tmp/b.log", "tmp/keep/c.csv"}},
+		{"include before exclude", []string{"+tmp/b.csv", "-tmp/*"}, []string{"a.csv", "a.log", "tmp/keep/c.csv"}},
+		{"exclude before include", []string{"-tmp/*", "+tmp/b.csv"}, []string{"a.csv", "a.log", "tmp/b.csv", "tmp/keep/c.csv"}},
+		{"character class", []string{"-tmp/[a-b].*"}, []string{"a.csv", "a.log", "tmp/keep/c.csv"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			setGlobs(t, test.patterns...)
+			var got []string
+			for _, key := range keys {
+				if matchesGlobs(&s3wrapper.ListOutput{Key: key, FullKey: s3wrapper.FormatS3Uri("bk", key)}) {
+					got = append(got, key)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+	withCleanGlobals(t)
+	setGlobs(t, "-*")
+	if !matchesGlobs(&s3wrapper.ListOutput{IsPrefix: true, Key: "tmp/"}) {
+		t.Error("excluded a prefix")
+	}
+}
+
+func TestInvalidGlob(t *testing.T) {
+	withCleanGlobals(t)
+	if err := (&keyGlobFlag{exclude: true}).Set("tmp/["); err == nil {
+		t.Error("got no error")
+	}
+	if len(keyGlobs) != 0 {
+		t.Errorf("got %v, want no pattern", keyGlobs)
+	}
+}
+
+func TestLsGlobsAndKeyRegex(t *testing.T) {
+	withCleanGlobals(t)
+	_, svc := newFakeS3(t, map[string]string{
+		"bk/a.csv":     "a",
+		"bk/a.log":     "a",
+		"bk/b.csv":     "b",
+		"bk/tmp/c.csv": "c",
+	})
+	setGlobs(t, "-tmp/*")
+	// a key must match --key-regex and pass the globs to be listed
+	listCh, err := Ls(svc, []string{"s3://bk/"}, true, "/", 0, `\.csv$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for itm := range listCh {
+		got = append(got, itm.FullKey)
+	}
+	sort.Strings(got)
+	if want := []string{"s3://bk/a.csv", "s3://bk/b.csv"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spf13/cobra"
+)
+
+// grepCmd represents the grep command
+var grepCmd = &cobra.Command{
+	Use:   "grep <pattern> <S3 URIs>",
+	Short: "Print the lines of S3 objects matching a pattern",
+	Long:  ``,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("requires a pattern and at least one S3 uri")
+		}
+		if _, err := regexp.Compile(args[0]); err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", args[0], err)
+		}
+		return validateS3URIs()(cmd, args[1:])
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		count, err := cmd.Flags().GetBool("count")
+		if err != nil {
+			log.Fatal(err)
+		}
+		filesWithMatches, err := cmd.Flags().GetBool("files-with-matches")
+		if err != nil {
+			log.Fatal(err)
+		}
+		lineNumbers, err := cmd.Flags().GetBool("line-numbers")
+		if err != nil {
+			log.Fatal(err)
+		}
+		afterContext, err := cmd.Flags().GetInt("after-context")
+		if err != nil {
+			log.Fatal(err)
+		}
+		beforeContext, err := cmd.Flags().GetInt("before-context")
+		if err != nil {
+			log.Fatal(err)
+		}
+		contextLines, err := cmd.Flags().GetInt("context")
+		if err != nil {
+			log.Fatal(err)
+		}
+		// -A and -B take precedence over -C like they do with grep
+		if !cmd.Flags().Changed("after-context") {
+			afterContext = contextLines
+		}
+		if !cmd.Flags().Changed("before-context") {
+			beforeContext = contextLines
+		}
+		if afterContext < 0 || beforeContext < 0 {
+			log.Fatal("context lines can't be negative")
+		}
+		if count && filesWithMatches {
+			log.Fatal("--count can't be used with --files-with-matches")
+		}
+		err = Grep(GetS3Client(), args[1:], recursive, delimiter, searchDepth, keyRegex, args[0], count, filesWithMatches, lineNumbers, beforeContext, afterContext)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// Grep prints the lines of S3 objects matching pattern using svc, s3Uris is a list of prefixes/keys, recurse tells
+// whether or not to search everything under the prefixes, delimiter tells the delimiter to use when listing,
+// searchDepth determines the number of prefixes to list before parallelizing list calls, keyRegex is a regex filter on
+// keys, count prints the number of matching lines of each object instead of the lines, filesWithMatches prints only the
+// objects with at least one match and stops reading each of them at its first match, lineNumbers prefixes each line
+// with its line number within its object, beforeContext and afterContext are the number of lines to print before and
+// after each matching line
+func Grep(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, pattern string, count bool, filesWithMatches bool, lineNumbers bool, beforeContext int, afterContext int) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	wrap, err := newS3Wrapper(svc).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("grep", dataOut)
+	collectLines := !count && !filesWithMatches
+	hasContext := beforeContext > 0 || afterContext > 0
+	for obj := range wrap.Grep(listCh, re, collectLines, filesWithMatches, beforeContext, afterContext) {
+		var text strings.Builder
+		// the context of different objects is separated too
+		if hasContext && collectLines && results.objects > 0 {
+			text.WriteString("--\n")
+		}
+		switch {
+		case filesWithMatches:
+			fmt.Fprintf(&text, "%s\n", obj.FullKey)
+		case count:
+			fmt.Fprintf(&text, "%s: %d\n", obj.FullKey, obj.Count)
+		default:
+			var prevNumber int64
+			for _, line := range obj.Lines {
+				// groups of context which aren't adjacent are separated
+				if hasContext && prevNumber > 0 && line.Number != prevNumber+1 {
+					text.WriteString("--\n")
+				}
+				prevNumber = line.Number
+				// like grep, matches are separated from their prefix by : and
+				// context lines by -
+				sep := ":"
+				if line.Context {
+					sep = "-"
+				}
+				if lineNumbers {
+					fmt.Fprintf(&text, "%s%s%d%s%s", obj.FullKey, sep, line.Number, sep, line.Text)
+				} else {
+					fmt.Fprintf(&text, "%s%s%s", obj.FullKey, sep, line.Text)
+				}
+				if !strings.HasSuffix(line.Text, "\n") {
+					text.WriteString("\n")
+				}
+			}
+		}
+		results.GrepResult("match", obj, text.String())
+	}
+	results.Summary()
+	return wrap.Err()
+}
+
+func init() {
+	rootCmd.AddCommand(grepCmd)
+
+	grepCmd.Flags().BoolP("recursive", "r", false, "Search all keys for this prefix")
+	grepCmd.Flags().BoolP("count", "c", false, "Print the number of matching lines of each object with matches instead of the lines")
+	grepCmd.Flags().BoolP("files-with-matches", "l", false, "Print only the objects with at least one match, reading each only up to its first match")
+	grepCmd.Flags().BoolP("line-numbers", "n", false, "Prefix each line with its line number within its object")
+	grepCmd.Flags().IntP("after-context", "A", 0, "Print this many lines after each match")
+	grepCmd.Flags().IntP("before-context", "B", 0, "Print this many lines before each match")
+	grepCmd.Flags().IntP("context", "C", 0, "Print this many lines before and after each match")
+}
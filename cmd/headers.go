@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// addHeaderFlags adds the flags setting the response headers of written
+// objects to cmd
+func addHeaderFlags(cmd *cobra.Command) {
+	cmd.Flags().String("cache-control", "", "Cache-Control header of the written objects")
+	cmd.Flags().String("content-disposition", "", "Content-Disposition header of the written objects")
+	cmd.Flags().String("content-encoding", "", "Content-Encoding header of the written objects")
+	cmd.Flags().String("expires", "", "Expires header of the written objects, either a RFC1123 date or a duration from now such as 24h")
+}
+
+// headerFlags reads the flags added by addHeaderFlags
+func headerFlags(cmd *cobra.Command) (s3wrapper.Headers, error) {
+	var headers s3wrapper.Headers
+	var err error
+	if headers.CacheControl, err = cmd.Flags().GetString("cache-control"); err != nil {
+		return headers, err
+	}
+	if headers.ContentDisposition, err = cmd.Flags().GetString("content-disposition"); err != nil {
+		return headers, err
+	}
+	if headers.ContentEncoding, err = cmd.Flags().GetString("content-encoding"); err != nil {
+		return headers, err
+	}
+	expires, err := cmd.Flags().GetString("expires")
+	if err != nil {
+		return headers, err
+	}
+	if expires != "" {
+		if headers.Expires, err = parseExpires(expires, time.Now()); err != nil {
+			return headers, err
+		}
+	}
+	return headers, nil
+}
+
+// parseExpires parses an --expires value, which is either a RFC1123 date or
+// a duration from now
+func parseExpires(expires string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC1123, expires); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(expires); err == nil && d >= 0 {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --expires %q, expected a RFC1123 date such as %q or a duration such as 24h", expires, now.UTC().Format(time.RFC1123))
+}
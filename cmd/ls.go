@@ -1,26 +1,58 @@
 package cmd
 
 import (
+	"container/heap"
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/metaverse/fasts3/s3wrapper"
 	"github.com/spf13/cobra"
 )
 
+// withOwner, ownerID and ownerName are set by the owner flags of ls, Ls reads
+// them so that --limit only counts the objects which match the owner filters
+var (
+	withOwner bool
+	ownerID   string
+	ownerName string
+)
+
+// allBuckets is set by --all-buckets, Ls then lists the top level of every
+// bucket matching a s3://<bucket-prefix> uri instead of the buckets themselves
+var allBuckets bool
+
+// withETag and etagFilter are set by the ETag flags of ls, Ls reads etagFilter
+// so that --limit only counts the objects with that ETag
+var (
+	withETag   bool
+	etagFilter string
+)
+
 // lsCmd represents the ls command
 var lsCmd = &cobra.Command{
 	Use:   "ls <S3 URIs>",
 	Short: "List S3 prefixes",
 	Long:  ``,
-	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Args: func(cmd *cobra.Command, args []string) error {
+		// uris read from stdin are validated once read
+		if readsStdin(cmd, args) {
+			return nil
+		}
+		return validateS3URIs(cobra.MinimumNArgs(1))(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		args, err := withStdinS3URIs(cmd, args, os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
 		recursive, err := cmd.Flags().GetBool("recursive")
 		if err != nil {
 			log.Fatal(err)
@@ -33,45 +65,555 @@ var lsCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
+		restoreStatus, err := cmd.Flags().GetBool("restore-status")
+		if err != nil {
+			log.Fatal(err)
+		}
+		sortBy, err := cmd.Flags().GetString("sort")
+		if err != nil {
+			log.Fatal(err)
+		}
+		reverse, err := cmd.Flags().GetBool("reverse")
+		if err != nil {
+			log.Fatal(err)
+		}
+		sortLimit, err := cmd.Flags().GetInt("sort-limit")
+		if err != nil {
+			log.Fatal(err)
+		}
+		follow, err := cmd.Flags().GetBool("follow")
+		if err != nil {
+			log.Fatal(err)
+		}
+		pollInterval, err := cmd.Flags().GetDuration("poll-interval")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if follow && sortBy != "" {
+			log.Fatal("--sort can't be used with --follow")
+		}
+		newest, err := cmd.Flags().GetInt("newest")
+		if err != nil {
+			log.Fatal(err)
+		}
+		oldest, err := cmd.Flags().GetInt("oldest")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if newest > 0 && oldest > 0 {
+			log.Fatal("--newest and --oldest can't be used together")
+		}
+		if (newest > 0 || oldest > 0) && (sortBy != "" || follow) {
+			log.Fatal("--newest and --oldest can't be used with --sort or --follow")
+		}
+		if sortBy != "" && sortBy != sortByName && sortBy != sortBySize && sortBy != sortByTime {
+			log.Fatalf("unknown sort %q, expected one of %s, %s or %s", sortBy, sortByName, sortBySize, sortByTime)
+		}
 
-		listChan, err := Ls(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex)
+		objectsOnly, err := cmd.Flags().GetBool("objects-only")
+		if err != nil {
+			log.Fatal(err)
+		}
+		prefixesOnly, err := cmd.Flags().GetBool("prefixes-only")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if objectsOnly && prefixesOnly {
+			log.Fatal("--objects-only and --prefixes-only can't be used together")
+		}
+		findDuplicates, err := cmd.Flags().GetBool("find-duplicates")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if findDuplicates && (follow || sortBy != "" || newest > 0 || oldest > 0) {
+			log.Fatal("--find-duplicates can't be used with --follow, --sort, --newest or --oldest")
+		}
+		jsonArray, err := cmd.Flags().GetBool("json-array")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if jsonArray && (output == outputJSON || follow) {
+			log.Fatal("--json-array can't be used with --output json or --follow")
+		}
+		page, err := cmd.Flags().GetBool("page")
 		if err != nil {
 			log.Fatal(err)
 		}
+		pageSize, err := cmd.Flags().GetInt("page-size")
+		if err != nil {
+			log.Fatal(err)
+		}
+		stopPager := func() {}
+		if page {
+			// quitting the pager stops the listing like an interrupt would
+			var quit context.CancelFunc
+			ctx, quit = context.WithCancel(ctx)
+			stopPager = startPager(pageSize, quit)
+		}
 
-		for listOutput := range listChan {
-			if listOutput.IsPrefix {
-				fmt.Printf("%10s %s\n", "DIR", listOutput.FullKey)
-			} else {
-				var size string
-				if humanReadable {
-					size = fmt.Sprintf("%10s", humanize.Bytes(uint64(listOutput.Size)))
-				} else {
-					size = fmt.Sprintf("%10d", listOutput.Size)
+		svc := GetS3Client()
+		var listChan chan *s3wrapper.ListOutput
+		if follow {
+			listChan = Follow(svc, args, recursive, delimiter, searchDepth, keyRegex, pollInterval)
+		} else {
+			listChan, err = Ls(svc, args, recursive, delimiter, searchDepth, keyRegex)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		var entries chan *s3wrapper.StatOutput
+		if restoreStatus {
+			wrap, err := newS3Wrapper(svc).WithRegionFrom(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			entries = wrap.StatAll(listChan)
+		} else {
+			entries = make(chan *s3wrapper.StatOutput, 10000)
+			go func() {
+				defer close(entries)
+				for listOutput := range listChan {
+					entries <- &s3wrapper.StatOutput{ListOutput: listOutput}
 				}
-				date := ""
-				if includeDates {
-					date = " " + (listOutput.LastModified).Format("2006-01-02T15:04:05")
+			}()
+		}
+		if newest > 0 {
+			entries = selectByTime(entries, newest, true)
+		} else if oldest > 0 {
+			entries = selectByTime(entries, oldest, false)
+		}
+		if sortBy != "" {
+			entries, err = sortEntries(entries, sortBy, reverse, sortLimit)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if findDuplicates {
+			entries = duplicateEntries(entries)
+		}
+
+		results := newEmitter("ls", dataOut)
+		if jsonArray {
+			results.JSONArray()
+		}
+		previousETag := ""
+		for entry := range entries {
+			if (objectsOnly && entry.IsPrefix) || (prefixesOnly && !entry.IsPrefix) {
+				continue
+			}
+			// prefixes get the columns of objects, blank, so keys line up
+			columns := make([]lsColumn, 0, 3)
+			if withETag || findDuplicates {
+				columns = append(columns, lsColumn{value: entry.ListOutput.ETag, width: etagWidth})
+			}
+			if fetchesOwner() {
+				owner := formatListOwner(entry.ListOutput)
+				if owner == "" {
+					owner = "-"
 				}
-				fmt.Printf("%s%s %s\n", size, date, listOutput.FullKey)
+				columns = append(columns, lsColumn{value: owner, width: ownerWidth})
+			}
+			if restoreStatus {
+				columns = append(columns, lsColumn{value: formatRestore(entry), width: restoreWidth})
+			}
+			line := formatListOutput(entry.ListOutput, humanReadable, includeDates, columns)
+			// groups of duplicates are separated by a blank line
+			if findDuplicates && previousETag != "" && entry.ListOutput.ETag != previousETag {
+				line = "\n" + line
 			}
+			previousETag = entry.ListOutput.ETag
+			if restoreStatus {
+				results.StatResult("list", entry, line)
+			} else {
+				results.Result("list", entry.ListOutput, "", line)
+			}
+		}
+		results.Summary()
+		stopPager()
+		// the footer goes to stderr so piped listings only hold the keys
+		if !results.json {
+			objects, bytes := results.totals()
+			size := humanize.Comma(bytes) + " bytes"
+			if humanReadable {
+				size = humanize.Bytes(uint64(bytes))
+			}
+			fmt.Fprintf(statusOut, "Total: %s objects, %s\n", humanize.Comma(objects), size)
+		}
+		if err := wrapperErrs.Err(); err != nil {
+			log.Fatal(err)
 		}
 	},
 }
 
+const (
+	sortByName = "name"
+	sortBySize = "size"
+	sortByTime = "time"
+)
+
+// sortEntries buffers the entries and sends them back sorted by name, size
+// or time, an error is returned when there are more than sortLimit entries
+func sortEntries(entries chan *s3wrapper.StatOutput, sortBy string, reverse bool, sortLimit int) (chan *s3wrapper.StatOutput, error) {
+	buffered := make([]*s3wrapper.StatOutput, 0, 1000)
+	for entry := range entries {
+		if len(buffered) >= sortLimit {
+			return nil, fmt.Errorf("more than %d entries to sort, narrow the listing or raise --sort-limit", sortLimit)
+		}
+		buffered = append(buffered, entry)
+	}
+
+	less := func(i, j int) bool {
+		a, b := buffered[i], buffered[j]
+		if reverse {
+			a, b = b, a
+		}
+		switch sortBy {
+		case sortBySize:
+			return a.Size < b.Size
+		case sortByTime:
+			return a.LastModified.Before(b.LastModified)
+		default:
+			return a.FullKey < b.FullKey
+		}
+	}
+	sort.SliceStable(buffered, less)
+
+	sorted := make(chan *s3wrapper.StatOutput, len(buffered))
+	for _, entry := range buffered {
+		sorted <- entry
+	}
+	close(sorted)
+	return sorted, nil
+}
+
+// timeHeap is a heap of entries whose root is the one to drop first when
+// selecting the newest (the oldest entry) or oldest (the newest entry)
+type timeHeap struct {
+	entries []*s3wrapper.StatOutput
+	newest  bool
+}
+
+func (h *timeHeap) Len() int { return len(h.entries) }
+
+func (h *timeHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if !h.newest {
+		a, b = b, a
+	}
+	if a.LastModified.Equal(b.LastModified) {
+		return a.FullKey > b.FullKey
+	}
+	return a.LastModified.Before(b.LastModified)
+}
+
+func (h *timeHeap) Swap(i, j int) { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+
+func (h *timeHeap) Push(x interface{}) { h.entries = append(h.entries, x.(*s3wrapper.StatOutput)) }
+
+func (h *timeHeap) Pop() interface{} {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// selectByTime sends back the n newest objects of entries, newest first, or
+// the n oldest, oldest first, only n entries are ever buffered and prefixes
+// are dropped
+func selectByTime(entries chan *s3wrapper.StatOutput, n int, newest bool) chan *s3wrapper.StatOutput {
+	selected := make(chan *s3wrapper.StatOutput, n)
+	go func() {
+		defer close(selected)
+		h := &timeHeap{entries: make([]*s3wrapper.StatOutput, 0, n+1), newest: newest}
+		for entry := range entries {
+			if entry.IsPrefix {
+				continue
+			}
+			heap.Push(h, entry)
+			if h.Len() > n {
+				heap.Pop(h)
+			}
+		}
+		// the heap pops the entry to drop first, so the selection comes
+		// out in reverse
+		ordered := make([]*s3wrapper.StatOutput, h.Len())
+		for i := len(ordered) - 1; i >= 0; i-- {
+			ordered[i] = heap.Pop(h).(*s3wrapper.StatOutput)
+		}
+		for _, entry := range ordered {
+			selected <- entry
+		}
+	}()
+	return selected
+}
+
+// duplicateEntries buffers the objects of entries by ETag and sends back the
+// ones sharing their ETag with other objects, grouped by ETag. Groups come in
+// the order of their first key and keys in the order they were listed,
+// prefixes and objects without an ETag are dropped
+func duplicateEntries(entries chan *s3wrapper.StatOutput) chan *s3wrapper.StatOutput {
+	duplicates := make(chan *s3wrapper.StatOutput, 10000)
+	go func() {
+		defer close(duplicates)
+		groups := make(map[string][]*s3wrapper.StatOutput)
+		for entry := range entries {
+			if entry.IsPrefix || entry.ListOutput.ETag == "" {
+				continue
+			}
+			groups[entry.ListOutput.ETag] = append(groups[entry.ListOutput.ETag], entry)
+		}
+		ordered := make([][]*s3wrapper.StatOutput, 0)
+		for _, group := range groups {
+			if len(group) > 1 {
+				ordered = append(ordered, group)
+			}
+		}
+		sort.Slice(ordered, func(i, j int) bool { return ordered[i][0].FullKey < ordered[j][0].FullKey })
+		for _, group := range ordered {
+			for _, entry := range group {
+				duplicates <- entry
+			}
+		}
+	}()
+	return duplicates
+}
+
+// Widths of the columns of the ls output, they are fixed rather than fitted
+// to the listing so it is still written as it is listed, longer values only
+// push their key to the right
+const (
+	// sizeWidth fits the 5TB of the largest objects in bytes
+	sizeWidth      = 13
+	humanSizeWidth = 10
+	// etagWidth fits MD5s and the ETags of uploads of up to 99 parts
+	etagWidth    = 35
+	ownerWidth   = 20
+	restoreWidth = 34
+)
+
+// lsColumn is an optional column of the ls output, left aligned in width
+type lsColumn struct {
+	value string
+	width int
+}
+
+// formatListOutput formats a line of the ls output, the size, date and
+// columns are padded to their width so keys line up, the columns are added
+// before the key and are blank for prefixes
+func formatListOutput(listOutput *s3wrapper.ListOutput, humanReadable bool, includeDates bool, columns []lsColumn) string {
+	width := sizeWidth
+	if humanReadable {
+		width = humanSizeWidth
+	}
+	var line strings.Builder
+	switch {
+	case listOutput.IsPrefix:
+		fmt.Fprintf(&line, "%*s", width, "DIR")
+	case humanReadable:
+		fmt.Fprintf(&line, "%*s", width, humanize.Bytes(uint64(listOutput.Size)))
+	default:
+		fmt.Fprintf(&line, "%*d", width, listOutput.Size)
+	}
+	if includeDates {
+		date := ""
+		if !listOutput.IsPrefix {
+			date = listOutput.LastModified.Format("2006-01-02T15:04:05")
+		}
+		fmt.Fprintf(&line, " %-19s", date)
+	}
+	for _, column := range columns {
+		value := column.value
+		if listOutput.IsPrefix {
+			value = ""
+		}
+		fmt.Fprintf(&line, " %-*s", column.width, value)
+	}
+	fmt.Fprintf(&line, " %s\n", listOutput.FullKey)
+	return line.String()
+}
+
+// keyDepth returns the number of delimiter separated segments the key of
+// listOutput has below the longest of queries it is under, prefixes and keys
+// listed without a delimiter are always 1 deep
+func keyDepth(listOutput *s3wrapper.ListOutput, queries []string, delimiter string) int {
+	if listOutput.IsPrefix || delimiter == "" {
+		return 1
+	}
+	return strings.Count(relativeToQueries(listOutput, queries, delimiter), delimiter) + 1
+}
+
+// relativeToQueries returns the key of listOutput relative to the longest of
+// queries it is under, or its whole key when it is under none
+func relativeToQueries(listOutput *s3wrapper.ListOutput, queries []string, delimiter string) string {
+	relative := listOutput.Key
+	matched := -1
+	for _, query := range queries {
+		bucket, prefix := s3wrapper.ParseS3Uri(query)
+		// uris without a key part, such as s3://buck, match whole buckets
+		if (bucket == listOutput.Bucket || prefix == "" && strings.HasPrefix(listOutput.Bucket, bucket)) &&
+			strings.HasPrefix(listOutput.Key, prefix) && len(prefix) > matched {
+			relative, matched = listOutput.Key[len(prefix):], len(prefix)
+		}
+	}
+	// a query without a trailing delimiter, such as s3://buck/logs, is the
+	// parent of the keys under logs/
+	return strings.TrimPrefix(relative, delimiter)
+}
+
+// isExcluded tells whether listOutput is under one of --exclude-prefix, which
+// are either full S3 uris or paths relative to the queries
+func isExcluded(listOutput *s3wrapper.ListOutput, queries []string, delimiter string) bool {
+	if len(excludePrefixes) == 0 {
+		return false
+	}
+	relative := relativeToQueries(listOutput, queries, delimiter)
+	for _, exclude := range excludePrefixes {
+		if strings.HasPrefix(exclude, "s3://") {
+			if strings.HasPrefix(listOutput.FullKey, exclude) {
+				return true
+			}
+		} else if strings.HasPrefix(relative, exclude) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchesOwner tells whether ls needs the owners of objects, either to print
+// them or to filter on them
+func fetchesOwner() bool {
+	return withOwner || ownerID != "" || ownerName != ""
+}
+
+// formatListOwner returns the display name of the owner of listOutput, or its
+// ID when the owner has no display name
+func formatListOwner(listOutput *s3wrapper.ListOutput) string {
+	if listOutput.OwnerName != "" {
+		return listOutput.OwnerName
+	}
+	return listOutput.OwnerID
+}
+
+// matchesETag tells whether listOutput passes the --etag filter, prefixes
+// have no ETag and always pass
+func matchesETag(listOutput *s3wrapper.ListOutput) bool {
+	if listOutput.IsPrefix || etagFilter == "" {
+		return true
+	}
+	return listOutput.ETag == strings.Trim(etagFilter, `"`)
+}
+
+// matchesOwner tells whether listOutput passes the --owner-id and
+// --owner-name filters, prefixes have no owner and always pass
+func matchesOwner(listOutput *s3wrapper.ListOutput) bool {
+	if listOutput.IsPrefix {
+		return true
+	}
+	return (ownerID == "" || listOutput.OwnerID == ownerID) &&
+		(ownerName == "" || listOutput.OwnerName == ownerName)
+}
+
 // Ls lists S3 keys and prefixes using svc, s3Uris specifies which S3 prefixes/keys to list, recursive tells whether or not to list everything
 // under s3Uris, delimiter tells which character to use as the delimiter for listing prefixes, searchDepth determines how many prefixes to list
-// before parallelizing list calls, keyRegex is a regex filter on Keys
+// before parallelizing list calls, keyRegex is a regex filter on Keys. Brace groups in s3Uris are expanded before listing. A uri without a trailing delimiter that matches an object exactly
+// yields only that object, regardless of recursive. At most --limit objects are listed, only counting those with the --etag ETag and passing --include and --exclude. With --from-inventory the objects
+// under s3Uris are read from the S3 Inventory report instead of listed. With --all-buckets every bucket matching a
+// s3://<bucket-prefix> uri is listed concurrently, whichever region it is in.
 func Ls(svc *s3.S3, s3Uris []string, recursive bool, delimiter string, searchDepth int, keyRegex string) (chan *s3wrapper.ListOutput, error) {
-	wrap, err := s3wrapper.New(svc, maxParallel).WithRegionFrom(s3Uris[0])
+	s3Uris = expandS3URIs(s3Uris)
+	// listing is cancelled on its own once --limit objects were listed
+	listCtx, cancelList := context.WithCancel(ctx)
+	wrap, err := newS3Wrapper(svc).
+		WithContext(listCtx).
+		WithMaxConcurrency(concurrencyOr(listConcurrency)).
+		WithFetchOwner(fetchesOwner()).
+		WithRegionFrom(s3Uris[0])
 	if err != nil {
+		cancelList()
 		return nil, err
 	}
-	outChan := make(chan *s3wrapper.ListOutput, 10000)
+	outChan := make(chan *s3wrapper.ListOutput, wrap.Prefetch())
+
+	// queries are the uris as given, which --recursive-depth is relative to
+	queries := append([]string(nil), s3Uris...)
+	listed := 0
+	// emit sends itm to outChan until --limit objects were sent, after which
+	// the rest of the listing is dropped
+	emit := func(itm *s3wrapper.ListOutput) {
+		if (limit > 0 && listed >= limit) || !matchesOwner(itm) || !matchesETag(itm) || !matchesGlobs(itm) || isExcluded(itm, queries, delimiter) {
+			return
+		}
+		if recursive && recursiveDepth > 0 && keyDepth(itm, queries, delimiter) > recursiveDepth {
+			return
+		}
+		outChan <- itm
+		if !itm.IsPrefix {
+			listed++
+			if limit > 0 && listed >= limit {
+				cancelList()
+			}
+		}
+	}
+
+	if fromInventory != "" {
+		if allBuckets {
+			cancelList()
+			return nil, fmt.Errorf("--all-buckets can't be used with --from-inventory")
+		}
+		if fetchesOwner() {
+			cancelList()
+			return nil, fmt.Errorf("inventory reports have no owners, --with-owner, --owner-id and --owner-name can't be used with --from-inventory")
+		}
+		// inventory reports are flat, so every object under s3Uris is
+		// listed regardless of recursive, and never encrypted with SSE-C
+		inventoryWrap, err := newS3Wrapper(svc).
+			WithSSECustomerKey(nil).
+			WithContext(listCtx).
+			WithMaxConcurrency(concurrencyOr(listConcurrency)).
+			WithRegionFrom(fromInventory)
+		if err != nil {
+			cancelList()
+			return nil, err
+		}
+		go func() {
+			defer close(outChan)
+			defer cancelList()
+			for itm := range inventoryWrap.ListInventory(fromInventory, keyRegex) {
+				for _, uri := range s3Uris {
+					if strings.HasPrefix(itm.FullKey, uri) {
+						emit(itm)
+						break
+					}
+				}
+			}
+		}()
+		return outChan, nil
+	}
 
 	slashRegex := regexp.MustCompile("/")
+	var keyRegexFilter *regexp.Regexp
+	if keyRegex != "" {
+		keyRegexFilter, err = regexp.Compile(keyRegex)
+		if err != nil {
+			cancelList()
+			return nil, err
+		}
+	}
 	bucketExpandedS3Uris := make([]string, 0, 1000)
+	if allBuckets {
+		for _, uri := range s3Uris {
+			if len(slashRegex.FindAllString(uri, -1)) != 2 {
+				cancelList()
+				return nil, fmt.Errorf("--all-buckets expects uris of the form s3://<bucket-prefix>, got %s", uri)
+			}
+		}
+	}
 
+	// direct holds the buckets and single objects found while expanding the
+	// uris, the listing goroutine sends them since outChan may hold fewer
+	// than them, or none with --prefetch 0, and sending them here would
+	// block before anything reads outChan
+	var direct []*s3wrapper.ListOutput
 	// transforms uris with partial or no bucket (e.g. s3://)
 	// into a listable uri
 	for _, uri := range s3Uris {
@@ -81,34 +623,42 @@ func Ls(svc *s3.S3, s3Uris []string, recursive bool, delimiter string, searchDep
 		if len(slashRegex.FindAllString(uri, -1)) == 2 {
 			buckets, err := wrap.ListBuckets(uri)
 			if err != nil {
+				cancelList()
 				return nil, err
 			}
 			for _, bucket := range buckets {
 				// add the bucket back to the list of s3 uris in cases where
-				// we are searching beyond the bucket
-				if recursive || searchDepth > 0 {
-					resp, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
-					if err != nil {
-						return nil, err
-					}
-					// if the region is location constrained and not in the region we specified in our config
-					// then don't list it, otherwise we will get an error from the AWS API
-					if resp.LocationConstraint == nil || *resp.LocationConstraint == *svc.Client.Config.Region {
-						bucketExpandedS3Uris = append(bucketExpandedS3Uris, s3wrapper.FormatS3Uri(bucket, ""))
-					}
+				// we are searching beyond the bucket, buckets in other
+				// regions are listed with a client of their region
+				if recursive || searchDepth > 0 || allBuckets {
+					bucketExpandedS3Uris = append(bucketExpandedS3Uris, s3wrapper.FormatS3Uri(bucket, ""))
 				} else {
 					key := ""
 					fullKey := s3wrapper.FormatS3Uri(bucket, "")
-					outChan <- &s3wrapper.ListOutput{
+					direct = append(direct, &s3wrapper.ListOutput{
 						IsPrefix:     true,
 						Key:          key,
 						FullKey:      fullKey,
 						LastModified: time.Time{},
 						Size:         0,
 						Bucket:       bucket,
-					}
+					})
 				}
 			}
+		} else if !strings.HasSuffix(uri, delimiter) {
+			// a uri without a trailing delimiter may point at a single
+			// object, in which case we only operate on that object
+			// regardless of whether we are recursing or not
+			obj, err := wrap.Head(uri)
+			if err != nil {
+				cancelList()
+				return nil, err
+			}
+			if obj == nil {
+				bucketExpandedS3Uris = append(bucketExpandedS3Uris, uri)
+			} else if keyRegexFilter == nil || keyRegexFilter.MatchString(obj.FullKey) {
+				direct = append(direct, obj)
+			}
 		} else {
 			bucketExpandedS3Uris = append(bucketExpandedS3Uris, uri)
 		}
@@ -117,31 +667,98 @@ func Ls(svc *s3.S3, s3Uris []string, recursive bool, delimiter string, searchDep
 
 	go func() {
 		defer close(outChan)
+		defer cancelList()
 
+		for _, itm := range direct {
+			emit(itm)
+		}
 		for i := 0; i < searchDepth; i++ {
 			newS3Uris := make([]string, 0)
 			for itm := range wrap.ListAll(s3Uris, false, delimiter, keyRegex) {
 				if itm.IsPrefix {
-					newS3Uris = append(newS3Uris, strings.TrimRight(itm.FullKey, delimiter)+delimiter)
+					// excluded prefixes are never listed any deeper
+					if isExcluded(itm, queries, delimiter) {
+						continue
+					}
+					newS3Uris = append(newS3Uris, strings.TrimSuffix(itm.FullKey, delimiter)+delimiter)
 				} else {
-					outChan <- itm
+					emit(itm)
 				}
 			}
 			s3Uris = newS3Uris
 		}
 
+		// keep ranging once the limit is hit so the listing goroutines,
+		// which stop paging once cancelled, aren't left blocked
 		for itm := range wrap.ListAll(s3Uris, recursive, delimiter, keyRegex) {
-			outChan <- itm
+			emit(itm)
 		}
 	}()
 
 	return outChan, nil
 }
 
+// Follow lists like Ls every pollInterval until interrupted, only sending the keys and prefixes which are new or were
+// modified since the previous listing
+func Follow(svc *s3.S3, s3Uris []string, recursive bool, delimiter string, searchDepth int, keyRegex string, pollInterval time.Duration) chan *s3wrapper.ListOutput {
+	outChan := make(chan *s3wrapper.ListOutput, 10000)
+	go func() {
+		defer close(outChan)
+
+		// seen holds the keys of the previous listing along with their last
+		// modified time, it is rebuilt every poll so deleted keys are pruned
+		seen := make(map[string]time.Time)
+		for {
+			listChan, err := Ls(svc, s3Uris, recursive, delimiter, searchDepth, keyRegex)
+			if err != nil {
+				logger.Printf("WARN: unable to list, retrying in %s. Cause: '%s'\n", pollInterval, err)
+			} else {
+				listed := make(map[string]time.Time, len(seen))
+				for itm := range listChan {
+					listed[itm.FullKey] = itm.LastModified
+					if lastModified, ok := seen[itm.FullKey]; !ok || !lastModified.Equal(itm.LastModified) {
+						outChan <- itm
+					}
+				}
+				seen = listed
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+		}
+	}()
+
+	return outChan
+}
+
 func init() {
 	rootCmd.AddCommand(lsCmd)
 
-	lsCmd.Flags().BoolP("recursive", "r", false, "Get all keys for this prefix")
+	lsCmd.Flags().BoolP("recursive", "r", false, "List all keys for this prefix")
 	lsCmd.Flags().BoolP("human-readable", "H", false, "Output human-readable object sizes")
 	lsCmd.Flags().BoolP("with-date", "d", false, "Include the last modified date")
+	lsCmd.Flags().String("sort", "", "Sort the listing by name, size or time, this buffers the whole listing")
+	lsCmd.Flags().Bool("reverse", false, "Reverse the order of --sort")
+	lsCmd.Flags().Int("sort-limit", 1000000, "Maximum number of entries --sort will buffer")
+	lsCmd.Flags().Int("newest", 0, "Only list the N most recently modified objects, newest first, keeping only N objects in memory")
+	lsCmd.Flags().Int("oldest", 0, "Only list the N least recently modified objects, oldest first, keeping only N objects in memory")
+	lsCmd.Flags().BoolP("follow", "f", false, "Keep listing every --poll-interval and print new or modified keys until interrupted")
+	lsCmd.Flags().Duration("poll-interval", 10*time.Second, "How often --follow lists again")
+	lsCmd.Flags().Bool("objects-only", false, "Only list objects, not prefixes")
+	lsCmd.Flags().Bool("prefixes-only", false, "Only list prefixes, not objects")
+	lsCmd.Flags().Bool("json-array", false, "Write the listing as a single JSON array written as it is listed, rather than the lines of --output json")
+	lsCmd.Flags().Bool("page", false, "Page the listing through $PAGER, or every --page-size lines when $PAGER isn't set, ignored when stdout isn't a terminal")
+	lsCmd.Flags().Int("page-size", 40, "Number of lines per page of --page when $PAGER isn't set")
+	lsCmd.Flags().Bool("stdin", false, "Also list the S3 uris read from stdin, one per line, blank lines and # comments are ignored, - as a uri does the same")
+	lsCmd.Flags().BoolVar(&withOwner, "with-owner", false, "Include the owner of objects, this makes listing slower")
+	lsCmd.Flags().StringVar(&ownerID, "owner-id", "", "Only list the objects owned by this canonical user ID, implies fetching owners")
+	lsCmd.Flags().StringVar(&ownerName, "owner-name", "", "Only list the objects owned by this display name, implies fetching owners")
+	lsCmd.Flags().BoolVar(&allBuckets, "all-buckets", false, "List the contents of every bucket matching s3://<bucket-prefix> concurrently instead of the bucket names")
+	lsCmd.Flags().BoolVar(&withETag, "with-etag", false, "Include the ETag of objects")
+	lsCmd.Flags().StringVar(&etagFilter, "etag", "", "Only list the objects with this ETag, such as the copies of a known file")
+	lsCmd.Flags().Bool("find-duplicates", false, "Only list the objects whose ETag is shared by other objects, grouped by ETag, this buffers the whole listing")
+	lsCmd.Flags().Bool("restore-status", false, "Include the restore status of archived objects (requires a HEAD request per object)")
 }
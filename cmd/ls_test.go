@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// lsKeys runs Ls and returns the sorted full keys it listed, failing the test
+// if Ls returns an error or the listing doesn't complete within a few seconds
+func lsKeys(t *testing.T, svc *s3.S3, s3Uris []string, recursive bool) []string {
+	done := make(chan []string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		listCh, err := Ls(svc, s3Uris, recursive, "/", 0, "")
+		if err != nil {
+			errs <- err
+			return
+		}
+		var keys []string
+		for itm := range listCh {
+			keys = append(keys, itm.FullKey)
+		}
+		sort.Strings(keys)
+		done <- keys
+	}()
+	select {
+	case keys := <-done:
+		return keys
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("listing %v didn't complete", s3Uris)
+	}
+	return nil
+}
+
+func TestLsSingleObjectsUnbuffered(t *testing.T) {
+	for _, prefetchSize := range []int{0, 1, 2} {
+		withCleanGlobals(t)
+		prefetch = prefetchSize
+		_, svc := newFakeS3(t, map[string]string{
+			"bk/a.txt":     "a",
+			"bk/b.txt":     "b",
+			"bk/c.txt":     "c",
+			"bk/dir/d.txt": "d",
+		})
+		got := lsKeys(t, svc, []string{"s3://bk/a.txt", "s3://bk/b.txt", "s3://bk/c.txt", "s3://bk/dir/"}, false)
+		want := []string{"s3://bk/a.txt", "s3://bk/b.txt", "s3://bk/c.txt", "s3://bk/dir/d.txt"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("--prefetch %d: got %v, want %v", prefetchSize, got, want)
+		}
+	}
+}
+
+func TestLsSingleObjectOrPrefix(t *testing.T) {
+	objects := map[string]string{
+		"bk/a":          "a",
+		"bk/a/b.txt":    "b",
+		"bk/a/c/d.txt":  "d",
+		"bk/logs/e.txt": "e",
+	}
+	tests := []struct {
+		name      string
+		uri       string
+		recursive bool
+		want      []string
+	}{
+		// a uri naming an object is that object alone, even if it's also
+		// a prefix
+		{"object", "s3://bk/a", false, []string{"s3://bk/a"}},
+		{"object recursive", "s3://bk/a", true, []string{"s3://bk/a"}},
+		{"prefix", "s3://bk/a/", false, []string{"s3://bk/a/b.txt", "s3://bk/a/c"}},
+		{"prefix recursive", "s3://bk/a/", true, []string{"s3://bk/a/b.txt", "s3://bk/a/c/d.txt"}},
+		// a uri naming no object is a prefix, even without trailing delimiter
+		{"partial prefix", "s3://bk/lo", false, []string{"s3://bk/logs"}},
+		{"partial prefix recursive", "s3://bk/lo", true, []string{"s3://bk/logs/e.txt"}},
+		{"missing object", "s3://bk/a/b", false, []string{"s3://bk/a/b.txt"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			fake, svc := newFakeS3(t, objects)
+			got := lsKeys(t, svc, []string{test.uri}, test.recursive)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			// only uris without trailing delimiter may name an object
+			heads := fake.served("HEAD ")
+			if strings.HasSuffix(test.uri, "/") && len(heads) != 0 {
+				t.Errorf("looked up %v for a prefix", heads)
+			}
+		})
+	}
+}
+
+// listingGoroutines returns the stacks of the goroutines still running Ls or
+// the wrapper
+func listingGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	var stacks []string
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, "fasts3/s3wrapper.") || strings.Contains(stack, "fasts3/cmd.Ls") {
+			stacks = append(stacks, stack)
+		}
+	}
+	return stacks
+}
+
+func TestLsLimitStopsListing(t *testing.T) {
+	withCleanGlobals(t)
+	objects := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 50; j++ {
+			objects[fmt.Sprintf("bk/logs/%02d/%02d.txt", i, j)] = "x"
+		}
+	}
+	_, svc := newFakeS3(t, objects)
+	// the listing goroutines block on their output once a single object is
+	// buffered, unless the rest of the listing is drained
+	limit, prefetch = 3, 1
+	// --search-depth 1 lists every prefix under logs/ in its own goroutine
+	listCh, err := Ls(svc, []string{"s3://bk/logs/"}, true, "/", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := 0
+	for range listCh {
+		listed++
+	}
+	if listed != limit {
+		t.Errorf("listed %d objects, want %d", listed, limit)
+	}
+
+	// the listing goroutines return once cancelled, but not necessarily
+	// before the output is closed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		leaked := listingGoroutines()
+		if len(leaked) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d listing goroutines left running:\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRecursiveFlags(t *testing.T) {
+	for _, command := range rootCmd.Commands() {
+		flag := command.Flags().Lookup("recursive")
+		if flag == nil {
+			continue
+		}
+		if flag.Shorthand != "r" || flag.DefValue != "false" {
+			t.Errorf("%s: got --recursive with shorthand %q defaulting to %s, want -r defaulting to false", command.Name(), flag.Shorthand, flag.DefValue)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// mvCmd represents the mv command
+var mvCmd = &cobra.Command{
+	Use:   "mv <src> <dest>",
+	Short: "Move files within S3",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.ExactArgs(2)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		flat, err := cmd.Flags().GetBool("flat")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+		onCollision, err := cmd.Flags().GetString("on-collision")
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch onCollision {
+		case s3wrapper.CollisionSkip, s3wrapper.CollisionRename, s3wrapper.CollisionError:
+		case s3wrapper.CollisionOverwrite:
+			// every source would be deleted but only the last one kept
+			log.Fatalf("--on-collision %s can't be used with mv, the keys overwritten would be lost", onCollision)
+		default:
+			log.Fatalf("unknown collision strategy %q, expected one of %s, %s or %s", onCollision,
+				s3wrapper.CollisionSkip, s3wrapper.CollisionRename, s3wrapper.CollisionError)
+		}
+		noClobber, err := cmd.Flags().GetBool("no-clobber")
+		if err != nil {
+			log.Fatal(err)
+		}
+		deleteEmptyPrefixes, err := cmd.Flags().GetBool("delete-empty-prefixes")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if deleteEmptyPrefixes && !recursive {
+			log.Fatal("--delete-empty-prefixes requires --recursive")
+		}
+		opts := s3wrapper.CopyOptions{
+			Flat:        flat,
+			OnCollision: onCollision,
+			NoClobber:   noClobber,
+		}
+		if err := Mv(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex, opts, deleteEmptyPrefixes, dryRun); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// Mv moves files from one s3 location to another using svc, s3Uris is a list of source and dest s3 URIs, recurse tells
+// whether to move all keys under the source prefix, delimiter tells the delimiter to use when listing, searchDepth
+// determines the number of prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys, opts
+// are the options of the copies, deleteEmptyPrefixes then deletes the directory markers left under the source with
+// nothing under them, dryRun prints what would be moved without moving anything. Every key is copied to the dest like
+// Cp does and its source is only deleted once its copy succeeded, the sources are deleted in batches.
+func Mv(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, opts s3wrapper.CopyOptions, deleteEmptyPrefixes bool, dryRun bool) error {
+	listCh, err := Ls(svc, []string{s3Uris[0]}, recurse, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+	wrap, err := newS3Wrapper(svc).WithDryRun(dryRun).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	destBucket, _ := s3wrapper.ParseS3Uri(s3Uris[1])
+	results := newEmitter("mv", statusOut)
+	results.dryRun = dryRun
+	stopProgress := reportProgress(results)
+	// dests maps the uris of the sources being deleted to their copy
+	var destsLock sync.Mutex
+	dests := make(map[string]string)
+	copied := wrap.CopyAll(listCh, s3Uris[0], s3Uris[1], delimiter, recurse, opts)
+	sources := make(chan *s3wrapper.ListOutput, 10000)
+	go func() {
+		defer close(sources)
+		for file := range copied {
+			dest := s3wrapper.FormatS3Uri(destBucket, file.Key)
+			// CopyAll replaced the key with the one of the copy
+			_, sourceKey := s3wrapper.ParseS3Uri(file.FullKey)
+			if dest == file.FullKey {
+				logger.Printf("WARN: not deleting %s, it was moved onto itself\n", file.FullKey)
+				results.Skip()
+				continue
+			}
+			destsLock.Lock()
+			dests[file.FullKey] = dest
+			destsLock.Unlock()
+			source := *file
+			source.Key = sourceKey
+			sources <- &source
+		}
+	}()
+	// wouldMove holds the keys a dry run would move, which the directory
+	// markers are then considered empty without
+	wouldMove := make(map[string]bool)
+	for file := range wrap.DeleteObjects(sources) {
+		if deleteEmptyPrefixes && dryRun {
+			wouldMove[file.FullKey] = true
+		}
+		destsLock.Lock()
+		dest := dests[file.FullKey]
+		destsLock.Unlock()
+		if dryRun {
+			results.Result("move", file, dest, fmt.Sprintf("Would move %s -> %s\n", file.FullKey, dest))
+		} else {
+			results.Result("move", file, dest, fmt.Sprintf("Moved %s -> %s\n", file.FullKey, dest))
+		}
+	}
+	if deleteEmptyPrefixes {
+		if err := deleteEmptyMarkers(wrap, s3Uris[:1], delimiter, wouldMove, results); err != nil {
+			stopProgress()
+			return err
+		}
+	}
+	stopProgress()
+	results.Summary()
+	return wrap.Err()
+}
+
+func init() {
+	rootCmd.AddCommand(mvCmd)
+
+	mvCmd.Flags().BoolP("recursive", "r", false, "Move all keys for this prefix")
+	mvCmd.Flags().BoolP("flat", "f", false, "Move all source files into a flat destination folder (vs. corresponding subfolders)")
+	mvCmd.Flags().BoolP("dry-run", "n", false, "Print what would be moved without moving anything")
+	mvCmd.Flags().String("on-collision", s3wrapper.CollisionError, "What to do with --flat when several keys have the same name, one of skip (the first one is moved, the others kept), rename (add a numeric suffix) or error (stop moving)")
+	mvCmd.Flags().Bool("no-clobber", false, "Keep the keys whose destination already exists instead of overwriting it")
+	mvCmd.Flags().Bool("delete-empty-prefixes", false, "Then delete the directory markers, empty keys ending with the delimiter, left under the source with no other object under them")
+}
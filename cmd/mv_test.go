@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+// mv runs Mv, failing the test if it returns an error or doesn't complete
+// within a few seconds
+func mv(t *testing.T, run func() error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- run()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("mv didn't complete")
+	}
+}
+
+func TestMvMoreKeysThanConcurrency(t *testing.T) {
+	// 0 is the concurrency of --max-parallel
+	for _, concurrency := range []int{0, 2} {
+		t.Run(fmt.Sprintf("transfer concurrency %d", concurrency), func(t *testing.T) {
+			withCleanGlobals(t)
+			oldConcurrency := transferConcurrency
+			transferConcurrency = concurrency
+			t.Cleanup(func() { transferConcurrency = oldConcurrency })
+			objects := make(map[string]string)
+			var want []string
+			for i := 0; i < 300; i++ {
+				objects[fmt.Sprintf("src/%03d", i)] = fmt.Sprint(i)
+				want = append(want, fmt.Sprintf("dst/%03d", i))
+			}
+			fake, svc := newFakeS3(t, objects)
+			mv(t, func() error {
+				return Mv(svc, []string{"s3://src/", "s3://dst/"}, true, "/", 0, "", s3wrapper.CopyOptions{}, false, false)
+			})
+			if got := fake.keys(); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %d keys %v, want the %d keys of dst", len(got), got, len(want))
+			}
+		})
+	}
+}
+
+func TestMvDeleteEmptyPrefixes(t *testing.T) {
+	withCleanGlobals(t)
+	fake, svc := newFakeS3(t, map[string]string{
+		"bk/incoming/":          "",
+		"bk/incoming/a.csv":     "a",
+		"bk/incoming/b.txt":     "b",
+		"bk/incoming/sub/":      "",
+		"bk/incoming/sub/c.csv": "c",
+	})
+	mv(t, func() error {
+		return Mv(svc, []string{"s3://bk/incoming/", "s3://bk/processed/"}, true, "/", 0, `\.csv$`, s3wrapper.CopyOptions{}, true, false)
+	})
+	// incoming/ still has b.txt under it, incoming/sub/ was emptied
+	want := []string{"bk/incoming/", "bk/incoming/b.txt", "bk/processed/a.csv", "bk/processed/sub/c.csv"}
+	if got := fake.keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMvDeleteEmptyPrefixesExclude(t *testing.T) {
+	withCleanGlobals(t)
+	if err := rootCmd.PersistentFlags().Set("exclude", "incoming/*.txt"); err != nil {
+		t.Fatal(err)
+	}
+	fake, svc := newFakeS3(t, map[string]string{
+		"bk/incoming/":      "",
+		"bk/incoming/a.csv": "a",
+		"bk/incoming/b.txt": "b",
+	})
+	mv(t, func() error {
+		return Mv(svc, []string{"s3://bk/incoming/", "s3://bk/processed/"}, true, "/", 0, `\.(csv|txt)$`, s3wrapper.CopyOptions{}, true, false)
+	})
+	// b.txt was excluded from the move but still keeps incoming/ from being
+	// empty
+	want := []string{"bk/incoming/", "bk/incoming/b.txt", "bk/processed/a.csv"}
+	if got := fake.keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMvDeleteEmptyPrefixesLimit(t *testing.T) {
+	withCleanGlobals(t)
+	limit = 1
+	fake, svc := newFakeS3(t, map[string]string{
+		"bk/incoming/":      "",
+		"bk/incoming/a.csv": "a",
+		"bk/incoming/b.csv": "b",
+	})
+	mv(t, func() error {
+		return Mv(svc, []string{"s3://bk/incoming/", "s3://bk/processed/"}, true, "/", 0, `\.csv$`, s3wrapper.CopyOptions{}, true, false)
+	})
+	// only a.csv was moved, b.csv past the limit still keeps incoming/ from
+	// being empty
+	want := []string{"bk/incoming/", "bk/incoming/b.csv", "bk/processed/a.csv"}
+	if got := fake.keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
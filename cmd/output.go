@@ -0,0 +1,375 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var (
+	// dataOut receives listings and object data, nothing else may be
+	// written to it so it can safely be piped
+	dataOut io.Writer = os.Stdout
+	// statusOut receives per-object statuses, progress and other messages
+	statusOut io.Writer = os.Stderr
+	// started is when the command started, the duration of --summary-json
+	// is measured from it
+	started = time.Now()
+)
+
+// result is the JSON representation of a single object a command
+// operated on
+type result struct {
+	Action            string            `json:"action"`
+	Source            string            `json:"source"`
+	Dest              string            `json:"dest,omitempty"`
+	IsPrefix          bool              `json:"is_prefix,omitempty"`
+	Size              int64             `json:"size"`
+	LastModified      *time.Time        `json:"last_modified,omitempty"`
+	DryRun            bool              `json:"dry_run,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	Owner             string            `json:"owner,omitempty"`
+	Grants            []*aclGrant       `json:"grants,omitempty"`
+	ContentType       string            `json:"content_type,omitempty"`
+	ETag              string            `json:"etag,omitempty"`
+	StorageClass      string            `json:"storage_class,omitempty"`
+	Restore           string            `json:"restore,omitempty"`
+	RestoreUntil      *time.Time        `json:"restore_until,omitempty"`
+	SSE               string            `json:"sse,omitempty"`
+	VersionID         string            `json:"version_id,omitempty"`
+	ChecksumAlgorithm string            `json:"checksum_algorithm,omitempty"`
+	Checksum          string            `json:"checksum,omitempty"`
+	Objects           int64             `json:"objects,omitempty"`
+	Matches           int64             `json:"matches,omitempty"`
+	Lines             []*grepLine       `json:"lines,omitempty"`
+}
+
+// aclGrant is the JSON representation of a single ACL grant
+type aclGrant struct {
+	Grantee    string `json:"grantee"`
+	Permission string `json:"permission"`
+}
+
+// grepLine is the JSON representation of a single matching line
+type grepLine struct {
+	Number  int64  `json:"number"`
+	Text    string `json:"text"`
+	Context bool   `json:"context,omitempty"`
+}
+
+// summary is the JSON representation of the final summary of a command
+type summary struct {
+	Action  string `json:"action"`
+	Command string `json:"command"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+	Skipped int64  `json:"skipped,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+}
+
+// machineSummary is the JSON summary of --summary-json, written whatever the
+// output format
+type machineSummary struct {
+	Command     string `json:"command"`
+	Objects     int64  `json:"objects"`
+	Bytes       int64  `json:"bytes"`
+	Errors      int    `json:"errors"`
+	DurationMS  int64  `json:"duration_ms"`
+	BytesPerSec int64  `json:"bytes_per_sec"`
+}
+
+// emitter writes the per-object results and final summary of a command
+// either as text or as one JSON object per line depending on --output
+type emitter struct {
+	sync.Mutex
+	command string
+	json    bool
+	out     io.Writer
+	enc     *json.Encoder
+	objects int64
+	bytes   int64
+	// skipped is the number of objects the command skipped
+	skipped int64
+	// dryRun marks the results as planned changes which weren't made
+	dryRun bool
+	// jsonArray writes the results as the elements of a single JSON array,
+	// incrementally and without the summary
+	jsonArray bool
+	// written is the number of results written to the JSON array
+	written int64
+}
+
+// newEmitter creates an emitter for command which writes to out
+func newEmitter(command string, out io.Writer) *emitter {
+	return &emitter{
+		command: command,
+		json:    output == outputJSON,
+		out:     out,
+		enc:     json.NewEncoder(out),
+	}
+}
+
+// Result writes the result of action on obj, text is written as is when the
+// output format is text, dest is the optional destination of the object
+func (e *emitter) Result(action string, obj *s3wrapper.ListOutput, dest string, text string) {
+	e.write(e.newResult(action, obj, dest), obj, text)
+}
+
+// TagResult writes the result of action on obj including its tags, text is
+// written as is when the output format is text
+func (e *emitter) TagResult(action string, obj *s3wrapper.TagOutput, text string) {
+	r := e.newResult(action, obj.ListOutput, "")
+	r.Tags = obj.Tags
+	e.write(r, obj.ListOutput, text)
+}
+
+// ACLResult writes the result of action on obj including its ACL, text is
+// written as is when the output format is text
+func (e *emitter) ACLResult(action string, obj *s3wrapper.ACLOutput, text string) {
+	r := e.newResult(action, obj.ListOutput, "")
+	r.Owner = obj.Owner
+	for _, grant := range obj.Grants {
+		r.Grants = append(r.Grants, &aclGrant{Grantee: grant.Grantee, Permission: grant.Permission})
+	}
+	e.write(r, obj.ListOutput, text)
+}
+
+// StatResult writes the result of action on obj including its metadata, text
+// is written as is when the output format is text
+func (e *emitter) StatResult(action string, obj *s3wrapper.StatOutput, text string) {
+	r := e.newResult(action, obj.ListOutput, "")
+	r.ContentType = obj.ContentType
+	r.ETag = obj.ETag
+	r.StorageClass = obj.StorageClass
+	r.Restore = obj.RestoreStatus
+	r.SSE = obj.ServerSideEncryption
+	r.VersionID = obj.VersionID
+	if !obj.RestoreExpiry.IsZero() {
+		restoreUntil := obj.RestoreExpiry
+		r.RestoreUntil = &restoreUntil
+	}
+	e.write(r, obj.ListOutput, text)
+}
+
+// GrepResult writes the result of action on obj including its matches, text
+// is written as is when the output format is text
+func (e *emitter) GrepResult(action string, obj *s3wrapper.GrepOutput, text string) {
+	r := e.newResult(action, obj.ListOutput, "")
+	r.Matches = obj.Count
+	for _, line := range obj.Lines {
+		r.Lines = append(r.Lines, &grepLine{Number: line.Number, Text: strings.TrimSuffix(line.Text, "\n"), Context: line.Context})
+	}
+	e.write(r, obj.ListOutput, text)
+}
+
+// UploadResult writes the result of action on the upload obj, which is
+// reported from its local path to its key, text is written as is when the
+// output format is text
+func (e *emitter) UploadResult(action string, obj *s3wrapper.PutOutput, text string) {
+	r := e.newResult(action, obj.ListOutput, obj.FullKey)
+	r.Source = obj.LocalPath
+	e.write(r, obj.ListOutput, text)
+}
+
+// GroupResult writes the result of action on a group of objects along with
+// their number and total size, text is written as is when the output format
+// is text
+func (e *emitter) GroupResult(action string, group string, objects int64, bytes int64, text string) {
+	r := &result{Action: action, Source: group, IsPrefix: true, Size: bytes, Objects: objects}
+	e.write(r, nil, text)
+}
+
+// JSONArray makes the emitter write its results as a single JSON array
+// rather than one JSON object per line, the array is closed by Summary
+func (e *emitter) JSONArray() {
+	e.json = true
+	e.jsonArray = true
+}
+
+// newResult creates the JSON result of action on obj
+func (e *emitter) newResult(action string, obj *s3wrapper.ListOutput, dest string) *result {
+	r := &result{
+		Action:            action,
+		Source:            obj.FullKey,
+		Dest:              dest,
+		IsPrefix:          obj.IsPrefix,
+		Size:              obj.Size,
+		DryRun:            e.dryRun,
+		Owner:             formatListOwner(obj),
+		ETag:              obj.ETag,
+		VersionID:         obj.VersionID,
+		ChecksumAlgorithm: obj.ChecksumAlgorithm,
+		Checksum:          obj.Checksum,
+	}
+	if !obj.LastModified.IsZero() {
+		lastModified := obj.LastModified
+		r.LastModified = &lastModified
+	}
+	return r
+}
+
+// write tallies obj, unless it is nil, and writes either text or r depending
+// on the output format
+func (e *emitter) write(r *result, obj *s3wrapper.ListOutput, text string) {
+	e.Lock()
+	defer e.Unlock()
+
+	if obj != nil && !obj.IsPrefix {
+		e.objects++
+		e.bytes += obj.Size
+	}
+	if !e.json {
+		fmt.Fprint(e.out, text)
+		return
+	}
+	if e.jsonArray {
+		encoded, err := json.Marshal(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		separator := ",\n"
+		if e.written == 0 {
+			separator = "[\n"
+		}
+		e.written++
+		fmt.Fprintf(e.out, "%s%s", separator, encoded)
+		return
+	}
+	if err := e.enc.Encode(r); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Count tallies obj in the summary without writing a result
+func (e *emitter) Count(obj *s3wrapper.ListOutput) {
+	e.Lock()
+	defer e.Unlock()
+
+	if !obj.IsPrefix {
+		e.objects++
+		e.bytes += obj.Size
+	}
+}
+
+// Skip tallies an object the command skipped in the summary
+func (e *emitter) Skip() {
+	e.Lock()
+	defer e.Unlock()
+	e.skipped++
+}
+
+// totals returns the number of objects and bytes tallied so far
+func (e *emitter) totals() (objects int64, bytes int64) {
+	e.Lock()
+	defer e.Unlock()
+	return e.objects, e.bytes
+}
+
+// Summary writes the final summary, with text output this is only written
+// for dry runs and commands which skipped objects, a JSON array is closed
+// instead. Nothing but the JSON array is written with --only-show-errors.
+func (e *emitter) Summary() {
+	e.Lock()
+	defer e.Unlock()
+	defer e.writeMachineSummary()
+
+	if onlyShowErrors && !e.jsonArray {
+		return
+	}
+	if !e.json {
+		if e.skipped > 0 {
+			fmt.Fprintf(e.out, "Skipped %s objects\n", humanize.Comma(e.skipped))
+		}
+		if e.dryRun {
+			fmt.Fprintf(e.out, "Dry run, %s would change %s objects (%s)\n", e.command, humanize.Comma(e.objects), humanize.Bytes(uint64(e.bytes)))
+		}
+		return
+	}
+	if e.jsonArray {
+		if e.written == 0 {
+			fmt.Fprint(e.out, "[")
+		}
+		fmt.Fprint(e.out, "\n]\n")
+		return
+	}
+	err := e.enc.Encode(&summary{
+		Action:  "summary",
+		Command: e.command,
+		Objects: e.objects,
+		Bytes:   e.bytes,
+		Skipped: e.skipped,
+		DryRun:  e.dryRun,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeMachineSummary writes the totals of e as the single JSON object of
+// --summary-json to stderr, or to --summary-file when set
+func (e *emitter) writeMachineSummary() {
+	if !summaryJSON && summaryFile == "" {
+		return
+	}
+	elapsed := time.Since(started)
+	s := &machineSummary{
+		Command:    e.command,
+		Objects:    e.objects,
+		Bytes:      e.bytes,
+		Errors:     wrapperErrs.Len(),
+		DurationMS: elapsed.Milliseconds(),
+	}
+	if elapsed > 0 {
+		s.BytesPerSec = int64(float64(e.bytes) / elapsed.Seconds())
+	}
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if summaryFile == "" {
+		fmt.Fprintf(statusOut, "%s\n", encoded)
+		return
+	}
+	if err := ioutil.WriteFile(summaryFile, append(encoded, '\n'), 0644); err != nil {
+		log.Fatalf("unable to write the summary to %s: %s", summaryFile, err)
+	}
+}
+
+// applyOnlyShowErrors silences everything written to stderr but errors with
+// --only-show-errors, errors are still written by log.Fatal
+func applyOnlyShowErrors() error {
+	if !onlyShowErrors {
+		return nil
+	}
+	if forceProgress {
+		return fmt.Errorf("--only-show-errors can't be used with --force-progress")
+	}
+	if summaryJSON && summaryFile == "" {
+		return fmt.Errorf("--only-show-errors can't be used with --summary-json unless the summary is written to --summary-file")
+	}
+	statusOut = ioutil.Discard
+	logger = log.New(ioutil.Discard, "", 0)
+	return nil
+}
+
+// validateOutput checks the --output flag is a known format
+func validateOutput() error {
+	if output != outputText && output != outputJSON {
+		return fmt.Errorf("unknown output format %q, expected %s or %s", output, outputText, outputJSON)
+	}
+	return nil
+}
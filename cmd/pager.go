@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// startPager pages what is written to dataOut when stdout is a terminal,
+// either through $PAGER when it is set or by stopping every pageSize lines
+// until Enter is pressed on the terminal. quit is called once the user quits
+// the pager so the command can stop producing output. The returned func
+// flushes the pager, waits for it to exit and restores dataOut.
+func startPager(pageSize int, quit func()) (stop func()) {
+	if !isTerminal(os.Stdout) {
+		return func() {}
+	}
+	previous := dataOut
+	restore := func() { dataOut = previous }
+
+	if pager := os.Getenv("PAGER"); pager != "" {
+		cmd := exec.Command("sh", "-c", pager)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err == nil {
+			dataOut = &pagerWriter{out: stdin, quit: quit}
+			return func() {
+				stdin.Close()
+				cmd.Wait()
+				restore()
+			}
+		}
+		fmt.Fprintf(statusOut, "unable to start $PAGER %q, paging without it: %s\n", pager, err)
+	}
+
+	// the keypresses are read from the terminal itself since stdin may be
+	// what is being listed
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return func() {}
+	}
+	dataOut = &pagerWriter{out: os.Stdout, quit: quit, tty: bufio.NewReader(tty), pageSize: pageSize}
+	return func() {
+		tty.Close()
+		restore()
+	}
+}
+
+// pagerWriter writes to out until the user quits, after which writes are
+// dropped. With a tty it stops every pageSize lines and waits for Enter, q
+// followed by Enter quits.
+type pagerWriter struct {
+	sync.Mutex
+	out      io.Writer
+	quit     func()
+	done     bool
+	tty      *bufio.Reader
+	pageSize int
+	lines    int
+}
+
+func (p *pagerWriter) Write(b []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	written := 0
+	for written < len(b) && !p.done {
+		chunk := b[written:]
+		if i := bytes.IndexByte(chunk, '\n'); i >= 0 {
+			chunk = chunk[:i+1]
+		}
+		if _, err := p.out.Write(chunk); err != nil {
+			// the external pager exited
+			p.stop()
+			break
+		}
+		written += len(chunk)
+		if p.tty != nil && chunk[len(chunk)-1] == '\n' {
+			p.lines++
+			if p.pageSize > 0 && p.lines%p.pageSize == 0 {
+				p.prompt()
+			}
+		}
+	}
+	// dropped output isn't an error, the user asked for it
+	return len(b), nil
+}
+
+// prompt waits for the user to ask for the next page
+func (p *pagerWriter) prompt() {
+	fmt.Fprint(statusOut, "-- More -- (Enter for the next page, q to quit)")
+	answer, err := p.tty.ReadString('\n')
+	if err != nil || strings.TrimSpace(answer) == "q" {
+		p.stop()
+	}
+}
+
+func (p *pagerWriter) stop() {
+	if !p.done {
+		p.done = true
+		p.quit()
+	}
+}
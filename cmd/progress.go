@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// reportProgress periodically writes the totals and rates of the results
+// tallied by e to statusOut, the returned func stops the reporting. Nothing
+// is reported unless showProgress allows it.
+func reportProgress(e *emitter) (stop func()) {
+	if !showProgress() {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		lastTick := time.Now()
+		var lastObjects, lastBytes int64
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				objects, bytes := e.totals()
+				elapsed := now.Sub(lastTick).Seconds()
+				fmt.Fprintf(statusOut, "... %s objects, %s, %.0f obj/s, %s/s\n",
+					humanize.Comma(objects),
+					humanize.Bytes(uint64(bytes)),
+					float64(objects-lastObjects)/elapsed,
+					humanize.Bytes(uint64(float64(bytes-lastBytes)/elapsed)))
+				lastTick, lastObjects, lastBytes = now, objects, bytes
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// fileProgress reports the progress of the download of a single large
+// object, the bytes downloaded are written to it
+type fileProgress struct {
+	uri     string
+	size    int64
+	written int64
+	done    chan struct{}
+}
+
+// fileProgressOver returns the GetOptions.Progress reporting the progress of
+// every object of at least threshold bytes on its own, or nil when progress
+// isn't shown or threshold is 0
+func fileProgressOver(threshold int64) func(k *s3wrapper.ListOutput) io.WriteCloser {
+	if threshold <= 0 || !showProgress() {
+		return nil
+	}
+	return func(k *s3wrapper.ListOutput) io.WriteCloser {
+		if k.Size < threshold {
+			return nil
+		}
+		p := &fileProgress{uri: k.FullKey, size: k.Size, done: make(chan struct{})}
+		go p.report()
+		return p
+	}
+}
+
+func (p *fileProgress) Write(b []byte) (int, error) {
+	atomic.AddInt64(&p.written, int64(len(b)))
+	return len(b), nil
+}
+
+// Close stops the reporting
+func (p *fileProgress) Close() error {
+	close(p.done)
+	return nil
+}
+
+// report writes the percentage downloaded, rate and time left every
+// progressInterval until closed, the time left assumes the average rate so
+// far holds
+func (p *fileProgress) report() {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			written := atomic.LoadInt64(&p.written)
+			rate := float64(written) / now.Sub(start).Seconds()
+			eta := "unknown"
+			if rate > 0 {
+				eta = time.Duration(float64(p.size-written) / rate * float64(time.Second)).Round(time.Second).String()
+			}
+			fmt.Fprintf(statusOut, "... %s %.1f%% (%s / %s), %s/s, ETA %s\n",
+				p.uri,
+				100*float64(written)/float64(p.size),
+				humanize.Bytes(uint64(written)),
+				humanize.Bytes(uint64(p.size)),
+				humanize.Bytes(uint64(rate)),
+				eta)
+		}
+	}
+}
+
+// showProgress tells whether progress should be reported. It never is when
+// --progress-interval is 0, always is with --force-progress or an explicit
+// --progress-interval and otherwise only when stderr is a terminal outside of
+// CI, where it would clutter the logs.
+func showProgress() bool {
+	if progressInterval <= 0 || onlyShowErrors {
+		return false
+	}
+	if forceProgress || rootCmd.PersistentFlags().Changed("progress-interval") {
+		return true
+	}
+	return isTerminal(os.Stderr) && os.Getenv("CI") == ""
+}
+
+// isTerminal tells whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
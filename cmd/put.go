@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// putCmd represents the put command
+var putCmd = &cobra.Command{
+	Use:   "put <local paths> <S3 URI>",
+	Short: "Upload local files to S3",
+	Long:  ``,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("requires at least one local path and an S3 uri")
+		}
+		return validateS3URIs()(cmd, args[len(args)-1:])
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+		contentType, err := cmd.Flags().GetString("content-type")
+		if err != nil {
+			log.Fatal(err)
+		}
+		contentTypeMap, err := cmd.Flags().GetStringToString("content-type-map")
+		if err != nil {
+			log.Fatal(err)
+		}
+		headers, err := headerFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		encryption, err := sseFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		contentMD5, err := cmd.Flags().GetBool("content-md5")
+		if err != nil {
+			log.Fatal(err)
+		}
+		followSymlinks, err := cmd.Flags().GetBool("follow-symlinks")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dedup, err := cmd.Flags().GetBool("dedup")
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts := s3wrapper.PutOptions{
+			ContentType:    contentType,
+			ContentTypes:   normalizeContentTypeMap(contentTypeMap),
+			Headers:        headers,
+			Encryption:     encryption,
+			ContentMD5:     contentMD5,
+			FollowSymlinks: followSymlinks,
+			Dedup:          dedup,
+		}
+		err = Put(GetS3Client(), args[:len(args)-1], args[len(args)-1], recursive, delimiter, opts, dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// Put uploads local files to S3 using svc, localPaths are the files and directories to upload, dest is the S3 uri to
+// upload them to, recurse tells whether or not to upload the files under directories, delimiter is used to build the
+// keys of files in directories, opts are the options applied to every object, dryRun prints what would be uploaded
+// without uploading anything. With opts.Dedup the files with the content of an object under the destination are copied
+// from it, or skipped when it is their own key, and how many were is written once done.
+func Put(svc *s3.S3, localPaths []string, dest string, recurse bool, delimiter string, opts s3wrapper.PutOptions, dryRun bool) error {
+	if delimiter == "" {
+		return fmt.Errorf("put requires a --delimiter to join the paths of files in directories")
+	}
+	wrap, err := newS3Wrapper(svc).WithDryRun(dryRun).WithRegionFrom(dest)
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("put", statusOut)
+	results.dryRun = dryRun
+	stopProgress := reportProgress(results)
+	// copied and alreadyUploaded are the files deduplicated by copying an object
+	// with their content or by skipping them, along with their size
+	var copied, alreadyUploaded, dedupBytes int64
+	for obj := range wrap.PutAll(localPaths, dest, delimiter, recurse, opts) {
+		if obj.DedupSource == obj.FullKey {
+			logger.Printf("Skipping %s, %s already has its content\n", obj.LocalPath, obj.FullKey)
+			results.Skip()
+			alreadyUploaded++
+			dedupBytes += obj.Size
+		} else if obj.DedupSource != "" {
+			verb := "Copied"
+			if dryRun {
+				verb = "Would copy"
+			}
+			results.UploadResult("copy", obj, fmt.Sprintf("%s %s -> %s from its duplicate %s\n", verb, obj.LocalPath, obj.FullKey, obj.DedupSource))
+			copied++
+			dedupBytes += obj.Size
+		} else if dryRun {
+			results.UploadResult("upload", obj, fmt.Sprintf("Would upload %s -> %s\n", obj.LocalPath, obj.FullKey))
+		} else {
+			results.UploadResult("upload", obj, fmt.Sprintf("Uploaded %s -> %s\n", obj.LocalPath, obj.FullKey))
+		}
+	}
+	stopProgress()
+	results.Summary()
+	if opts.Dedup {
+		fmt.Fprintf(statusOut, "Deduplicated %s files (%s), %s copied from their duplicates and %s already uploaded\n",
+			humanize.Comma(copied+alreadyUploaded), humanize.Bytes(uint64(dedupBytes)), humanize.Comma(copied), humanize.Comma(alreadyUploaded))
+	}
+	return wrap.Err()
+}
+
+// normalizeContentTypeMap lower cases the extensions of contentTypeMap and
+// adds their leading dot when missing
+func normalizeContentTypeMap(contentTypeMap map[string]string) map[string]string {
+	normalized := make(map[string]string, len(contentTypeMap))
+	for ext, contentType := range contentTypeMap {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalized[ext] = contentType
+	}
+	return normalized
+}
+
+func init() {
+	rootCmd.AddCommand(putCmd)
+
+	putCmd.Flags().BoolP("recursive", "r", false, "Upload all files under directories")
+	putCmd.Flags().Bool("dry-run", false, "Print what would be uploaded without uploading anything")
+	putCmd.Flags().String("content-type", "", "Content type of every uploaded object, detected from the file extension by default")
+	putCmd.Flags().StringToString("content-type-map", nil, "Content types of file extensions as ext=type pairs, these take precedence over the built-in ones")
+	putCmd.Flags().Bool("content-md5", false, "Hash every file before uploading it so S3 rejects corrupted uploads, files uploaded in several parts are checked against the ETag of the object instead, this reads every file twice")
+	putCmd.Flags().Bool("dedup", false, "Skip the files whose key already has their content, and copy the files with the content of another object under the destination from it instead of uploading them, this lists the destination and reads every file twice")
+	putCmd.Flags().Bool("follow-symlinks", false, "Upload the targets of the symlinks found in directories, they are skipped with a warning by default")
+	addHeaderFlags(putCmd)
+	addSSEFlags(putCmd)
+}
@@ -3,8 +3,13 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/metaverse/fasts3/s3wrapper"
 	"github.com/spf13/cobra"
 )
@@ -20,7 +25,48 @@ var rmCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
-		if err := Rm(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex); err != nil {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+		olderThanFlag, err := cmd.Flags().GetString("older-than")
+		if err != nil {
+			log.Fatal(err)
+		}
+		yes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			log.Fatal(err)
+		}
+		includeVersions, err := cmd.Flags().GetBool("include-versions")
+		if err != nil {
+			log.Fatal(err)
+		}
+		// deleting versions can't be undone, so it only prints what it
+		// would delete until confirmed
+		if includeVersions && !yes && !dryRun {
+			fmt.Fprintln(statusOut, "--include-versions is a dry run unless --yes is given")
+			dryRun = true
+		}
+		deleteEmptyPrefixes, err := cmd.Flags().GetBool("delete-empty-prefixes")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if deleteEmptyPrefixes && !recursive {
+			log.Fatal("--delete-empty-prefixes requires --recursive")
+		}
+		var olderThan time.Duration
+		if olderThanFlag != "" {
+			if olderThan, err = parseAge(olderThanFlag); err != nil {
+				log.Fatal(err)
+			}
+			// retention cleanups delete whole swaths of a bucket, so they
+			// only print what they would delete until confirmed
+			if !yes && !dryRun {
+				fmt.Fprintln(statusOut, "--older-than is a dry run unless --yes is given")
+				dryRun = true
+			}
+		}
+		if err := Rm(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex, olderThan, includeVersions, deleteEmptyPrefixes, dryRun); err != nil {
 			log.Fatal(err)
 		}
 	},
@@ -28,27 +74,192 @@ var rmCmd = &cobra.Command{
 
 // Rm removes files from S3 using svc, s3Uris is a list of prefixes/keys to delete, recurse tells whether or not to delete
 // everything under the prefixes, delimiter tells the delimiter to use when listing, searchDepth determines the number of
-// prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys
-func Rm(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string) error {
+// prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys, only the objects last modified
+// more than olderThan ago are deleted when it is above 0, includeVersions permanently deletes every version of the keys,
+// delete markers included, instead of adding a delete marker, deleteEmptyPrefixes then deletes the directory markers
+// left under s3Uris with nothing under them, dryRun prints what would be deleted without deleting anything
+func Rm(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, olderThan time.Duration, includeVersions bool, deleteEmptyPrefixes bool, dryRun bool) error {
 	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
 	if err != nil {
 		return err
 	}
+	if olderThan > 0 {
+		listCh = filterModifiedBefore(listCh, time.Now().Add(-olderThan))
+	}
 
-	wrap, err := s3wrapper.New(svc, maxParallel).WithRegionFrom(s3Uris[0])
+	wrap, err := newS3Wrapper(svc).WithDryRun(dryRun).WithRegionFrom(s3Uris[0])
 	if err != nil {
 		return err
 	}
 
+	if includeVersions {
+		listCh = wrap.ListKeyVersions(listCh)
+	}
+
+	results := newEmitter("rm", statusOut)
+	results.dryRun = dryRun
+	stopProgress := reportProgress(results)
 	deleted := wrap.DeleteObjects(listCh)
+	// versions counts the versions deleted of every key, which are reported
+	// once all of them were
+	versions := make(map[string]int)
+	// wouldDelete holds the keys a dry run would delete, which the directory
+	// markers are then considered empty without
+	wouldDelete := make(map[string]bool)
 	for key := range deleted {
-		fmt.Printf("Deleted %s\n", key.FullKey)
+		if deleteEmptyPrefixes && dryRun {
+			wouldDelete[key.FullKey] = true
+		}
+		if includeVersions {
+			versions[key.FullKey]++
+			results.Result("delete", key, "", "")
+		} else if dryRun {
+			results.Result("delete", key, "", fmt.Sprintf("Would delete %s\n", key.FullKey))
+		} else {
+			results.Result("delete", key, "", fmt.Sprintf("Deleted %s\n", key.FullKey))
+		}
+	}
+	if deleteEmptyPrefixes {
+		if err := deleteEmptyMarkers(wrap, s3Uris, delimiter, wouldDelete, results); err != nil {
+			stopProgress()
+			return err
+		}
+	}
+	stopProgress()
+	if includeVersions && !results.json {
+		reportVersions(versions, dryRun)
+	}
+	results.Summary()
+	if olderThan > 0 && !dryRun && !results.json {
+		objects, bytes := results.totals()
+		fmt.Fprintf(statusOut, "Reclaimed %s objects (%s)\n", humanize.Comma(objects), humanize.Bytes(uint64(bytes)))
+	}
+	return wrap.Err()
+}
+
+// deleteEmptyMarkers deletes the directory markers under s3Uris, the empty
+// keys ending with delimiter, which no other object is under once the keys
+// of deleted are gone, and reports their number. Everything under s3Uris is
+// listed whatever the listing filters, since an object they leave out still
+// keeps its prefix from being empty.
+func deleteEmptyMarkers(wrap *s3wrapper.S3Wrapper, s3Uris []string, delimiter string, deleted map[string]bool, results *emitter) error {
+	listCh := wrap.ListAll(expandS3URIs(s3Uris), true, delimiter, "")
+	var markers []*s3wrapper.ListOutput
+	// nonEmpty holds every prefix with an object other than a marker under
+	// it, so markers under which there are only empty markers are empty too
+	nonEmpty := make(map[string]bool)
+	for itm := range listCh {
+		if itm.IsPrefix || deleted[itm.FullKey] {
+			continue
+		}
+		if itm.Size == 0 && strings.HasSuffix(itm.Key, delimiter) {
+			markers = append(markers, itm)
+			continue
+		}
+		for end := 0; ; {
+			next := strings.Index(itm.Key[end:], delimiter)
+			if next < 0 {
+				break
+			}
+			end += next + len(delimiter)
+			nonEmpty[s3wrapper.FormatS3Uri(itm.Bucket, itm.Key[:end])] = true
+		}
+	}
+	// a partial listing would leave objects out and their prefixes look empty
+	if err := wrap.Err(); err != nil {
+		return err
+	}
+
+	emptyMarkers := make(chan *s3wrapper.ListOutput, len(markers))
+	for _, marker := range markers {
+		if !nonEmpty[marker.FullKey] {
+			emptyMarkers <- marker
+		}
+	}
+	close(emptyMarkers)
+
+	var removed int64
+	for marker := range wrap.DeleteObjects(emptyMarkers) {
+		removed++
+		if results.dryRun {
+			results.Result("delete", marker, "", fmt.Sprintf("Would delete directory marker %s\n", marker.FullKey))
+		} else {
+			results.Result("delete", marker, "", fmt.Sprintf("Deleted directory marker %s\n", marker.FullKey))
+		}
+	}
+	if !results.json {
+		verb := "Removed"
+		if results.dryRun {
+			verb = "Would remove"
+		}
+		fmt.Fprintf(statusOut, "%s %s empty directory markers\n", verb, humanize.Comma(removed))
 	}
 	return nil
 }
 
+// reportVersions writes the number of versions deleted of every key, sorted
+// by key
+func reportVersions(versions map[string]int, dryRun bool) {
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	for _, key := range keys {
+		fmt.Fprintf(statusOut, "%s %s versions of %s\n", verb, humanize.Comma(int64(versions[key])), key)
+	}
+}
+
+// filterModifiedBefore sends the objects of listCh last modified before
+// cutoff to the returned channel, prefixes are dropped and so are the
+// objects whose modification time isn't known, such as inventory rows
+// without one
+func filterModifiedBefore(listCh chan *s3wrapper.ListOutput, cutoff time.Time) chan *s3wrapper.ListOutput {
+	filtered := make(chan *s3wrapper.ListOutput, 10000)
+	go func() {
+		defer close(filtered)
+		for itm := range listCh {
+			if itm.IsPrefix {
+				continue
+			}
+			if itm.LastModified.IsZero() {
+				logger.Printf("WARN: not deleting %s, its modification time is unknown\n", itm.FullKey)
+				continue
+			}
+			if itm.LastModified.Before(cutoff) {
+				filtered <- itm
+			}
+		}
+	}()
+	return filtered
+}
+
+// parseAge parses an age such as 90d, 2w or 36h, on top of the units of
+// time.ParseDuration it accepts days (d) and weeks (w) on their own
+func parseAge(age string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, err := strconv.Atoi(strings.TrimSuffix(age, suffix)); strings.HasSuffix(age, suffix) && err == nil && n > 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	if d, err := time.ParseDuration(age); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid age %q, expected a number of days such as 90d, of weeks such as 2w or a duration such as 36h", age)
+}
+
 func init() {
 	rootCmd.AddCommand(rmCmd)
 
-	rmCmd.Flags().BoolP("recursive", "r", false, "Get all keys for this prefix")
+	rmCmd.Flags().BoolP("recursive", "r", false, "Delete all keys for this prefix")
+	rmCmd.Flags().BoolP("dry-run", "n", false, "Print what would be deleted without deleting anything")
+	rmCmd.Flags().String("older-than", "", "Only delete the objects last modified more than this long ago, such as 90d, 2w or 36h, this is a dry run unless --yes is given")
+	rmCmd.Flags().Bool("delete-empty-prefixes", false, "Then delete the directory markers, empty keys ending with the delimiter, left under the uris with no other object under them")
+	rmCmd.Flags().Bool("include-versions", false, "Permanently delete every version of the listed keys, delete markers included, instead of adding delete markers, this is a dry run unless --yes is given")
+	rmCmd.Flags().Bool("yes", false, "Confirm the deletions of --older-than and --include-versions")
 }
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+func TestRmRegions(t *testing.T) {
+	withCleanGlobals(t)
+	// the buckets are only used by this test so their regions are looked up
+	fake, svc := newRegionalFakeS3(t, map[string]string{
+		"rm-east/a.txt": "a",
+		"rm-east/b.txt": "b",
+		"rm-west/c.txt": "c",
+		"rm-west/d.txt": "d",
+	}, map[string]string{"rm-east": "us-east-1", "rm-west": "us-west-2"})
+	err := Rm(svc, []string{"s3://rm-east/", "s3://rm-west/"}, true, "/", 0, "", 0, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.keys(); len(keys) != 0 {
+		t.Errorf("left %v", keys)
+	}
+	// every bucket is listed and deleted from in its own region
+	if got, want := fake.servedIn("us-west-2"), []string{"GET rm-west/", "POST rm-west/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in us-west-2, want %v", got, want)
+	}
+	if got, want := fake.servedIn("us-east-1"), []string{"GET rm-east/", "POST rm-east/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v in us-east-1, want %v", got, want)
+	}
+}
+
+func TestFilterModifiedBefore(t *testing.T) {
+	_, stderr := withCleanGlobals(t)
+	now := time.Now()
+	listCh := make(chan *s3wrapper.ListOutput, 4)
+	listCh <- &s3wrapper.ListOutput{FullKey: "s3://bk/old", LastModified: now.Add(-48 * time.Hour)}
+	listCh <- &s3wrapper.ListOutput{FullKey: "s3://bk/new", LastModified: now}
+	listCh <- &s3wrapper.ListOutput{FullKey: "s3://bk/dir/", IsPrefix: true}
+	// inventory rows may have no modification time
+	listCh <- &s3wrapper.ListOutput{FullKey: "s3://bk/undated"}
+	close(listCh)
+	var got []string
+	for itm := range filterModifiedBefore(listCh, now.Add(-24*time.Hour)) {
+		got = append(got, itm.FullKey)
+	}
+	if want := []string{"s3://bk/old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !strings.Contains(stderr.String(), "not deleting s3://bk/undated") {
+		t.Errorf("got %q, want a warning about s3://bk/undated", stderr.String())
+	}
+}
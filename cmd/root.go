@@ -1,13 +1,21 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/metaverse/fasts3/s3wrapper"
 	"github.com/spf13/cobra"
 )
 
@@ -16,6 +24,21 @@ var rootCmd = &cobra.Command{
 	Use:   "fasts3",
 	Short: "A faster S3 utility",
 	Long:  ``,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateDelimiter(cmd); err != nil {
+			return err
+		}
+		if sseCustomerKeyFlag != "" {
+			var err error
+			if sseCustomerKey, err = loadSSECustomerKey(sseCustomerKeyFlag); err != nil {
+				return err
+			}
+		}
+		if err := validateOutput(); err != nil {
+			return err
+		}
+		return applyOnlyShowErrors()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if showVersion, err := cmd.Flags().GetBool("version"); err == nil && showVersion {
 			versionCmd.Run(cmd, args)
@@ -27,33 +50,143 @@ var rootCmd = &cobra.Command{
 
 var (
 	s3Client *s3.S3
+	// ctx is cancelled on the first interrupt so no new work gets started
+	ctx = context.Background()
+	// logger receives the diagnostics of every wrapper a command creates
+	logger s3wrapper.Logger = log.New(statusOut, "", log.Lshortfile)
+	// wrapperErrs collects the errors of every wrapper a command creates
+	wrapperErrs = &s3wrapper.Errors{}
 
 	keyRegex               string
+	excludePrefixes        []string
 	delimiter              string
 	searchDepth            int
 	maxParallel            int
+	concurrencyAuto        bool
+	minParallel            int
 	endpoint               string
+	region                 string
 	usePathStyleAddressing bool
+	requestPayer           string
+	output                 string
+	progressInterval       time.Duration
+	forceProgress          bool
+	summaryJSON            bool
+	summaryFile            string
+	onlyShowErrors         bool
+	limit                  int
+	listConcurrency        int
+	transferConcurrency    int
+	prefetch               int
+	maxListPages           int
+	maxRetries             int
+	noRetryWrites          bool
+	maxBackoff             time.Duration
+	fromInventory          string
+	recursiveDepth         int
+	insecureSkipVerify     bool
+	caBundle               string
+	sseCustomerKeyFlag     string
+	// sseCustomerKey is the key loaded from --sse-c-key
+	sseCustomerKey []byte
+	// bucketRegions maps buckets to the region given for them with
+	// --source-region or --dest-region
+	bucketRegions map[string]string
 )
 
 func init() {
 	rootCmd.Flags().Bool("version", false, "Show the version")
 	rootCmd.PersistentFlags().StringVar(&keyRegex, "key-regex", "", "Regex filter for keys")
+	rootCmd.PersistentFlags().StringArrayVar(&excludePrefixes, "exclude-prefix", nil, "Skip the keys under this prefix, either a S3 uri or a path relative to the listed uris such as _temporary/, with --search-depth the prefixes found are skipped without listing them, can be repeated")
+	rootCmd.PersistentFlags().Var(&keyGlobFlag{exclude: true}, "exclude", "Skip the keys matching this glob, matched like path.Match against the key without s3://<bucket>/ so * doesn't match /, e.g. 'tmp/*' or '*/*.log', can be repeated and is combined with --include in order, the last matching pattern deciding, keys must also match --key-regex")
+	rootCmd.PersistentFlags().Var(&keyGlobFlag{}, "include", "Keep the keys matching this glob even when an earlier --exclude matched them, e.g. --exclude '*' --include '*.csv', keys no pattern matches are kept, can be repeated")
 	rootCmd.PersistentFlags().StringVar(&delimiter, "delimiter", "/", "Delimiter to use while listing")
 	rootCmd.PersistentFlags().IntVar(&searchDepth, "search-depth", 0, "Dictates how many prefix groups to walk down")
 	rootCmd.PersistentFlags().IntVarP(&maxParallel, "max-parallel", "p", 10, "Maximum number of calls to make to S3 simultaneously")
-	rootCmd.PersistentFlags().StringVar(&endpoint, "endpoint", "", "endpoint to make S3 requests against")
+	rootCmd.PersistentFlags().BoolVar(&concurrencyAuto, "concurrency-auto", false, "Tune the number of per-object calls made to S3 simultaneously between --min-parallel and --max-parallel (or --transfer-concurrency), lowering it when S3 throttles and raising it while calls succeed")
+	rootCmd.PersistentFlags().IntVar(&minParallel, "min-parallel", 1, "Minimum number of calls to make to S3 simultaneously with --concurrency-auto")
+	rootCmd.PersistentFlags().IntVar(&recursiveDepth, "recursive-depth", 0, "With --recursive, skip the objects more than this many delimiter separated segments below the given uris, 0 means no limit")
+	rootCmd.PersistentFlags().IntVar(&limit, "limit", 0, "Stop after this many objects have been listed, 0 means no limit")
+	rootCmd.PersistentFlags().IntVar(&listConcurrency, "list-concurrency", 0, "Maximum number of list calls to make to S3 simultaneously, defaults to --max-parallel")
+	rootCmd.PersistentFlags().IntVar(&transferConcurrency, "transfer-concurrency", 0, "Maximum number of per-object calls (get, copy, delete, ...) to make to S3 simultaneously, defaults to --max-parallel")
+	rootCmd.PersistentFlags().IntVar(&prefetch, "prefetch", s3wrapper.DefaultPrefetch, "Number of listed keys to buffer ahead of the calls transferring them, so listing never waits on transfers")
+	rootCmd.PersistentFlags().IntVar(&maxListPages, "max-list-pages", 0, "Abort the listing of a prefix with an error once it has listed this many pages of 1000 keys, guarding against prefixes with many more keys than expected, each prefix found with --search-depth is capped on its own, 0 means no limit")
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", 3, "Maximum number of times to retry a call which failed with a retryable error such as throttling")
+	rootCmd.PersistentFlags().BoolVar(&noRetryWrites, "no-retry-writes", false, "Never retry calls which write to S3 (copies, deletes, tag and ACL changes)")
+	rootCmd.PersistentFlags().DurationVar(&maxBackoff, "max-backoff", s3wrapper.DefaultMaxBackoff, "Maximum delay between two retries")
+	rootCmd.PersistentFlags().StringVar(&fromInventory, "from-inventory", "", "S3 uri of the manifest.json of a CSV S3 Inventory report to read the objects from instead of listing them")
+	rootCmd.PersistentFlags().StringVar(&sseCustomerKeyFlag, "sse-c-key", "", "Customer provided key (SSE-C) to read and write objects with, either base64 encoded or the path of a file holding it, copies use it for both their source and destination")
+	rootCmd.PersistentFlags().StringVar(&endpoint, "endpoint", "", "endpoint to make S3 requests against, either a host or a http:// or https:// URL")
+	rootCmd.PersistentFlags().BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "Don't verify the TLS certificate of S3, e.g. for on-prem endpoints with self-signed certificates")
+	rootCmd.PersistentFlags().StringVar(&caBundle, "ca-bundle", "", "Path of a PEM file of the certificate authorities to trust for the TLS certificate of S3 instead of the system ones")
+	rootCmd.PersistentFlags().StringVar(&region, "region", "", "region to make S3 requests against, region autodetection is disabled when used with --endpoint")
 	rootCmd.PersistentFlags().BoolVar(&usePathStyleAddressing, "path-style-addressing", false, "enables path-style addressing (deprecated in normal AWS environments)")
+	rootCmd.PersistentFlags().StringVar(&output, "output", outputText, "format of the per-object results and summary, one of text or json")
+	rootCmd.PersistentFlags().DurationVar(&progressInterval, "progress-interval", 5*time.Second, "how often get, cp, rm and stream report their progress to stderr, 0 disables it (disabled by default when stderr isn't a terminal or the CI environment variable is set)")
+	rootCmd.PersistentFlags().BoolVar(&forceProgress, "force-progress", false, "report progress even when stderr isn't a terminal or the CI environment variable is set")
+	rootCmd.PersistentFlags().BoolVar(&summaryJSON, "summary-json", false, "write a single JSON object with the number of objects, bytes, errors, duration and rate of the command to stderr once it completes, whatever the output format")
+	rootCmd.PersistentFlags().StringVar(&summaryFile, "summary-file", "", "write the summary of --summary-json to this file instead of stderr, implies --summary-json")
+	rootCmd.PersistentFlags().BoolVar(&onlyShowErrors, "only-show-errors", false, "only write errors to stderr, without per-object statuses, warnings, progress or summaries, the exit code still tells whether any error occurred")
+	rootCmd.PersistentFlags().StringVar(&requestPayer, "request-payer", "", "confirms the requester will pay for requests to requester-pays buckets (only 'requester' is supported)")
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	ctx = handleInterrupts()
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// concurrencyOr returns override if it is set and --max-parallel otherwise
+func concurrencyOr(override int) int {
+	if override > 0 {
+		return override
+	}
+	return maxParallel
+}
+
+// handleInterrupts returns a context which is cancelled on the first SIGINT,
+// letting in-flight operations finish and their results print, a second
+// SIGINT exits immediately
+func handleInterrupts() context.Context {
+	interruptCtx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		fmt.Fprintln(statusOut, "Interrupted, waiting for in-flight operations to finish (interrupt again to exit immediately)")
+		cancel()
+		<-sigs
+		os.Exit(130)
+	}()
+	return interruptCtx
+}
+
+// newS3Wrapper creates a S3Wrapper for svc configured from the global flags,
+// its concurrency is the one of the per-object phase of a command, adjusted
+// to throttling with --concurrency-auto
+func newS3Wrapper(svc *s3.S3) *s3wrapper.S3Wrapper {
+	wrap := s3wrapper.New(svc, concurrencyOr(transferConcurrency))
+	if concurrencyAuto {
+		wrap = wrap.WithAdaptiveConcurrency(minParallel, concurrencyOr(transferConcurrency))
+	}
+	for bucket, region := range bucketRegions {
+		wrap = wrap.WithBucketRegion(bucket, region)
+	}
+	return wrap.
+		WithRequestPayer(requestPayer).
+		WithContext(ctx).
+		WithLogger(logger).
+		WithRetries(maxRetries, !noRetryWrites).
+		WithMaxBackoff(maxBackoff).
+		WithPrefetch(prefetch).
+		WithMaxListPages(maxListPages).
+		WithSSECustomerKey(sseCustomerKey).
+		WithErrors(wrapperErrs)
+}
+
 func GetS3Client() *s3.S3 {
 	awsSession, err := session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
@@ -65,9 +198,27 @@ func GetS3Client() *s3.S3 {
 
 	config := aws.NewConfig()
 	if endpoint != "" {
+		if err := validateEndpoint(endpoint); err != nil {
+			log.Fatal(err)
+		}
 		config = config.WithEndpoint(endpoint)
 	}
+	httpClient, err := tlsHTTPClient(insecureSkipVerify, caBundle)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if httpClient != nil {
+		config = config.WithHTTPClient(httpClient)
+	}
+	if region != "" {
+		config = config.WithRegion(region)
+	}
 	config = config.WithS3ForcePathStyle(usePathStyleAddressing)
+	// retries are left to the wrapper, which knows which calls are safe to retry
+	config = config.WithMaxRetries(0)
+	if creds := webIdentityCredentials(awsSession); creds != nil {
+		config = config.WithCredentials(creds)
+	}
 
 	return s3.New(awsSession, config)
 }
@@ -81,7 +232,7 @@ func validateS3URIs(pArgs ...cobra.PositionalArgs) func(cmd *cobra.Command, args
 			}
 		}
 
-		for _, a := range args {
+		for _, a := range expandS3URIs(args) {
 			hasMatch, err := regexp.MatchString("^s3://", a)
 			if err != nil {
 				return err
@@ -93,3 +244,136 @@ func validateS3URIs(pArgs ...cobra.PositionalArgs) func(cmd *cobra.Command, args
 		return nil
 	}
 }
+
+// validateDelimiter checks --delimiter isn't empty for commands which list
+// non-recursively, without a delimiter there are no prefixes to stop at
+func validateDelimiter(cmd *cobra.Command) error {
+	if delimiter != "" {
+		return nil
+	}
+	if recursive := cmd.Flags().Lookup("recursive"); recursive != nil && recursive.Value.String() != "true" {
+		return fmt.Errorf("--delimiter can only be empty with --recursive")
+	}
+	return nil
+}
+
+// stdinS3URI is the argument standing for the S3 uris read from stdin
+const stdinS3URI = "-"
+
+// readsStdin tells whether cmd reads S3 uris from stdin, either because
+// --stdin was given or one of args is -
+func readsStdin(cmd *cobra.Command, args []string) bool {
+	if fromStdin, err := cmd.Flags().GetBool("stdin"); err == nil && fromStdin {
+		return true
+	}
+	for _, arg := range args {
+		if arg == stdinS3URI {
+			return true
+		}
+	}
+	return false
+}
+
+// withStdinS3URIs replaces the - in args, or appends when --stdin was given,
+// with the S3 uris read from stdin one per line, blank lines and lines
+// starting with # are ignored
+func withStdinS3URIs(cmd *cobra.Command, args []string, stdin io.Reader) ([]string, error) {
+	if !readsStdin(cmd, args) {
+		return args, nil
+	}
+
+	stdinUris := make([]string, 0)
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		stdinUris = append(stdinUris, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read S3 uris from stdin: %s", err)
+	}
+
+	s3Uris := make([]string, 0, len(args)+len(stdinUris))
+	for _, arg := range args {
+		if arg != stdinS3URI {
+			s3Uris = append(s3Uris, arg)
+		}
+	}
+	s3Uris = append(s3Uris, stdinUris...)
+	if err := validateS3URIs(cobra.MinimumNArgs(1))(cmd, s3Uris); err != nil {
+		return nil, err
+	}
+	return s3Uris, nil
+}
+
+// expandS3URIs expands shell style brace groups in each of the s3Uris, e.g.
+// s3://bucket/{2023,2024}/logs/ becomes s3://bucket/2023/logs/ and
+// s3://bucket/2024/logs/
+func expandS3URIs(s3Uris []string) []string {
+	expanded := make([]string, 0, len(s3Uris))
+	for _, uri := range s3Uris {
+		expanded = append(expanded, expandBraces(uri)...)
+	}
+	return expanded
+}
+
+// expandBraces expands the first brace group of s which contains a comma
+// and then recurses on each alternative, this handles both multiple and
+// nested groups. Groups without a comma and unbalanced braces are left as is.
+func expandBraces(s string) []string {
+	depth := 0
+	start := -1
+	for i, c := range s {
+		switch c {
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			alternatives := splitTopLevelCommas(s[start+1 : i])
+			if len(alternatives) < 2 {
+				continue
+			}
+			expanded := make([]string, 0, len(alternatives))
+			for _, alternative := range alternatives {
+				expanded = append(expanded, expandBraces(s[:start]+alternative+s[i+1:])...)
+			}
+			return expanded
+		}
+	}
+	return []string{s}
+}
+
+// splitTopLevelCommas splits s on the commas which aren't inside a nested
+// brace group
+func splitTopLevelCommas(s string) []string {
+	parts := make([]string, 0)
+	depth := 0
+	last := 0
+	for i, c := range s {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, s[last:])
+}
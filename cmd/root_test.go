@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"no group", "s3://bk/logs/", []string{"s3://bk/logs/"}},
+		{"single group", "s3://bk/{2023,2024}/logs/", []string{"s3://bk/2023/logs/", "s3://bk/2024/logs/"}},
+		{"multiple groups", "s3://bk/{a,b}/{1,2}", []string{"s3://bk/a/1", "s3://bk/a/2", "s3://bk/b/1", "s3://bk/b/2"}},
+		{"nested group", "s3://bk/{a,b{1,2}}/", []string{"s3://bk/a/", "s3://bk/b1/", "s3://bk/b2/"}},
+		{"nested group alone", "s3://bk/{{a,b},c}", []string{"s3://bk/a", "s3://bk/b", "s3://bk/c"}},
+		{"empty alternative", "s3://bk/logs{,-old}/", []string{"s3://bk/logs/", "s3://bk/logs-old/"}},
+		{"empty alternatives", "s3://bk/{,}", []string{"s3://bk/", "s3://bk/"}},
+		// groups without a comma aren't expanded, like in a shell
+		{"empty group", "s3://bk/{}/a", []string{"s3://bk/{}/a"}},
+		{"group without comma", "s3://bk/{a}/{b,c}", []string{"s3://bk/{a}/b", "s3://bk/{a}/c"}},
+		// unbalanced braces are kept as they are
+		{"unclosed group", "s3://bk/{a,b", []string{"s3://bk/{a,b"}},
+		{"unopened group", "s3://bk/a,b}", []string{"s3://bk/a,b}"}},
+		{"extra closing brace", "s3://bk/{a,b}}", []string{"s3://bk/a}", "s3://bk/b}"}},
+		{"extra opening brace", "s3://bk/{{a,b}", []string{"s3://bk/{{a,b}"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := expandBraces(test.in); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expandBraces(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitTopLevelCommas(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{""}},
+		{"a", []string{"a"}},
+		{"a,b", []string{"a", "b"}},
+		{",", []string{"", ""}},
+		{"a,{b,c},d", []string{"a", "{b,c}", "d"}},
+		{"{a,{b,c}},d", []string{"{a,{b,c}}", "d"}},
+		// a stray closing brace doesn't hide the commas after it
+		{"a},b", []string{"a}", "b"}},
+		{"{a,b", []string{"{a,b"}},
+	}
+	for _, test := range tests {
+		if got := splitTopLevelCommas(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitTopLevelCommas(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestExpandS3URIs(t *testing.T) {
+	got := expandS3URIs([]string{"s3://bk/{a,b}/", "s3://other/c"})
+	want := []string{"s3://bk/a/", "s3://bk/b/", "s3://other/c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// sseCustomerKeySize is the size of the AES-256 keys of SSE-C
+const sseCustomerKeySize = 32
+
+// loadSSECustomerKey loads the SSE-C key given to --sse-c-key, which is
+// either the path of a file holding the raw or base64 encoded key or the
+// base64 encoded key itself
+func loadSSECustomerKey(value string) ([]byte, error) {
+	encoded := value
+	if info, err := os.Stat(value); err == nil && info.Mode().IsRegular() {
+		content, err := ioutil.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read --sse-c-key file %s: %s", value, err)
+		}
+		if len(content) == sseCustomerKeySize {
+			return content, nil
+		}
+		encoded = strings.TrimSpace(string(content))
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --sse-c-key, expected a base64 encoded key or the path of a file holding one: %s", err)
+	}
+	if len(key) != sseCustomerKeySize {
+		return nil, fmt.Errorf("invalid --sse-c-key, SSE-C keys are %d bytes long, this one is %d", sseCustomerKeySize, len(key))
+	}
+	return key, nil
+}
+
+// addSSEFlags adds the flags choosing how written objects are encrypted to
+// cmd
+func addSSEFlags(cmd *cobra.Command) {
+	cmd.Flags().String("sse", "", "Server side encryption of the written objects, AES256 or aws:kms, the default encryption of the bucket is used otherwise")
+	cmd.Flags().String("sse-kms-key-id", "", "KMS key to encrypt the written objects with when --sse is aws:kms, defaults to the aws/s3 key")
+	cmd.Flags().StringToString("sse-kms-encryption-context", nil, "KMS encryption context of the written objects as key=value pairs when --sse is aws:kms")
+}
+
+// sseFlags reads the flags added by addSSEFlags
+func sseFlags(cmd *cobra.Command) (s3wrapper.ServerSideEncryption, error) {
+	var sse s3wrapper.ServerSideEncryption
+	var err error
+	if sse.Algorithm, err = cmd.Flags().GetString("sse"); err != nil {
+		return sse, err
+	}
+	if sse.KMSKeyID, err = cmd.Flags().GetString("sse-kms-key-id"); err != nil {
+		return sse, err
+	}
+	if sse.KMSEncryptionContext, err = cmd.Flags().GetStringToString("sse-kms-encryption-context"); err != nil {
+		return sse, err
+	}
+	if err := sse.Validate(); err != nil {
+		return sse, fmt.Errorf("invalid --sse flags: %s", err)
+	}
+	if sse.Algorithm != "" && sseCustomerKey != nil {
+		return sse, fmt.Errorf("--sse can't be used with --sse-c-key, which encrypts the written objects with the customer provided key")
+	}
+	return sse, nil
+}
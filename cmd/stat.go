@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// statCmd represents the stat command
+var statCmd = &cobra.Command{
+	Use:   "stat <S3 URIs>",
+	Short: "Show the metadata of S3 objects",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := Stat(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// Stat prints the metadata of S3 objects using svc, s3Uris is a list of prefixes/keys, recurse tells whether or not to
+// use everything under the prefixes, delimiter tells the delimiter to use when listing, searchDepth determines the number
+// of prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys
+func Stat(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string) error {
+	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	wrap, err := newS3Wrapper(svc).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("stat", dataOut)
+	for obj := range wrap.StatAll(listCh) {
+		if obj.IsPrefix {
+			continue
+		}
+		text := fmt.Sprintf("%s\n", obj.FullKey) +
+			fmt.Sprintf("  %-14s %d\n", "Size:", obj.Size) +
+			fmt.Sprintf("  %-14s %s\n", "LastModified:", obj.LastModified.Format("2006-01-02T15:04:05")) +
+			fmt.Sprintf("  %-14s %s\n", "ContentType:", obj.ContentType) +
+			fmt.Sprintf("  %-14s %s\n", "ETag:", obj.ETag) +
+			fmt.Sprintf("  %-14s %s\n", "StorageClass:", obj.StorageClass) +
+			fmt.Sprintf("  %-14s %s\n", "Restore:", formatRestore(obj)) +
+			fmt.Sprintf("  %-14s %s\n", "Encryption:", obj.ServerSideEncryption) +
+			fmt.Sprintf("  %-14s %s\n", "VersionId:", obj.VersionID)
+		results.StatResult("stat", obj, text)
+	}
+	results.Summary()
+	return wrap.Err()
+}
+
+// formatRestore formats the restore status of obj, including the expiry of
+// restored objects
+func formatRestore(obj *s3wrapper.StatOutput) string {
+	if obj.RestoreStatus == s3wrapper.RestoreRestored && !obj.RestoreExpiry.IsZero() {
+		return fmt.Sprintf("%s-until-%s", obj.RestoreStatus, obj.RestoreExpiry.Format("2006-01-02T15:04:05"))
+	}
+	return obj.RestoreStatus
+}
+
+func init() {
+	rootCmd.AddCommand(statCmd)
+
+	statCmd.Flags().BoolP("recursive", "r", false, "Show the metadata of all keys for this prefix")
+}
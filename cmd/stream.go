@@ -3,9 +3,11 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/metaverse/fasts3/s3wrapper"
 	"github.com/spf13/cobra"
 )
@@ -24,70 +26,225 @@ var streamCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
+		orderedBufferFlag, err := cmd.Flags().GetString("ordered-buffer")
+		if err != nil {
+			log.Fatal(err)
+		}
+		orderedBuffer, err := humanize.ParseBytes(orderedBufferFlag)
+		if err != nil {
+			log.Fatalf("invalid --ordered-buffer %q: %s", orderedBufferFlag, err)
+		}
 		raw, err := cmd.Flags().GetBool("raw")
 		if err != nil {
 			log.Fatal(err)
 		}
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		lineNumbers, err := cmd.Flags().GetBool("line-numbers")
+		if err != nil {
+			log.Fatal(err)
+		}
+		jsonl, err := cmd.Flags().GetBool("jsonl")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if jsonl && raw {
+			log.Fatal("--jsonl can't be used with --raw, only lines can be turned into JSON")
+		}
+		stats, err := cmd.Flags().GetBool("stats")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if stats && jsonl {
+			log.Fatal("--stats can't be used with --jsonl, it writes counts instead of lines")
+		}
+		tar, err := cmd.Flags().GetBool("tar")
+		if err != nil {
+			log.Fatal(err)
+		}
+		tarList, err := cmd.Flags().GetBool("tar-list")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if (tar || tarList) && (raw || jsonl || lineNumbers || stats) {
+			log.Fatal("--tar and --tar-list can't be used with --raw, --jsonl, --line-numbers or --stats")
+		}
+		separator, err := cmd.Flags().GetString("separator")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if separator != "" && (jsonl || stats) {
+			log.Fatal("--separator can't be used with --jsonl or --stats")
+		}
+		follow, err := cmd.Flags().GetBool("follow")
+		if err != nil {
+			log.Fatal(err)
+		}
+		pollInterval, err := cmd.Flags().GetDuration("poll-interval")
+		if err != nil {
+			log.Fatal(err)
+		}
+		checksumMode, err := checksumModeFlag(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		maxSize, err := maxObjectSizeFlags(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		err = Stream(
 			GetS3Client(),
 			args,
+			recursive,
 			delimiter,
 			searchDepth,
 			includeKeyName,
 			keyRegex,
 			ordered,
-			raw)
+			int64(orderedBuffer),
+			raw,
+			lineNumbers,
+			jsonl,
+			stats,
+			tar || tarList,
+			tarList,
+			unescapeSeparator(separator),
+			follow,
+			pollInterval,
+			checksumMode,
+			maxSize)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Encountered an error: %s\n", err)
-			return
+			log.Fatalf("Encountered an error: %s", err)
 		}
 	},
 }
 
 // Stream streams S3 Key content to stdout using the svc, s3Uris specifies the
-// S3 Prefixes/Keys to stream, delimiter tells the delimiter to use when listing,
+// S3 Prefixes/Keys to stream, recurse tells whether or not to stream everything
+// under s3Uris, delimiter tells the delimiter to use when listing,
 // searchDepth determines how many prefixes to list before parallelizing list
 // calls, includeKeyName will prefix each line with the key in which the line
 // came from, keyRegex is a regex filter on Keys, ordered determines whether the
 // lines can be inter-mingled with lines from other files or must be in order
-// (helpful for parsing binary files), raw is a boolean for determining whether
-// to output the raw data of each file instead of lines
+// (helpful for parsing binary files), orderedBuffer is the number of bytes
+// ordered streaming may buffer for the keys downloaded ahead of the one being
+// written, raw is a boolean for determining whether
+// to output the raw data of each file instead of lines, lineNumbers prefixes
+// each line with its line number within its key, jsonl writes each line as a
+// JSON object holding the line and its key, stats writes the line, word and
+// byte counts of each key, and their total, instead of its content like wc,
+// tar reads each key as a tar archive, decompressing .tar.gz, .tar.bz2, .tar.zst and .tgz keys,
+// and streams the lines of its files, or only their names when tarList,
+// separator is written between keys, or before every key when it contains
+// {key} which is replaced by the uri of the key, it implies ordered,
+// follow keeps listing every
+// pollInterval and streams the keys which are new or were modified until
+// interrupted, checksumMode validates the additional checksum of each key
+// once it was streamed, maxSize skips or stops at the keys larger than its
+// size
 func Stream(
 	svc *s3.S3,
 	s3Uris []string,
+	recurse bool,
 	delimiter string,
 	searchDepth int,
 	includeKeyName bool,
 	keyRegex string,
 	ordered bool,
+	orderedBuffer int64,
 	raw bool,
+	lineNumbers bool,
+	jsonl bool,
+	stats bool,
+	tar bool,
+	tarList bool,
+	separator string,
+	follow bool,
+	pollInterval time.Duration,
+	checksumMode bool,
+	maxSize maxObjectSize,
 ) error {
-	listCh, err := Ls(svc, s3Uris, true, delimiter, searchDepth, keyRegex)
-	if err != nil {
-		return err
+	var listCh chan *s3wrapper.ListOutput
+	if follow {
+		listCh = Follow(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex, pollInterval)
+	} else {
+		var err error
+		listCh, err = Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+		if err != nil {
+			return err
+		}
 	}
-	wrap, err := s3wrapper.New(svc, maxParallel).WithRegionFrom(s3Uris[0])
+	wrap, err := newS3Wrapper(svc).WithChecksumMode(checksumMode).WithRegionFrom(s3Uris[0])
 	if err != nil {
 		return err
 	}
 
-	if ordered {
-		wrap.WithMaxConcurrency(1)
-	}
+	results := newEmitter("stream", statusOut)
+	stopProgress := reportProgress(results)
+	listCh, aborted := maxSize.filter(listCh, results)
+	countedCh := make(chan *s3wrapper.ListOutput, 10000)
+	go func() {
+		defer close(countedCh)
+		for key := range listCh {
+			results.Count(key)
+			countedCh <- key
+		}
+	}()
 
-	lines := wrap.Stream(listCh, includeKeyName, raw)
+	opts := s3wrapper.StreamOptions{
+		IncludeKeyName: includeKeyName,
+		Raw:            raw,
+		LineNumbers:    lineNumbers,
+		JSONL:          jsonl,
+		Stats:          stats,
+		Tar:            tar,
+		TarList:        tarList,
+		Separator:      separator,
+	}
+	var lines chan string
+	// the separators are written between keys, so their data can't mix
+	if ordered || separator != "" {
+		lines = wrap.StreamOrdered(countedCh, opts, orderedBuffer)
+	} else {
+		lines = wrap.Stream(countedCh, opts)
+	}
 	for line := range lines {
-		fmt.Print(line)
+		fmt.Fprint(dataOut, line)
+	}
+	stopProgress()
+	results.Summary()
+
+	if err := aborted(); err != nil {
+		return err
 	}
+	return wrap.Err()
+}
 
-	return nil
+// unescapeSeparator turns the \n, \t and \\ escapes of a --separator into
+// the characters they stand for
+func unescapeSeparator(separator string) string {
+	return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\\`, `\`).Replace(separator)
 }
 
 func init() {
 	rootCmd.AddCommand(streamCmd)
 
 	streamCmd.Flags().BoolP("include-key-name", "i", false, "Include the key name in streamed output")
-	streamCmd.Flags().BoolP("ordered", "o", false, "Read the keys in-order, not mixing output from different keys (this will reduce the parallelism to 1)")
-	streamCmd.Flags().BoolP("raw", "r", false, "Raw object stream (do not uncompress or delimit stream)")
+	streamCmd.Flags().BoolP("ordered", "o", false, "Write the keys in listing order, not mixing output from different keys, they are still downloaded in parallel")
+	streamCmd.Flags().String("ordered-buffer", "64MB", "Maximum amount of data --ordered buffers for the keys downloaded ahead of the one being written")
+	streamCmd.Flags().Bool("raw", false, "Raw object stream (do not uncompress or delimit stream)")
+	streamCmd.Flags().BoolP("follow", "f", false, "Keep listing every --poll-interval and stream new or modified keys until interrupted")
+	streamCmd.Flags().Duration("poll-interval", 10*time.Second, "How often --follow lists again")
+	streamCmd.Flags().BoolP("line-numbers", "n", false, "Prefix each line with its line number within its key (ignored with --raw)")
+	streamCmd.Flags().Bool("jsonl", false, `Write each line as a JSON object such as {"source":"s3://...","line":"..."}, including its "number" with --line-numbers`)
+	streamCmd.Flags().Bool("stats", false, "Write the line, word and byte counts of every key, decompressed unless --raw, and their total like wc instead of their content")
+	streamCmd.Flags().Bool("tar", false, "Read every key as a tar archive, decompressing .tar.gz, .tar.bz2, .tar.zst and .tgz keys, and stream the lines of its files one after the other")
+	streamCmd.Flags().String("separator", "", `Write this between the output of consecutive keys, such as "---\n", or before the output of every key when it contains {key} which is replaced by the key, such as "==> {key} <==\n", \n and \t are unescaped, implies --ordered`)
+	streamCmd.Flags().Bool("tar-list", false, "Stream the names of the members of tar archives instead of their content, like tar -t")
+	streamCmd.Flags().BoolP("recursive", "r", false, "Stream all keys for this prefix")
+	addChecksumModeFlag(streamCmd)
+	addMaxObjectSizeFlags(streamCmd)
 }
@@ -0,0 +1,476 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync <src> <dest>",
+	Short: "Transfer the files which changed between S3 and a local directory",
+	Long:  ``,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.ExactArgs(2)(cmd, args); err != nil {
+			return err
+		}
+		if isS3Uri(args[0]) == isS3Uri(args[1]) {
+			return fmt.Errorf("requires an S3 uri and a local directory, in either order")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		deleteExtra, err := cmd.Flags().GetBool("delete")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+		planExitCode, err := cmd.Flags().GetBool("plan-exit-code")
+		if err != nil {
+			log.Fatal(err)
+		}
+		showPlan, err := cmd.Flags().GetBool("plan")
+		if err != nil {
+			log.Fatal(err)
+		}
+		checksum, err := cmd.Flags().GetBool("checksum")
+		if err != nil {
+			log.Fatal(err)
+		}
+		partSizeFlag, err := cmd.Flags().GetString("part-size")
+		if err != nil {
+			log.Fatal(err)
+		}
+		var partSize int64
+		if partSizeFlag != "" {
+			if !checksum {
+				log.Fatal("--part-size can only be used with --checksum")
+			}
+			parsed, err := humanize.ParseBytes(partSizeFlag)
+			if err != nil || parsed == 0 {
+				log.Fatalf("invalid --part-size %q, expected a size such as 8MiB", partSizeFlag)
+			}
+			partSize = int64(parsed)
+		}
+		if showPlan || planExitCode {
+			plan, err := PlanSync(GetS3Client(), args[0], args[1], delimiter, searchDepth, keyRegex, deleteExtra, checksum, partSize)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printPlan(plan)
+			if planExitCode && plan.Pending() {
+				os.Exit(planPendingExitCode)
+			}
+			return
+		}
+		if err := Sync(GetS3Client(), args[0], args[1], delimiter, searchDepth, keyRegex, deleteExtra, checksum, partSize, dryRun); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// planPendingExitCode is the exit code of sync --plan-exit-code when the
+// plan changes anything, errors exit with 1
+const planPendingExitCode = 2
+
+// Sync transfers the files which changed from src to dest using svc, one of them is an S3 uri and the other a local
+// directory. A file is transferred when it is missing from dest, its size differs or src was modified after it.
+// delimiter separates the local directories in keys, searchDepth determines how many prefixes to list before
+// parallelizing list calls, keyRegex is a regex filter on keys, deleteExtra deletes the files in dest which aren't in
+// src, checksum compares the content of files and objects of the same size instead of their modification time, see
+// unchangedFile, partSize is the part size of the objects uploaded in parts it compares, 0 tries the common ones,
+// dryRun prints what would be transferred and deleted without changing anything
+func Sync(svc *s3.S3, src string, dest string, delimiter string, searchDepth int, keyRegex string, deleteExtra bool, checksum bool, partSize int64, dryRun bool) error {
+	return runSync(svc, src, dest, delimiter, searchDepth, keyRegex, deleteExtra, checksum, partSize, dryRun, nil)
+}
+
+// PlanSync returns what Sync would change without changing anything, the files or objects it would create, those
+// it would update and, with deleteExtra, those it would delete. The entries to create and update hold their source
+// in FullKey and their destination in Key, the entries to delete hold the file or uri deleted in FullKey.
+func PlanSync(svc *s3.S3, src string, dest string, delimiter string, searchDepth int, keyRegex string, deleteExtra bool, checksum bool, partSize int64) (*s3wrapper.Plan, error) {
+	plan := &s3wrapper.Plan{}
+	if err := runSync(svc, src, dest, delimiter, searchDepth, keyRegex, deleteExtra, checksum, partSize, false, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// runSync is Sync, only recording the changes into plan without making them
+// when it is set
+func runSync(svc *s3.S3, src string, dest string, delimiter string, searchDepth int, keyRegex string, deleteExtra bool, checksum bool, partSize int64, dryRun bool, plan *s3wrapper.Plan) error {
+	if delimiter == "" {
+		return fmt.Errorf("sync requires a --delimiter to map keys to the paths of files")
+	}
+	if deleteExtra && (keyRegex != "" || len(keyGlobs) > 0 || len(excludePrefixes) > 0 || limit > 0 || recursiveDepth > 0 || fromInventory != "") {
+		return fmt.Errorf("--delete can't be used with --key-regex, --include, --exclude, --exclude-prefix, --limit, --recursive-depth or --from-inventory, the files of the keys they leave out would be deleted")
+	}
+	if isS3Uri(src) {
+		return syncDown(svc, src, filepath.Clean(dest), delimiter, searchDepth, keyRegex, deleteExtra, checksum, partSize, dryRun, plan)
+	}
+	return syncUp(svc, filepath.Clean(src), dest, delimiter, searchDepth, keyRegex, deleteExtra, checksum, partSize, dryRun, plan)
+}
+
+// syncDown downloads the objects under src which changed to the directory
+// dest, see runSync
+func syncDown(svc *s3.S3, src string, dest string, delimiter string, searchDepth int, keyRegex string, deleteExtra bool, checksum bool, partSize int64, dryRun bool, plan *s3wrapper.Plan) error {
+	bucket, prefix := syncPrefix(src, delimiter)
+	listCh, err := Ls(svc, []string{"s3://" + bucket + "/" + prefix}, true, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+	wrap, err := newS3Wrapper(svc).WithRegionFrom(src)
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("sync", statusOut)
+	results.dryRun = dryRun
+	stopProgress := reportProgress(results)
+	// expected holds the local path of every listed object, which --delete
+	// keeps
+	expected := make(map[string]bool)
+	changed := make(chan *s3wrapper.ListOutput, 10000)
+	go func() {
+		defer close(changed)
+		for obj := range listCh {
+			if obj.IsPrefix || !strings.HasPrefix(obj.Key, prefix) || strings.HasSuffix(obj.Key, delimiter) {
+				continue
+			}
+			localPath := syncLocalPath(dest, prefix, obj.Key, delimiter)
+			expected[localPath] = true
+			info, err := os.Stat(localPath)
+			if err == nil && unchangedFile(wrap, localPath, info, obj, true, checksum, partSize) {
+				results.Skip()
+				continue
+			}
+			if plan != nil {
+				entry := *obj
+				entry.Key = localPath
+				if err == nil {
+					plan.Update = append(plan.Update, &entry)
+				} else {
+					plan.Create = append(plan.Create, &entry)
+				}
+				continue
+			}
+			if dryRun {
+				results.Result("download", obj, localPath, fmt.Sprintf("Would download %s -> %s\n", obj.FullKey, localPath))
+				continue
+			}
+			changed <- obj
+		}
+	}()
+
+	opts := s3wrapper.GetOptions{
+		Atomic: true,
+		// the files are written where expected says
+		PathFor: func(k *s3wrapper.ListOutput) string {
+			return syncLocalPath(dest, prefix, k.Key, delimiter)
+		},
+	}
+	// GetAll returns once changed was closed, so expected is complete
+	downloaded := wrap.GetAll(changed, opts)
+	for file := range downloaded {
+		results.Result("download", file, file.Key, fmt.Sprintf("Downloaded %s -> %s\n", file.FullKey, file.Key))
+	}
+	if deleteExtra {
+		// an incomplete listing would delete the files of the objects it
+		// missed
+		if err := wrap.Err(); err != nil {
+			stopProgress()
+			return fmt.Errorf("not deleting any file from %s: %s", dest, err)
+		}
+		if err := deleteLocalExtra(dest, expected, results, dryRun, plan); err != nil {
+			stopProgress()
+			return err
+		}
+	}
+	stopProgress()
+	if plan == nil {
+		results.Summary()
+	}
+	return wrap.Err()
+}
+
+// unchangedFile tells whether the file at localPath, of info, and obj are in
+// sync. They are when their sizes are equal and the source, obj when
+// download is set, wasn't modified after the destination. With checksum the
+// content of files and objects of the same size is compared instead, from
+// the MD5 of the file and the ETag of obj, see fileContentMatch. The objects
+// whose content can't be compared from their ETag are compared by
+// modification time like without checksum.
+func unchangedFile(wrap *s3wrapper.S3Wrapper, localPath string, info os.FileInfo, obj *s3wrapper.ListOutput, download bool, checksum bool, partSize int64) bool {
+	if info.Size() != obj.Size {
+		return false
+	}
+	if checksum {
+		match, err := fileContentMatch(wrap, localPath, info, obj, partSize)
+		if err != nil {
+			logger.Printf("WARN: unable to compare the content of %s and %s, comparing their modification time instead: %s\n", localPath, obj.FullKey, err)
+		} else if match == s3wrapper.ContentUnknown {
+			logger.Printf("WARN: the content of %s can't be compared to %s from its ETag, comparing their modification time instead\n", obj.FullKey, localPath)
+		} else {
+			return match == s3wrapper.ContentSame
+		}
+	}
+	if download {
+		return !obj.LastModified.After(info.ModTime())
+	}
+	return !info.ModTime().After(obj.LastModified)
+}
+
+// fileContentMatch compares the content of the file at localPath, of info,
+// to the one of obj from the ETag of obj. The ETag of an object uploaded in
+// parts is the MD5 of the MD5s of its parts followed by -N, it is compared to
+// the one of an upload of the file in parts of partSize, or of the common
+// part sizes giving N parts when partSize is 0. The match is unknown when
+// the part size isn't the one the object was uploaded with, or when no
+// common part size matches, and for objects encrypted with SSE-C whose ETag
+// isn't an MD5 of their content.
+func fileContentMatch(wrap *s3wrapper.S3Wrapper, localPath string, info os.FileInfo, obj *s3wrapper.ListOutput, partSize int64) (s3wrapper.ContentMatch, error) {
+	if etag := strings.Trim(obj.ETag, `"`); strings.Contains(etag, "-") && sseCustomerKey == nil {
+		if partSize <= 0 {
+			// another part size than the common ones may give N parts
+			matches, err := s3wrapper.MatchesETag(localPath, etag, 0)
+			if err != nil || !matches {
+				return s3wrapper.ContentUnknown, err
+			}
+			return s3wrapper.ContentSame, nil
+		}
+		local, err := s3wrapper.MultipartETag(localPath, partSize)
+		if err != nil {
+			return s3wrapper.ContentUnknown, err
+		}
+		switch {
+		case local == etag:
+			return s3wrapper.ContentSame, nil
+		case local[strings.LastIndex(local, "-"):] != etag[strings.LastIndex(etag, "-"):]:
+			// a different number of parts means another part size
+			return s3wrapper.ContentUnknown, nil
+		}
+		return s3wrapper.ContentDifferent, nil
+	}
+	etag, err := s3wrapper.FileETag(localPath)
+	if err != nil {
+		return s3wrapper.ContentUnknown, err
+	}
+	file := &s3wrapper.ListOutput{Key: localPath, FullKey: localPath, Size: info.Size(), ETag: etag}
+	// the objects aren't read, files can't be
+	return wrap.SameContent(file, obj, false)
+}
+
+// syncLocalPath returns the path under dest of the file of key, which is
+// under prefix. The path is cleaned like the ones filepath.WalkDir returns so
+// that deleteLocalExtra can find it, whatever the form of dest.
+func syncLocalPath(dest string, prefix string, key string, delimiter string) string {
+	return filepath.Join(dest, s3wrapper.LocalPath(s3wrapper.RelativeKey(prefix, key, delimiter), delimiter))
+}
+
+// deleteLocalExtra deletes the files under dir which aren't expected, or adds
+// them to plan when set, the paths of expected must be built with
+// syncLocalPath
+func deleteLocalExtra(dir string, expected map[string]bool, results *emitter, dryRun bool, plan *s3wrapper.Plan) error {
+	return filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && filePath == dir {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || expected[filePath] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		file := &s3wrapper.ListOutput{Key: filePath, FullKey: filePath, Size: info.Size(), LastModified: info.ModTime()}
+		if plan != nil {
+			plan.Delete = append(plan.Delete, file)
+			return nil
+		}
+		if dryRun {
+			results.Result("delete", file, "", fmt.Sprintf("Would delete %s\n", filePath))
+			return nil
+		}
+		if err := os.Remove(filePath); err != nil {
+			return fmt.Errorf("unable to delete %s: %s", filePath, err)
+		}
+		results.Result("delete", file, "", fmt.Sprintf("Deleted %s\n", filePath))
+		return nil
+	})
+}
+
+// syncUp uploads the files under the local directory src which changed to
+// dest, see runSync
+func syncUp(svc *s3.S3, src string, dest string, delimiter string, searchDepth int, keyRegex string, deleteExtra bool, checksum bool, partSize int64, dryRun bool, plan *s3wrapper.Plan) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("unable to sync %s: it isn't a directory", src)
+	}
+	bucket, prefix := syncPrefix(dest, delimiter)
+	listCh, err := Ls(svc, []string{"s3://" + bucket + "/" + prefix}, true, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+	wrap, err := newS3Wrapper(svc).WithDryRun(dryRun).WithRegionFrom(dest)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]*s3wrapper.ListOutput)
+	for obj := range listCh {
+		if !obj.IsPrefix && strings.HasPrefix(obj.Key, prefix) && !strings.HasSuffix(obj.Key, delimiter) {
+			existing[obj.Key] = obj
+		}
+	}
+	if deleteExtra {
+		// an incomplete listing would miss the objects to delete
+		if err := wrap.Err(); err != nil {
+			return fmt.Errorf("not deleting any object from %s: %s", dest, err)
+		}
+	}
+
+	results := newEmitter("sync", statusOut)
+	results.dryRun = dryRun
+	stopProgress := reportProgress(results)
+	// seen holds the key of every local file, the other objects are deleted
+	// with --delete, Filter is only called by the goroutine walking src
+	seen := make(map[string]bool)
+	opts := s3wrapper.PutOptions{
+		Filter: func(localPath string, key string, size int64) bool {
+			seen[key] = true
+			obj, ok := existing[key]
+			if ok {
+				if info, err := os.Stat(localPath); err == nil && unchangedFile(wrap, localPath, info, obj, false, checksum, partSize) {
+					results.Skip()
+					return false
+				}
+			}
+			if plan != nil {
+				entry := &s3wrapper.ListOutput{Key: s3wrapper.FormatS3Uri(bucket, key), FullKey: localPath, Size: size, Bucket: bucket}
+				if ok {
+					plan.Update = append(plan.Update, entry)
+				} else {
+					plan.Create = append(plan.Create, entry)
+				}
+				return false
+			}
+			return true
+		},
+	}
+	for obj := range wrap.PutAll([]string{src}, "s3://"+bucket+"/"+prefix, delimiter, true, opts) {
+		if dryRun {
+			results.UploadResult("upload", obj, fmt.Sprintf("Would upload %s -> %s\n", obj.LocalPath, obj.FullKey))
+		} else {
+			results.UploadResult("upload", obj, fmt.Sprintf("Uploaded %s -> %s\n", obj.LocalPath, obj.FullKey))
+		}
+	}
+	if deleteExtra {
+		if err := wrap.Err(); err != nil {
+			stopProgress()
+			return fmt.Errorf("not deleting any object from %s: %s", dest, err)
+		}
+		extra := make(chan *s3wrapper.ListOutput, len(existing))
+		for key, obj := range existing {
+			if !seen[key] {
+				if plan != nil {
+					plan.Delete = append(plan.Delete, obj)
+				} else {
+					extra <- obj
+				}
+			}
+		}
+		close(extra)
+		for obj := range wrap.DeleteObjects(extra) {
+			if dryRun {
+				results.Result("delete", obj, "", fmt.Sprintf("Would delete %s\n", obj.FullKey))
+			} else {
+				results.Result("delete", obj, "", fmt.Sprintf("Deleted %s\n", obj.FullKey))
+			}
+		}
+	}
+	stopProgress()
+	if plan == nil {
+		results.Summary()
+	}
+	return wrap.Err()
+}
+
+// printPlan writes the changes of plan grouped by kind, followed by their
+// number and size, see PlanSync for what its entries hold
+func printPlan(plan *s3wrapper.Plan) {
+	results := newEmitter("sync", dataOut)
+	results.dryRun = true
+	groups := []struct {
+		action  string
+		title   string
+		entries []*s3wrapper.ListOutput
+	}{
+		{"create", "To create", plan.Create},
+		{"update", "To update", plan.Update},
+		{"delete", "To delete", plan.Delete},
+	}
+	for _, group := range groups {
+		// the entries are found concurrently
+		sort.Slice(group.entries, func(i, j int) bool { return group.entries[i].FullKey < group.entries[j].FullKey })
+		if len(group.entries) > 0 && !results.json {
+			fmt.Fprintf(dataOut, "%s:\n", group.title)
+		}
+		for _, entry := range group.entries {
+			if group.action == "delete" {
+				results.Result(group.action, entry, "", fmt.Sprintf("  %s\n", entry.FullKey))
+			} else {
+				results.Result(group.action, entry, entry.Key, fmt.Sprintf("  %s -> %s\n", entry.FullKey, entry.Key))
+			}
+		}
+	}
+	if !results.json {
+		transferred, deleted := plan.Bytes()
+		fmt.Fprintf(dataOut, "Plan: %s to create, %s to update, %s to transfer, %s to delete, %s\n",
+			humanize.Comma(int64(len(plan.Create))), humanize.Comma(int64(len(plan.Update))), humanize.Bytes(uint64(transferred)),
+			humanize.Comma(int64(len(plan.Delete))), humanize.Bytes(uint64(deleted)))
+	}
+	results.Summary()
+}
+
+// syncPrefix returns the bucket of the S3 uri synced and the prefix of its
+// objects, which ends with delimiter unless it is the whole bucket
+func syncPrefix(s3Uri string, delimiter string) (string, string) {
+	bucket, prefix := s3wrapper.ParseS3Uri(s3Uri)
+	if prefix != "" && !strings.HasSuffix(prefix, delimiter) {
+		prefix += delimiter
+	}
+	return bucket, prefix
+}
+
+// isS3Uri tells whether arg is an S3 uri rather than a local path
+func isS3Uri(arg string) bool {
+	return strings.HasPrefix(arg, "s3://")
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+
+	syncCmd.Flags().Bool("delete", false, "Delete the files in the destination which aren't in the source")
+	syncCmd.Flags().Bool("dry-run", false, "Print what would be transferred and deleted without changing anything")
+	syncCmd.Flags().Bool("checksum", false, "Compare the content of files and objects of the same size, from the MD5 of the file and the ETag of the object, instead of their modification time. The ETag of objects uploaded in several parts is compared to the one of an upload of the file in parts of --part-size, those uploaded with another part size and those encrypted with SSE-C are still compared by modification time")
+	syncCmd.Flags().String("part-size", "", "With --checksum, the part size such as 8MiB the objects uploaded in parts were uploaded with, their ETag is compared to the one of an upload of the file in parts of that size, by default the part sizes of the usual uploaders (5MiB, 8MiB, 16MiB, 64MiB and 100MiB) are tried")
+	syncCmd.Flags().Bool("plan", false, "Print the files or objects which would be created, updated and deleted, grouped, along with their size, without changing anything")
+	syncCmd.Flags().Bool("plan-exit-code", false, fmt.Sprintf("Like --plan, exiting with %d when there is anything to change", planPendingExitCode))
+}
@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+// writeFiles creates the files of contents, keyed by their path under dir
+func writeFiles(t *testing.T, dir string, contents map[string]string) {
+	for name, content := range contents {
+		localPath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(localPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFiles returns the content of every file under dir keyed by its path
+// relative to dir
+func readFiles(t *testing.T, dir string) map[string]string {
+	contents := make(map[string]string)
+	err := filepath.Walk(dir, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(localPath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, localPath)
+		contents[filepath.ToSlash(rel)] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestSyncDownDeleteKeepsSyncedFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// dest is the destination given to sync, relative to the working
+		// directory unless absolute
+		dest string
+		// root is where dest is relative to the working directory
+		root string
+	}{
+		{"working directory", ".", ""},
+		{"dot slash", "./", ""},
+		{"relative", "site", "site"},
+		{"relative with trailing separator", "site/", "site"},
+		{"relative with dot", "./site/../site", "site"},
+		{"absolute", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			fake, svc := newFakeS3(t, map[string]string{
+				"bk/site/index.html":    "index",
+				"bk/site/css/main.css":  "css",
+				"bk/site/img/a/b/c.png": "png",
+			})
+			wd := t.TempDir()
+			t.Chdir(wd)
+			dest := test.dest
+			if dest == "" {
+				dest = filepath.Join(wd, "site")
+				test.root = "site"
+			}
+			// css/main.css is up to date so it is skipped, extra files are
+			// deleted
+			writeFiles(t, filepath.Join(wd, test.root), map[string]string{
+				"css/main.css": "css",
+				"old.html":     "old",
+				"img/old.png":  "old",
+			})
+
+			if err := Sync(svc, "s3://bk/site/", dest, "/", 0, "", true, false, 0, false); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{
+				"index.html":    "index",
+				"css/main.css":  "css",
+				"img/a/b/c.png": "png",
+			}
+			got := readFiles(t, filepath.Join(wd, test.root))
+			if len(got) != len(want) {
+				t.Errorf("got files %v, want %v", got, want)
+			}
+			for name, content := range want {
+				if got[name] != content {
+					t.Errorf("%s holds %q, want %q", name, got[name], content)
+				}
+			}
+			if gets := fake.served("GET bk/site/css"); len(gets) != 0 {
+				t.Errorf("downloaded the up to date file: %v", gets)
+			}
+		})
+	}
+}
+
+func TestSyncDeleteRefusesFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func()
+	}{
+		{"--limit", func() { limit = 1 }},
+		{"--exclude-prefix", func() { excludePrefixes = []string{"tmp/"} }},
+		{"--recursive-depth", func() { recursiveDepth = 1 }},
+		{"--from-inventory", func() { fromInventory = "s3://inventory/manifest.json" }},
+		{"--exclude", func() { keyGlobs = []keyGlob{{pattern: "*.tmp", exclude: true}} }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			oldExcludes, oldDepth, oldInventory := excludePrefixes, recursiveDepth, fromInventory
+			defer func() {
+				excludePrefixes, recursiveDepth, fromInventory = oldExcludes, oldDepth, oldInventory
+			}()
+			test.set()
+			fake, svc := newFakeS3(t, map[string]string{"bk/site/index.html": "index"})
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"old.html": "old"})
+
+			for _, args := range [][2]string{{"s3://bk/site/", dir}, {dir, "s3://bk/site/"}} {
+				err := Sync(svc, args[0], args[1], "/", 0, "", true, false, 0, false)
+				if err == nil || !strings.Contains(err.Error(), test.name) {
+					t.Errorf("sync %s %s: got error %v, want one naming %s", args[0], args[1], err, test.name)
+				}
+			}
+			if calls := fake.served(""); len(calls) != 0 {
+				t.Errorf("made calls %v", calls)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "old.html")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// planKeys returns the FullKey and Key of entries
+func planKeys(entries []*s3wrapper.ListOutput) []string {
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, entry.FullKey+" -> "+entry.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPlanSyncDown(t *testing.T) {
+	withCleanGlobals(t)
+	fake, svc := newFakeS3(t, map[string]string{
+		"bk/site/new.html":     "new",
+		"bk/site/changed.html": "changed",
+		"bk/site/same.html":    "same",
+	})
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"changed.html": "old",
+		"same.html":    "same",
+		"extra.html":   "extra",
+	})
+
+	plan, err := PlanSync(svc, "s3://bk/site/", dir, "/", 0, "", true, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	if got, want := planKeys(plan.Create), []string{"s3://bk/site/new.html -> " + path("new.html")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got creates %v, want %v", got, want)
+	}
+	// a size change is an update
+	if got, want := planKeys(plan.Update), []string{"s3://bk/site/changed.html -> " + path("changed.html")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got updates %v, want %v", got, want)
+	}
+	if got, want := planKeys(plan.Delete), []string{path("extra.html") + " -> " + path("extra.html")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got deletes %v, want %v", got, want)
+	}
+	if transferred, deleted := plan.Bytes(); transferred != 10 || deleted != 5 {
+		t.Errorf("got %d bytes transferred and %d deleted, want 10 and 5", transferred, deleted)
+	}
+	if gets := fake.served("GET bk/site/"); len(gets) != 0 {
+		t.Errorf("downloaded %v", gets)
+	}
+	if got := readFiles(t, dir); len(got) != 3 || got["changed.html"] != "old" || got["extra.html"] != "extra" {
+		t.Errorf("files changed: %v", got)
+	}
+}
+
+func TestPlanSyncUp(t *testing.T) {
+	withCleanGlobals(t)
+	fake, svc := newFakeS3(t, map[string]string{
+		"bk/site/changed.html": "old",
+		"bk/site/same.html":    "same",
+		"bk/site/extra.html":   "extra",
+	})
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"new.html":     "new",
+		"changed.html": "changed",
+		"same.html":    "same",
+	})
+	// the local files are older than the objects so only the size decides
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"new.html", "changed.html", "same.html"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := PlanSync(svc, dir, "s3://bk/site/", "/", 0, "", true, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	if got, want := planKeys(plan.Create), []string{path("new.html") + " -> s3://bk/site/new.html"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got creates %v, want %v", got, want)
+	}
+	if got, want := planKeys(plan.Update), []string{path("changed.html") + " -> s3://bk/site/changed.html"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got updates %v, want %v", got, want)
+	}
+	if got, want := planKeys(plan.Delete), []string{"s3://bk/site/extra.html -> site/extra.html"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got deletes %v, want %v", got, want)
+	}
+	if writes := append(fake.served("PUT"), fake.served("POST")...); len(writes) != 0 {
+		t.Errorf("changed the bucket: %v", writes)
+	}
+	if got := len(fake.keys()); got != 3 {
+		t.Errorf("got %d objects, want 3", got)
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	stdout, _ := withCleanGlobals(t)
+	plan := &s3wrapper.Plan{
+		Create: []*s3wrapper.ListOutput{
+			{FullKey: "s3://bk/b", Key: "out/b", Size: 1000},
+			{FullKey: "s3://bk/a", Key: "out/a", Size: 24},
+		},
+		Delete: []*s3wrapper.ListOutput{{FullKey: "out/old", Key: "out/old", Size: 3}},
+	}
+	printPlan(plan)
+	want := `To create:
+  s3://bk/a -> out/a
+  s3://bk/b -> out/b
+To delete:
+  out/old
+Plan: 2 to create, 0 to update, 1.0 kB to transfer, 1 to delete, 3 B
+Dry run, sync would change 3 objects (1.0 kB)
+`
+	if got := stdout.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if !plan.Pending() || (&s3wrapper.Plan{}).Pending() {
+		t.Error("only plans with changes should be pending")
+	}
+}
+
+func TestSyncChecksum(t *testing.T) {
+	const partsETag = "0123456789abcdef0123456789abcdef-2"
+	tests := []struct {
+		name      string
+		local     string
+		remote    string
+		partsETag string
+		// localNewer makes the file newer than the object, it is older
+		// otherwise
+		localNewer bool
+		checksum   bool
+		// wantDown and wantUp tell whether the download or upload
+		// updates the destination
+		wantDown bool
+		wantUp   bool
+	}{
+		{"same size, newer file", "aaaa", "bbbb", "", true, false, false, true},
+		{"same size, newer file, checksum", "aaaa", "bbbb", "", true, true, true, true},
+		{"same content, older file", "aaaa", "aaaa", "", false, false, true, false},
+		{"same content, older file, checksum", "aaaa", "aaaa", "", false, true, false, false},
+		{"different size, checksum", "aaaa", "bbb", "", true, true, true, true},
+		// the ETags of multipart uploads fall back to the modification time
+		{"multipart, newer file, checksum", "aaaa", "bbbb", partsETag, true, true, false, true},
+		{"multipart, older file, checksum", "aaaa", "aaaa", partsETag, false, true, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			fake, svc := newFakeS3(t, map[string]string{"bk/site/a.txt": test.remote})
+			fake.objects["bk/site/a.txt"].partsETag = test.partsETag
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": test.local})
+			modified := time.Now().Add(-2 * time.Hour)
+			if test.localNewer {
+				modified = time.Now()
+			}
+			if err := os.Chtimes(filepath.Join(dir, "a.txt"), modified, modified); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, direction := range []struct {
+				src, dest string
+				want      bool
+			}{
+				{"s3://bk/site/", dir, test.wantDown},
+				{dir, "s3://bk/site/", test.wantUp},
+			} {
+				plan, err := PlanSync(svc, direction.src, direction.dest, "/", 0, "", false, test.checksum, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := len(plan.Update) > 0; got != direction.want {
+					t.Errorf("sync %s %s: got update %t, want %t", direction.src, direction.dest, got, direction.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncChecksumPartSize(t *testing.T) {
+	// the ETag of "0123456789" uploaded in parts of 4 bytes
+	const partsETag = "61e3716e3a7767581863b67c4e785584-3"
+	tests := []struct {
+		name       string
+		local      string
+		partSize   int64
+		localNewer bool
+		want       bool
+	}{
+		{"same content, older file", "0123456789", 4, false, false},
+		{"different content, newer file", "0123456780", 4, true, true},
+		// 5 bytes parts give 2 parts, the object was uploaded with another
+		// part size so the modification time decides
+		{"other part size, older file", "0123456789", 5, false, true},
+		{"other part size, newer file", "0123456780", 5, true, false},
+		{"no common part size", "0123456789", 0, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withCleanGlobals(t)
+			fake, svc := newFakeS3(t, map[string]string{"bk/site/a.txt": "0123456789"})
+			fake.objects["bk/site/a.txt"].partsETag = partsETag
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": test.local})
+			modified := time.Now().Add(-2 * time.Hour)
+			if test.localNewer {
+				modified = time.Now()
+			}
+			if err := os.Chtimes(filepath.Join(dir, "a.txt"), modified, modified); err != nil {
+				t.Fatal(err)
+			}
+
+			plan, err := PlanSync(svc, "s3://bk/site/", dir, "/", 0, "", false, true, test.partSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(plan.Update) > 0; got != test.want {
+				t.Errorf("got update %t, want %t", got, test.want)
+			}
+		})
+	}
+}
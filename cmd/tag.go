@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spf13/cobra"
+)
+
+const (
+	maxTagsPerObject = 10
+	maxTagKeyLength  = 128
+	maxTagValLength  = 256
+)
+
+// tagCmd represents the tag command
+var tagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "Read and write object tags",
+	Long:  ``,
+}
+
+// tagGetCmd represents the tag get command
+var tagGetCmd = &cobra.Command{
+	Use:   "get <S3 URIs>",
+	Short: "Print the tags of S3 objects",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := GetTags(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// tagSetCmd represents the tag set command
+var tagSetCmd = &cobra.Command{
+	Use:   "set <S3 URIs> <key=value>...",
+	Short: "Replace the tags of S3 objects",
+	Long:  ``,
+	Args: func(cmd *cobra.Command, args []string) error {
+		s3Uris, tagArgs := splitTagArgs(args)
+		if len(s3Uris) == 0 || len(tagArgs) == 0 {
+			return fmt.Errorf("requires at least one S3 uri and one key=value tag")
+		}
+		if _, err := parseTags(tagArgs); err != nil {
+			return err
+		}
+		return validateS3URIs()(cmd, s3Uris)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		s3Uris, tagArgs := splitTagArgs(args)
+		tags, err := parseTags(tagArgs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := SetTags(GetS3Client(), s3Uris, recursive, delimiter, searchDepth, keyRegex, tags); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// GetTags prints the tags of S3 objects using svc, s3Uris is a list of prefixes/keys, recurse tells whether or not to
+// use everything under the prefixes, delimiter tells the delimiter to use when listing, searchDepth determines the number
+// of prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys
+func GetTags(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string) error {
+	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	wrap, err := newS3Wrapper(svc).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("tag get", dataOut)
+	for obj := range wrap.GetTags(listCh) {
+		results.TagResult("tags", obj, fmt.Sprintf("%s %s\n", obj.FullKey, formatTags(obj.Tags)))
+	}
+	results.Summary()
+	return wrap.Err()
+}
+
+// SetTags replaces the tags of S3 objects with tags using svc, s3Uris is a list of prefixes/keys, recurse tells whether
+// or not to use everything under the prefixes, delimiter tells the delimiter to use when listing, searchDepth determines
+// the number of prefixes to list before parallelizing list calls, keyRegex is a regex filter on keys
+func SetTags(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, tags map[string]string) error {
+	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	wrap, err := newS3Wrapper(svc).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("tag set", statusOut)
+	stopProgress := reportProgress(results)
+	formattedTags := formatTags(tags)
+	for obj := range wrap.PutTags(listCh, tags) {
+		results.Result("tag", obj, "", fmt.Sprintf("Tagged %s %s\n", obj.FullKey, formattedTags))
+	}
+	stopProgress()
+	results.Summary()
+	return wrap.Err()
+}
+
+// splitTagArgs separates the S3 uris from the key=value tags in args
+func splitTagArgs(args []string) (s3Uris []string, tagArgs []string) {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "s3://") {
+			s3Uris = append(s3Uris, arg)
+		} else {
+			tagArgs = append(tagArgs, arg)
+		}
+	}
+	return s3Uris, tagArgs
+}
+
+// parseTags parses key=value tags and validates them against the S3 limits
+func parseTags(tagArgs []string) (map[string]string, error) {
+	if len(tagArgs) > maxTagsPerObject {
+		return nil, fmt.Errorf("objects can have at most %d tags, got %d", maxTagsPerObject, len(tagArgs))
+	}
+
+	tags := make(map[string]string, len(tagArgs))
+	for _, tagArg := range tagArgs {
+		parts := strings.SplitN(tagArg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%s is not a valid tag, tags must be of the form key=value", tagArg)
+		}
+		key, val := parts[0], parts[1]
+		if utf8.RuneCountInString(key) > maxTagKeyLength {
+			return nil, fmt.Errorf("tag key %s is longer than %d characters", key, maxTagKeyLength)
+		}
+		if utf8.RuneCountInString(val) > maxTagValLength {
+			return nil, fmt.Errorf("value of tag %s is longer than %d characters", key, maxTagValLength)
+		}
+		if _, ok := tags[key]; ok {
+			return nil, fmt.Errorf("tag %s is given more than once", key)
+		}
+		tags[key] = val
+	}
+	return tags, nil
+}
+
+// formatTags formats tags as space separated key=value pairs sorted by key
+func formatTags(tags map[string]string) string {
+	formatted := make([]string, 0, len(tags))
+	for k, v := range tags {
+		formatted = append(formatted, k+"="+v)
+	}
+	sort.Strings(formatted)
+	return strings.Join(formatted, " ")
+}
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+	tagCmd.AddCommand(tagGetCmd)
+	tagCmd.AddCommand(tagSetCmd)
+
+	tagGetCmd.Flags().BoolP("recursive", "r", false, "Get the tags of all keys for this prefix")
+	tagSetCmd.Flags().BoolP("recursive", "r", false, "Set the tags of all keys for this prefix")
+}
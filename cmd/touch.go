@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// touchCmd represents the touch command
+var touchCmd = &cobra.Command{
+	Use:   "touch <S3 URIs>",
+	Short: "Update the last modified time and metadata of S3 objects in place",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+		storageClass, err := cmd.Flags().GetString("storage-class")
+		if err != nil {
+			log.Fatal(err)
+		}
+		contentType, err := cmd.Flags().GetString("content-type")
+		if err != nil {
+			log.Fatal(err)
+		}
+		metadata, err := cmd.Flags().GetStringToString("metadata")
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts := s3wrapper.TouchOptions{
+			StorageClass: storageClass,
+			ContentType:  contentType,
+			Metadata:     metadata,
+		}
+		if err := Touch(GetS3Client(), args, recursive, delimiter, searchDepth, keyRegex, opts, dryRun); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// Touch copies S3 objects onto themselves using svc to reset their last modified time, s3Uris is a list of
+// prefixes/keys, recurse tells whether or not to touch everything under the prefixes, delimiter tells the delimiter to
+// use when listing, searchDepth determines the number of prefixes to list before parallelizing list calls, keyRegex is a
+// regex filter on keys, opts are the metadata changes to make, dryRun prints what would be touched without touching it
+func Touch(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, searchDepth int, keyRegex string, opts s3wrapper.TouchOptions, dryRun bool) error {
+	listCh, err := Ls(svc, s3Uris, recurse, delimiter, searchDepth, keyRegex)
+	if err != nil {
+		return err
+	}
+
+	wrap, err := newS3Wrapper(svc).WithDryRun(dryRun).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	results := newEmitter("touch", statusOut)
+	results.dryRun = dryRun
+	stopProgress := reportProgress(results)
+	for obj := range wrap.TouchAll(listCh, opts) {
+		if dryRun {
+			results.Result("touch", obj, "", fmt.Sprintf("Would touch %s\n", obj.FullKey))
+		} else {
+			results.Result("touch", obj, "", fmt.Sprintf("Touched %s\n", obj.FullKey))
+		}
+	}
+	stopProgress()
+	results.Summary()
+	return wrap.Err()
+}
+
+func init() {
+	rootCmd.AddCommand(touchCmd)
+
+	touchCmd.Flags().BoolP("recursive", "r", false, "Touch all keys for this prefix")
+	touchCmd.Flags().Bool("dry-run", false, "Print what would be touched without touching anything")
+	touchCmd.Flags().String("storage-class", "", "Storage class to move the objects to, they keep their current one by default")
+	touchCmd.Flags().String("content-type", "", "Content type to set, the current one is kept by default")
+	touchCmd.Flags().StringToString("metadata", nil, "User metadata to add or overwrite as key=value pairs, other user metadata is kept")
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/metaverse/fasts3/s3wrapper"
+)
+
+func TestTouch(t *testing.T) {
+	withCleanGlobals(t)
+	fake, svc := newFakeS3(t, map[string]string{
+		"bk/logs/a.txt": "a",
+	})
+	touched := fake.objects["bk/logs/a.txt"]
+	touched.headers = http.Header{
+		"Content-Type":                                []string{"text/plain"},
+		"X-Amz-Meta-Owner":                            []string{"etl"},
+		"X-Amz-Server-Side-Encryption":                []string{"aws:kms"},
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": []string{"arn:aws:kms:us-east-1:123456789012:key/abc"},
+		"X-Amz-Website-Redirect-Location":             []string{"/b.txt"},
+	}
+	opts := s3wrapper.TouchOptions{StorageClass: "STANDARD_IA", Metadata: map[string]string{"touched": "yes"}}
+	if err := Touch(svc, []string{"s3://bk/logs/a.txt"}, false, "/", 0, "", opts, false); err != nil {
+		t.Fatal(err)
+	}
+	obj := fake.objects["bk/logs/a.txt"]
+	if !obj.modified.After(touched.modified) {
+		t.Errorf("last modified %s wasn't reset", obj.modified)
+	}
+	// the object keeps its metadata, encryption and redirect
+	want := http.Header{
+		"Content-Type":                                []string{"text/plain"},
+		"X-Amz-Meta-Owner":                            []string{"etl"},
+		"X-Amz-Meta-Touched":                          []string{"yes"},
+		"X-Amz-Server-Side-Encryption":                []string{"aws:kms"},
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": []string{"arn:aws:kms:us-east-1:123456789012:key/abc"},
+		"X-Amz-Storage-Class":                         []string{"STANDARD_IA"},
+		"X-Amz-Website-Redirect-Location":             []string{"/b.txt"},
+	}
+	if !reflect.DeepEqual(obj.headers, want) {
+		t.Errorf("got %v, want %v", obj.headers, want)
+	}
+}
+
+func TestTouchDryRun(t *testing.T) {
+	withCleanGlobals(t)
+	fake, svc := newFakeS3(t, map[string]string{
+		"bk/logs/a.txt": "a",
+	})
+	if err := Touch(svc, []string{"s3://bk/logs/"}, true, "/", 0, "", s3wrapper.TouchOptions{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if copies := fake.served("PUT "); len(copies) != 0 {
+		t.Errorf("copied %v", copies)
+	}
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// treeCmd represents the tree command
+var treeCmd = &cobra.Command{
+	Use:   "tree <S3 URIs>",
+	Short: "Show S3 prefixes and objects as a tree",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		depth, err := cmd.Flags().GetInt("depth")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dirsOnly, err := cmd.Flags().GetBool("dirs-only")
+		if err != nil {
+			log.Fatal(err)
+		}
+		summarize, err := cmd.Flags().GetBool("summarize")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := Tree(GetS3Client(), args, delimiter, keyRegex, depth, dirsOnly, summarize); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// Tree prints the prefixes and objects under s3Uris as a tree using svc, delimiter tells the delimiter to use when
+// listing, keyRegex is a regex filter on keys, depth limits the number of levels listed when above 0, dirsOnly only
+// prints prefixes and summarize prints the number of objects directly under each listed prefix
+func Tree(svc *s3.S3, s3Uris []string, delimiter string, keyRegex string, depth int, dirsOnly bool, summarize bool) error {
+	if delimiter == "" {
+		return fmt.Errorf("tree requires a --delimiter")
+	}
+	s3Uris = expandS3URIs(s3Uris)
+	for i, uri := range s3Uris {
+		if !strings.HasSuffix(uri, delimiter) {
+			s3Uris[i] = uri + delimiter
+		}
+	}
+
+	wrap, err := newS3Wrapper(svc).
+		WithMaxConcurrency(concurrencyOr(listConcurrency)).
+		WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	// children maps every listed prefix to the prefixes and objects directly
+	// under it, each level is listed in parallel once the previous one is done
+	children := make(map[string][]*s3wrapper.ListOutput)
+	level := s3Uris
+	for i := 0; len(level) > 0 && (depth <= 0 || i < depth); i++ {
+		next := make([]string, 0)
+		for itm := range wrap.ListAll(level, false, delimiter, keyRegex) {
+			parent := treeParent(itm.FullKey, delimiter)
+			children[parent] = append(children[parent], itm)
+			if itm.IsPrefix {
+				next = append(next, itm.FullKey)
+			}
+		}
+		level = next
+	}
+
+	var dirs, files int
+	for _, uri := range s3Uris {
+		fmt.Fprintln(dataOut, uri)
+		printTree(children, uri, "", dirsOnly, summarize, &dirs, &files)
+	}
+	if dirsOnly {
+		fmt.Fprintf(dataOut, "\n%s directories\n", humanize.Comma(int64(dirs)))
+	} else {
+		fmt.Fprintf(dataOut, "\n%s directories, %s files\n", humanize.Comma(int64(dirs)), humanize.Comma(int64(files)))
+	}
+	return wrap.Err()
+}
+
+// treeParent returns the prefix fullKey is directly under
+func treeParent(fullKey string, delimiter string) string {
+	trimmed := strings.TrimSuffix(fullKey, delimiter)
+	return trimmed[:strings.LastIndex(trimmed, delimiter)+len(delimiter)]
+}
+
+// printTree prints the children of prefix sorted by name, indented by indent,
+// and recurses into the ones which are prefixes, dirs and files are
+// incremented for every prefix and object printed
+func printTree(children map[string][]*s3wrapper.ListOutput, prefix string, indent string, dirsOnly bool, summarize bool, dirs *int, files *int) {
+	entries := make([]*s3wrapper.ListOutput, 0, len(children[prefix]))
+	for _, entry := range children[prefix] {
+		if dirsOnly && !entry.IsPrefix {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].FullKey < entries[j].FullKey })
+
+	for i, entry := range entries {
+		branch, childIndent := "├── ", indent+"│   "
+		if i == len(entries)-1 {
+			branch, childIndent = "└── ", indent+"    "
+		}
+		name := strings.TrimPrefix(entry.FullKey, prefix)
+		if !entry.IsPrefix {
+			*files++
+			fmt.Fprintf(dataOut, "%s%s%s\n", indent, branch, name)
+			continue
+		}
+
+		*dirs++
+		if _, listed := children[entry.FullKey]; summarize && listed {
+			fmt.Fprintf(dataOut, "%s%s%s (%s objects)\n", indent, branch, name, humanize.Comma(countObjects(children[entry.FullKey])))
+		} else {
+			fmt.Fprintf(dataOut, "%s%s%s\n", indent, branch, name)
+		}
+		printTree(children, entry.FullKey, childIndent, dirsOnly, summarize, dirs, files)
+	}
+}
+
+// countObjects returns the number of objects in entries
+func countObjects(entries []*s3wrapper.ListOutput) int64 {
+	var objects int64
+	for _, entry := range entries {
+		if !entry.IsPrefix {
+			objects++
+		}
+	}
+	return objects
+}
+
+func init() {
+	rootCmd.AddCommand(treeCmd)
+
+	treeCmd.Flags().Int("depth", 0, "Maximum number of levels to list, 0 means no limit")
+	treeCmd.Flags().Bool("dirs-only", false, "Only show prefixes")
+	treeCmd.Flags().Bool("summarize", false, "Show the number of objects directly under each prefix")
+}
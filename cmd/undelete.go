@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/metaverse/fasts3/s3wrapper"
+	"github.com/spf13/cobra"
+)
+
+// undeleteCmd represents the undelete command
+var undeleteCmd = &cobra.Command{
+	Use:   "undelete <S3 URIs>",
+	Short: "Restore objects deleted from a versioned bucket by removing their delete markers",
+	Long:  ``,
+	Args:  validateS3URIs(cobra.MinimumNArgs(1)),
+	Run: func(cmd *cobra.Command, args []string) {
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := Undelete(GetS3Client(), args, recursive, delimiter, keyRegex, dryRun); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// Undelete restores the objects deleted from a versioned bucket using svc, s3Uris is a list of prefixes/keys, recurse
+// tells whether or not to restore everything under the prefixes, delimiter tells the delimiter to use when listing,
+// keyRegex is a regex filter on keys, dryRun prints what would be restored without restoring anything. An object is
+// restored by deleting the delete marker which is its latest version, making the version before it current again.
+func Undelete(svc *s3.S3, s3Uris []string, recurse bool, delimiter string, keyRegex string, dryRun bool) error {
+	s3Uris = expandS3URIs(s3Uris)
+	wrap, err := newS3Wrapper(svc).WithDryRun(dryRun).WithRegionFrom(s3Uris[0])
+	if err != nil {
+		return err
+	}
+
+	markers := make(chan *s3wrapper.ListOutput, 10000)
+	var wg sync.WaitGroup
+	for _, s3Uri := range s3Uris {
+		wg.Add(1)
+		go func(s3Uri string) {
+			defer wg.Done()
+			for marker := range wrap.ListDeleteMarkers(s3Uri, recurse, delimiter, keyRegex) {
+				markers <- marker
+			}
+		}(s3Uri)
+	}
+	go func() {
+		wg.Wait()
+		close(markers)
+	}()
+
+	results := newEmitter("undelete", statusOut)
+	results.dryRun = dryRun
+	stopProgress := reportProgress(results)
+	for key := range wrap.DeleteObjects(markers) {
+		if dryRun {
+			results.Result("undelete", key, "", fmt.Sprintf("Would restore %s\n", key.FullKey))
+		} else {
+			results.Result("undelete", key, "", fmt.Sprintf("Restored %s\n", key.FullKey))
+		}
+	}
+	stopProgress()
+	results.Summary()
+	return wrap.Err()
+}
+
+func init() {
+	rootCmd.AddCommand(undeleteCmd)
+
+	undeleteCmd.Flags().BoolP("recursive", "r", false, "Restore all deleted keys for this prefix")
+	undeleteCmd.Flags().Bool("dry-run", false, "Print what would be restored without restoring anything")
+}
@@ -0,0 +1,168 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ACLOutput is a key along with its access control list
+type ACLOutput struct {
+	*ListOutput
+	Owner  string
+	Grants []*ACLGrant
+}
+
+// ACLGrant is a single permission granted to a grantee
+type ACLGrant struct {
+	Grantee    string
+	Permission string
+}
+
+// GetACLs retrieves the access control list of every key in the given
+// keys channel
+func (w *S3Wrapper) GetACLs(keys chan *ListOutput) chan *ACLOutput {
+	aclOut := make(chan *ACLOutput, 10000)
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(aclOut)
+		}()
+		defer w.recoverPanic()
+		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
+			wg.Add(1)
+			go func(k *ListOutput) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+
+				var resp *s3.GetObjectAclOutput
+				err := w.retry(true, func() error {
+					var err error
+					resp, err = w.client(k.Bucket).GetObjectAcl(&s3.GetObjectAclInput{
+						Bucket:       aws.String(k.Bucket),
+						Key:          aws.String(k.Key),
+						RequestPayer: w.requestPayer,
+					})
+					return err
+				})
+				if err != nil {
+					w.errs.add(aclError(k, err, "get", "s3:GetObjectAcl"))
+					return
+				}
+				grants := make([]*ACLGrant, 0, len(resp.Grants))
+				for _, grant := range resp.Grants {
+					grants = append(grants, &ACLGrant{
+						Grantee:    formatGrantee(grant.Grantee),
+						Permission: aws.StringValue(grant.Permission),
+					})
+				}
+				aclOut <- &ACLOutput{
+					ListOutput: k,
+					Owner:      formatOwner(resp.Owner),
+					Grants:     grants,
+				}
+			}(key)
+		}
+	}()
+
+	return aclOut
+}
+
+// PutACLs applies the canned acl to every key in the given keys channel
+func (w *S3Wrapper) PutACLs(keys chan *ListOutput, acl string) chan *ListOutput {
+	listOut := make(chan *ListOutput, 10000)
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(listOut)
+		}()
+		defer w.recoverPanic()
+		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
+			wg.Add(1)
+			go func(k *ListOutput) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+
+				if !w.dryRun {
+					err := w.retryWrite(func() error {
+						_, err := w.client(k.Bucket).PutObjectAcl(&s3.PutObjectAclInput{
+							ACL:          aws.String(acl),
+							Bucket:       aws.String(k.Bucket),
+							Key:          aws.String(k.Key),
+							RequestPayer: w.requestPayer,
+						})
+						return err
+					})
+					if err != nil {
+						w.errs.add(aclError(k, err, "set", "s3:PutObjectAcl"))
+						return
+					}
+				}
+				listOut <- k
+			}(key)
+		}
+	}()
+
+	return listOut
+}
+
+// aclError turns the common errors of the ACL requests into clearer messages,
+// permission is the IAM permission the request requires
+func aclError(k *ListOutput, err error, action string, permission string) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey:
+			return fmt.Errorf("unable to %s the ACL of %s: no such key", action, k.FullKey)
+		case "AccessDenied":
+			return fmt.Errorf("unable to %s the ACL of %s: access denied, this requires the %s permission", action, k.FullKey, permission)
+		case "AccessControlListNotSupported":
+			return fmt.Errorf("unable to %s the ACL of %s: the bucket has ACLs disabled", action, k.FullKey)
+		}
+	}
+	return fmt.Errorf("unable to %s the ACL of %s: %s", action, k.FullKey, err)
+}
+
+// formatOwner returns the display name of owner, falling back to its ID
+func formatOwner(owner *s3.Owner) string {
+	if owner == nil {
+		return ""
+	}
+	if name := aws.StringValue(owner.DisplayName); name != "" {
+		return name
+	}
+	return aws.StringValue(owner.ID)
+}
+
+// formatGrantee returns the most readable identifier of grantee
+func formatGrantee(grantee *s3.Grantee) string {
+	if grantee == nil {
+		return ""
+	}
+	for _, id := range []*string{grantee.DisplayName, grantee.URI, grantee.EmailAddress, grantee.ID} {
+		if aws.StringValue(id) != "" {
+			return aws.StringValue(id)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package s3wrapper
+
+import (
+	"sync"
+)
+
+// adaptiveConcurrency limits the calls holding a slot of the wrapper's
+// concurrency semaphore to a limit which changes at runtime, additively
+// increasing while calls succeed and halving when S3 throttles them
+type adaptiveConcurrency struct {
+	sync.Mutex
+	cond  *sync.Cond
+	min   int
+	max   int
+	limit int
+	inUse int
+	// successes counts the calls which succeeded since the limit last
+	// changed, the limit increases once a whole limit's worth did
+	successes int
+	// sinceDecrease counts the calls which finished since the limit was
+	// last decreased, the calls in flight then were started with the old
+	// limit and their throttling is already accounted for
+	sinceDecrease int
+	// lastLimit is the limit before the last decrease
+	lastLimit int
+}
+
+// WithAdaptiveConcurrency makes the wrapper tune its concurrency between
+// min and max, starting halfway, from the throttling errors S3 returns. It
+// replaces the concurrency set with WithMaxConcurrency.
+func (w *S3Wrapper) WithAdaptiveConcurrency(min, max int) *S3Wrapper {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	a := &adaptiveConcurrency{
+		min:   min,
+		max:   max,
+		limit: (min + max) / 2,
+	}
+	a.cond = sync.NewCond(a)
+	w.concurrencySemaphore = make(chan struct{}, max)
+	w.adaptive = a
+	return w
+}
+
+// acquire waits for a slot of the wrapper's concurrency
+func (w *S3Wrapper) acquire() {
+	w.concurrencySemaphore <- struct{}{}
+	if w.adaptive != nil {
+		w.adaptive.acquire()
+	}
+}
+
+// release frees a slot taken with acquire
+func (w *S3Wrapper) release() {
+	if w.adaptive != nil {
+		w.adaptive.release()
+	}
+	<-w.concurrencySemaphore
+}
+
+// acquire waits until fewer calls than the limit are in flight
+func (a *adaptiveConcurrency) acquire() {
+	a.Lock()
+	defer a.Unlock()
+	for a.inUse >= a.limit {
+		a.cond.Wait()
+	}
+	a.inUse++
+}
+
+func (a *adaptiveConcurrency) release() {
+	a.Lock()
+	defer a.Unlock()
+	a.inUse--
+	a.cond.Signal()
+}
+
+// succeeded records a call which wasn't throttled
+func (a *adaptiveConcurrency) succeeded() {
+	a.Lock()
+	defer a.Unlock()
+	a.sinceDecrease++
+	a.successes++
+	if a.successes >= a.limit && a.limit < a.max {
+		a.successes = 0
+		a.limit++
+		a.cond.Signal()
+	}
+}
+
+// throttled records a call which S3 throttled
+func (a *adaptiveConcurrency) throttled() {
+	a.Lock()
+	defer a.Unlock()
+	a.sinceDecrease++
+	if a.sinceDecrease <= a.lastLimit {
+		return
+	}
+	a.sinceDecrease = 0
+	a.successes = 0
+	a.lastLimit = a.limit
+	a.limit /= 2
+	if a.limit < a.min {
+		a.limit = a.min
+	}
+}
+
+// record feeds the outcome of a call into the adaptive concurrency, if the
+// wrapper has one
+func (w *S3Wrapper) record(err error) {
+	if w.adaptive == nil {
+		return
+	}
+	if isThrottle(err) {
+		w.adaptive.throttled()
+	} else if err == nil {
+		w.adaptive.succeeded()
+	}
+}
@@ -0,0 +1,144 @@
+package s3wrapper
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// checksumHeaders are the headers S3 returns the additional checksum of an
+// object in when checksum mode is enabled, along with the hash computing it
+var checksumHeaders = []struct {
+	header  string
+	newHash func() hash.Hash
+}{
+	{"X-Amz-Checksum-Crc32", func() hash.Hash { return crc32.NewIEEE() }},
+	{"X-Amz-Checksum-Crc32c", func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }},
+	{"X-Amz-Checksum-Sha1", sha1.New},
+	{"X-Amz-Checksum-Sha256", sha256.New},
+}
+
+// checksumAlgorithmHeader asks S3 to compute and store the additional
+// checksum of the object written with the given algorithm
+const checksumAlgorithmHeader = "X-Amz-Checksum-Algorithm"
+
+// ParseChecksumAlgorithm returns the upper case name of algorithm if it is
+// one of the algorithms of additional checksums
+func ParseChecksumAlgorithm(algorithm string) (string, error) {
+	names := make([]string, 0, len(checksumHeaders))
+	for _, checksum := range checksumHeaders {
+		name := strings.ToUpper(strings.TrimPrefix(checksum.header, "X-Amz-Checksum-"))
+		if strings.EqualFold(algorithm, name) {
+			return name, nil
+		}
+		names = append(names, name)
+	}
+	return "", fmt.Errorf("unknown checksum algorithm %q, expected one of %s", algorithm, strings.Join(names, ", "))
+}
+
+// checksumAlgorithmOption returns the option setting the checksum algorithm
+// of the object a request writes, the SDK predates additional checksums so
+// the header is set by hand
+func checksumAlgorithmOption(algorithm string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set(checksumAlgorithmHeader, algorithm)
+	}
+}
+
+// headChecksum returns the additional checksum S3 stored for key with
+// algorithm, it is empty when there is none
+func (w *S3Wrapper) headChecksum(bucket string, key string, algorithm string) (string, error) {
+	params := &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: w.requestPayer,
+	}
+	w.sseCustomerKey.applyToHead(params)
+	var checksum string
+	err := w.retry(true, func() error {
+		req, _ := w.client(bucket).HeadObjectRequest(params)
+		req.HTTPRequest.Header.Set("X-Amz-Checksum-Mode", "ENABLED")
+		if err := req.Send(); err != nil {
+			return err
+		}
+		checksum = req.HTTPResponse.Header.Get("X-Amz-Checksum-" + algorithm)
+		return nil
+	})
+	return checksum, err
+}
+
+// ChecksumError is returned when the body of an object doesn't match the
+// checksum S3 stored for it
+type ChecksumError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch, expected %s but got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// WithChecksumMode makes the wrapper ask S3 for the additional checksum of
+// the objects it gets and validate their body against it
+func (w *S3Wrapper) WithChecksumMode(enabled bool) *S3Wrapper {
+	w.checksumMode = enabled
+	return w
+}
+
+// checksumReader hashes the body it reads and fails on EOF when the hash
+// doesn't match the expected checksum
+type checksumReader struct {
+	io.ReadCloser
+	hash      hash.Hash
+	algorithm string
+	expected  string
+}
+
+// newChecksumReader wraps body so it is validated against the first
+// checksum found in header, body is returned as is when there is none or
+// only a checksum of the checksums of the parts of a multipart upload, which
+// can't be validated against the whole body
+func newChecksumReader(body io.ReadCloser, header http.Header) io.ReadCloser {
+	for _, checksum := range checksumHeaders {
+		expected := header.Get(checksum.header)
+		if expected == "" || strings.Contains(expected, "-") {
+			continue
+		}
+		return &checksumReader{
+			ReadCloser: body,
+			hash:       checksum.newHash(),
+			algorithm:  strings.ToUpper(strings.TrimPrefix(checksum.header, "X-Amz-Checksum-")),
+			expected:   expected,
+		}
+	}
+	return body
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		actual := r.hash.Sum(nil)
+		expected, decodeErr := base64.StdEncoding.DecodeString(r.expected)
+		if decodeErr != nil || !bytes.Equal(actual, expected) {
+			return n, &ChecksumError{
+				Algorithm: r.algorithm,
+				Expected:  r.expected,
+				Actual:    base64.StdEncoding.EncodeToString(actual),
+			}
+		}
+	}
+	return n, err
+}
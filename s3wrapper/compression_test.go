@@ -0,0 +1,135 @@
+package s3wrapper
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2Hello is "hello\nworld\n" compressed by bzip2(1), the standard library
+// can only decompress bzip2
+var bzip2Hello = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x6b, 0x5f, 0xb1, 0xdd, 0x00, 0x00,
+	0x02, 0x41, 0x80, 0x00, 0x10, 0x06, 0x44, 0x90, 0x80, 0x20, 0x00, 0x31, 0x0c, 0x08, 0x21, 0xa3,
+	0x69, 0x08, 0x07, 0x23, 0xae, 0x87, 0x8b, 0xb9, 0x22, 0x9c, 0x28, 0x48, 0x35, 0xaf, 0xd8, 0xee,
+	0x80,
+}
+
+const hello = "hello\nworld\n"
+
+func gzipped(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdCompressed(t *testing.T, data string) []byte {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll([]byte(data), nil)
+}
+
+func TestGetReaderByExt(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		data []byte
+		want string
+	}{
+		{"gzip", "logs/a.gz", gzipped(t, hello), hello},
+		{"gzip upper case", "logs/a.GZIP", gzipped(t, hello), hello},
+		{"gz upper case", "logs/FILE.GZ", gzipped(t, hello), hello},
+		{"bzip2", "logs/a.bz2", bzip2Hello, hello},
+		{"bzip2 upper case", "logs/a.BZ2", bzip2Hello, hello},
+		{"zstd", "logs/a.zst", zstdCompressed(t, hello), hello},
+		{"zstd upper case", "logs/a.ZST", zstdCompressed(t, hello), hello},
+		{"uncompressed", "logs/a.txt", []byte(hello), hello},
+		{"compressed without extension", "logs/a", bzip2Hello, string(bzip2Hello)},
+		// keys which aren't compressed the way their extension says are read
+		// as they are
+		{"plain bz2", "logs/a.bz2", []byte(hello), hello},
+		{"plain zst", "logs/a.zst", []byte(hello), hello},
+		{"empty bz2", "logs/a.bz2", nil, ""},
+		{"empty zst", "logs/a.zst", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := getReaderByExt(ioutil.NopCloser(bytes.NewReader(test.data)), test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			got, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTrimCompressionExt(t *testing.T) {
+	tests := map[string]string{
+		"logs/a.json.gz":  "logs/a.json",
+		"logs/a.json.BZ2": "logs/a.json",
+		"logs/FILE.GZ":    "logs/FILE",
+		"logs/a.json.zst": "logs/a.json",
+		"logs/a.json":     "logs/a.json",
+		"logs/a.tgz":      "logs/a.tgz",
+		"logs/A.TGZ":      "logs/A.TGZ",
+	}
+	for key, want := range tests {
+		if got := trimCompressionExt(key); got != want {
+			t.Errorf("trimCompressionExt(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestTarReaderFor(t *testing.T) {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	if err := writer.WriteHeader(&tar.Header{Name: "a.txt", Mode: 0644, Size: int64(len(hello))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte(hello)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := gzipped(t, buf.String())
+	for _, key := range []string{"bundles/a.tgz", "bundles/A.TGZ", "bundles/a.tar.gz", "bundles/A.TAR.GZ"} {
+		t.Run(key, func(t *testing.T) {
+			reader, err := tarReaderFor(ioutil.NopCloser(bytes.NewReader(archive)), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, err := reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Name != "a.txt" || string(got) != hello {
+				t.Errorf("got %s holding %q, want a.txt holding %q", header.Name, got, hello)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package s3wrapper
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"strings"
+)
+
+// ContentMatch tells whether two objects have the same content
+type ContentMatch string
+
+const (
+	// ContentSame is the match of objects known to have the same content
+	ContentSame ContentMatch = "same"
+	// ContentDifferent is the match of objects known to have different content
+	ContentDifferent ContentMatch = "different"
+	// ContentUnknown is the match of objects whose ETags can't be compared,
+	// which is the case of objects uploaded in several parts
+	ContentUnknown ContentMatch = "unknown"
+)
+
+// sameContent compares the content of a and b from their listing alone. The
+// ETag of an object uploaded in a single part is the MD5 of its content, the
+// one of a multipart upload (ending in -N) is the MD5 of the MD5s of its
+// parts and depends on the part size, so it is only comparable to itself.
+func sameContent(a *ListOutput, b *ListOutput) ContentMatch {
+	if a.Size != b.Size {
+		return ContentDifferent
+	}
+	if a.ETag == "" || b.ETag == "" {
+		return ContentUnknown
+	}
+	if isMultipartETag(a.ETag) || isMultipartETag(b.ETag) {
+		// equal multipart ETags were most likely uploaded with the same
+		// parts, but different ones say nothing about the content
+		if a.ETag == b.ETag {
+			return ContentSame
+		}
+		return ContentUnknown
+	}
+	if a.ETag == b.ETag {
+		return ContentSame
+	}
+	return ContentDifferent
+}
+
+// isMultipartETag tells whether etag is the one of a multipart upload
+func isMultipartETag(etag string) bool {
+	return strings.Contains(etag, "-")
+}
+
+// SameContent compares the content of the objects a and b, their ETags are
+// compared when possible. When they can't be and fullRead is set both objects
+// are downloaded and the MD5 of their content compared, otherwise
+// ContentUnknown is returned.
+func (w *S3Wrapper) SameContent(a *ListOutput, b *ListOutput, fullRead bool) (ContentMatch, error) {
+	match := sameContent(a, b)
+	// the ETags of SSE-C objects aren't the MD5 of their content
+	if w.sseCustomerKey != nil && a.Size == b.Size {
+		match = ContentUnknown
+	}
+	if match != ContentUnknown || !fullRead {
+		return match, nil
+	}
+
+	aSum, err := w.forBucket(a.Bucket).contentMD5(a)
+	if err != nil {
+		return ContentUnknown, err
+	}
+	bSum, err := w.forBucket(b.Bucket).contentMD5(b)
+	if err != nil {
+		return ContentUnknown, err
+	}
+	if bytes.Equal(aSum, bSum) {
+		return ContentSame, nil
+	}
+	return ContentDifferent, nil
+}
+
+// contentMD5 downloads obj and returns the MD5 of its content
+func (w *S3Wrapper) contentMD5(obj *ListOutput) ([]byte, error) {
+	reader, err := w.GetReader(obj.Bucket, obj.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
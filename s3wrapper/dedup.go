@@ -0,0 +1,127 @@
+package s3wrapper
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// maxCopySize is the size of the largest object a single CopyObject request
+// can copy, larger duplicates are uploaded
+const maxCopySize = 5 * 1024 * 1024 * 1024
+
+// dedupIndex maps the sizes and ETags of the objects under the destination
+// of PutAll to one of their keys, so that the files with the content of an
+// existing object can be copied from it rather than uploaded
+type dedupIndex struct {
+	sync.Mutex
+	keys map[int64]map[string]string
+}
+
+// newDedupIndex lists the objects under prefix in bucket into an index, the
+// listing errors are recorded like those of any other listing
+func (w *S3Wrapper) newDedupIndex(bucket string, prefix string) *dedupIndex {
+	index := &dedupIndex{keys: make(map[int64]map[string]string)}
+	for obj := range w.List(FormatS3Uri(bucket, prefix), true, "", "") {
+		if obj.ETag != "" && strings.HasPrefix(obj.Key, prefix) {
+			index.add(obj.Size, obj.ETag, obj.Key)
+		}
+	}
+	return index
+}
+
+// dedupPrefix returns the prefix of the objects the files at localPaths may
+// be duplicates of, which is the directory PutAll uploads them to under
+// destPrefix
+func dedupPrefix(localPaths []string, destPrefix string, delimiter string) string {
+	if destPrefix == "" || strings.HasSuffix(destPrefix, delimiter) || len(localPaths) > 1 {
+		return destPrefix
+	}
+	// a single file is uploaded to destPrefix itself, a directory under it
+	if info, err := os.Stat(localPaths[0]); err == nil && !info.IsDir() {
+		// a dest without a delimiter is a key at the root of the bucket
+		i := strings.LastIndex(destPrefix, delimiter)
+		if i < 0 {
+			return ""
+		}
+		return destPrefix[:i+len(delimiter)]
+	}
+	return destPrefix + delimiter
+}
+
+// add records key as an object of size bytes with etag, the first key of
+// every size and ETag is kept
+func (d *dedupIndex) add(size int64, etag string, key string) {
+	d.Lock()
+	defer d.Unlock()
+	if d.keys[size] == nil {
+		d.keys[size] = make(map[string]string)
+	}
+	if _, ok := d.keys[size][etag]; !ok {
+		d.keys[size][etag] = key
+	}
+}
+
+// lookup returns the key of an object with the content of the file at
+// localPath, which is size bytes, along with the ETag of both. key itself is
+// preferred when it already has that content. Only the files with the size
+// of an indexed object are hashed.
+func (d *dedupIndex) lookup(localPath string, size int64, key string) (string, string, error) {
+	d.Lock()
+	candidates := make(map[string]string, len(d.keys[size]))
+	for etag, existing := range d.keys[size] {
+		candidates[etag] = existing
+	}
+	d.Unlock()
+
+	var matched, matchedETag string
+	var singlePartETag string
+	for etag, existing := range candidates {
+		var matches bool
+		if isMultipartETag(etag) {
+			// the part size of the object is guessed, objects whose part
+			// size isn't a common one never match
+			matches, _ = MatchesETag(localPath, etag, 0)
+		} else {
+			if singlePartETag == "" {
+				var err error
+				if singlePartETag, err = FileETag(localPath); err != nil {
+					return "", "", err
+				}
+			}
+			matches = singlePartETag == etag
+		}
+		if matches && (matched == "" || existing == key) {
+			matched, matchedETag = existing, etag
+		}
+	}
+	return matched, matchedETag, nil
+}
+
+// uploadETag computes the ETag of the upload of the file at localPath, which
+// is size bytes, in parts of partSize bytes
+func uploadETag(localPath string, size int64, partSize int64) (string, error) {
+	if size <= partSize {
+		return FileETag(localPath)
+	}
+	return MultipartETag(localPath, partSize)
+}
+
+// dedupCopy copies the object at existing in bucket to key with the content
+// type, headers and encryption the upload of the file at localPath would
+// have had
+func (w *S3Wrapper) dedupCopy(bucket string, existing string, key string, localPath string, opts PutOptions) error {
+	params := &s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		CopySource:        aws.String("/" + bucket + "/" + existing),
+		Key:               aws.String(key),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		ContentType:       aws.String(contentTypeFor(localPath, opts)),
+	}
+	opts.Headers.applyToCopy(params)
+	opts.Encryption.applyToCopy(params)
+	return w.copyObject(params, opts.Encryption.requestOptions()...)
+}
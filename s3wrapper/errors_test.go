@@ -0,0 +1,162 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// stubS3 returns a client of a stub S3 served by handler
+func stubS3(t *testing.T, handler http.Handler) *s3.S3 {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(srv.URL).
+		WithS3ForcePathStyle(true).
+		WithRegion("us-east-1").
+		WithMaxRetries(0).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s3.New(sess)
+}
+
+// writeStubError answers with status and the S3 error code
+func writeStubError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>stub %s</Message></Error>", code, code)
+}
+
+// failingS3 returns a client of a stub S3 failing every call with status
+func failingS3(t *testing.T, status int, code string) *s3.S3 {
+	return stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeStubError(w, status, code)
+	}))
+}
+
+// keysOf returns a closed channel holding a ListOutput for each key of bucket
+func keysOf(bucket string, keys ...string) chan *ListOutput {
+	ch := make(chan *ListOutput, len(keys))
+	for _, key := range keys {
+		ch <- &ListOutput{Bucket: bucket, Key: key, FullKey: FormatS3Uri(bucket, key), Size: 1}
+	}
+	close(ch)
+	return ch
+}
+
+// drain reads ch until it's closed and returns how many values it read,
+// failing the test if it isn't closed within a few seconds
+func drain(t *testing.T, ch chan *ListOutput) int {
+	n := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return n
+			}
+			n++
+		case <-timeout:
+			t.Fatal("the output wasn't closed")
+		}
+	}
+}
+
+// drainLines is drain for the lines of Stream
+func drainLines(t *testing.T, lines chan string) int {
+	ch := make(chan *ListOutput)
+	go func() {
+		defer close(ch)
+		for range lines {
+			ch <- &ListOutput{}
+		}
+	}()
+	return drain(t, ch)
+}
+
+func TestInjectedFailures(t *testing.T) {
+	statuses := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusInternalServerError, "InternalError"},
+		{http.StatusForbidden, "AccessDenied"},
+	}
+	operations := []struct {
+		name string
+		run  func(t *testing.T, w *S3Wrapper) int
+	}{
+		{"List", func(t *testing.T, w *S3Wrapper) int {
+			return drain(t, w.List("s3://bk/logs/", true, "/", ""))
+		}},
+		{"ListAll", func(t *testing.T, w *S3Wrapper) int {
+			return drain(t, w.ListAll([]string{"s3://bk/logs/", "s3://bk/other/"}, true, "/", ""))
+		}},
+		{"GetAll", func(t *testing.T, w *S3Wrapper) int {
+			t.Chdir(t.TempDir())
+			return drain(t, w.GetAll(keysOf("bk", "logs/a.gz", "logs/b.txt"), GetOptions{}))
+		}},
+		{"Stream", func(t *testing.T, w *S3Wrapper) int {
+			return drainLines(t, w.Stream(keysOf("bk", "logs/a.gz", "logs/b.txt"), StreamOptions{}))
+		}},
+		{"CopyAll", func(t *testing.T, w *S3Wrapper) int {
+			return drain(t, w.CopyAll(keysOf("bk", "logs/a.gz", "logs/b.txt"), "s3://bk/logs/", "s3://dest/", "/", true, CopyOptions{}))
+		}},
+		{"DeleteObjects", func(t *testing.T, w *S3Wrapper) int {
+			return drain(t, w.DeleteObjects(keysOf("bk", "logs/a.gz", "logs/b.txt")))
+		}},
+	}
+	for _, status := range statuses {
+		for _, op := range operations {
+			t.Run(fmt.Sprintf("%s %s", op.name, status.code), func(t *testing.T) {
+				w := New(failingS3(t, status.status, status.code), 4).WithLogger(discardLogger{})
+				if n := op.run(t, w); n != 0 {
+					t.Errorf("got %d outputs, want none", n)
+				}
+				if w.Err() == nil {
+					t.Error("got no error")
+				}
+			})
+		}
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	w := New(nil, 1)
+	func() {
+		defer w.recoverPanic()
+		panic("boom")
+	}()
+	if err := w.Err(); err == nil || err.Error() != "recovered from panic: boom" {
+		t.Errorf("got %v, want the recovered panic", err)
+	}
+}
+
+func TestListKeyVersionsRecoversPanic(t *testing.T) {
+	w := New(nil, 1)
+	keys := make(chan *ListOutput, 1)
+	// a nil key makes the loop handing out the keys panic
+	keys <- nil
+	close(keys)
+	if n := drain(t, w.ListKeyVersions(keys)); n != 0 {
+		t.Errorf("got %d outputs, want none", n)
+	}
+	if err := w.Err(); err == nil || !strings.Contains(err.Error(), "recovered from panic") {
+		t.Errorf("got %v, want the recovered panic", err)
+	}
+}
+
+// discardLogger drops the diagnostics of the wrapper
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
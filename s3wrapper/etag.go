@@ -0,0 +1,127 @@
+package s3wrapper
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// commonPartSizes are the part sizes of the usual uploaders, tried in order
+// when matching a multipart ETag without a known part size: the SDKs (and
+// fasts3 put) upload 5MiB parts, the AWS CLI 8MiB ones
+var commonPartSizes = []int64{
+	s3manager.DefaultUploadPartSize,
+	8 * 1024 * 1024,
+	16 * 1024 * 1024,
+	64 * 1024 * 1024,
+	100 * 1024 * 1024,
+}
+
+// FileETag computes the ETag S3 gives a single part upload of the file at
+// localPath, which is the MD5 of its content
+func FileETag(localPath string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// MultipartETag computes the ETag S3 gives a multipart upload of the file at
+// localPath in parts of partSize bytes, which is the MD5 of the MD5s of the
+// parts followed by -N, N being the number of parts
+func MultipartETag(localPath string, partSize int64) (string, error) {
+	if partSize <= 0 {
+		return "", fmt.Errorf("invalid part size %d", partSize)
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sums := md5.New()
+	parts := 0
+	for {
+		part := md5.New()
+		n, err := io.CopyN(part, file, partSize)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		// an empty last part is never uploaded, unless the file is empty
+		if n > 0 || parts == 0 {
+			sums.Write(part.Sum(nil))
+			parts++
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), parts), nil
+}
+
+// MatchesETag tells whether the file at localPath has the content of an
+// object whose ETag is etag. The part size of multipart ETags is partSize
+// when it is above 0, otherwise the common part sizes giving the number of
+// parts of etag are tried. An error is returned when no part size is known
+// to give that number of parts.
+func MatchesETag(localPath string, etag string, partSize int64) (bool, error) {
+	etag = strings.Trim(etag, `"`)
+	if !isMultipartETag(etag) {
+		local, err := FileETag(localPath)
+		return local == etag, err
+	}
+
+	parts, err := strconv.ParseInt(etag[strings.LastIndex(etag, "-")+1:], 10, 64)
+	if err != nil || parts <= 0 {
+		return false, fmt.Errorf("invalid multipart ETag %q", etag)
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return false, err
+	}
+
+	candidates := []int64{partSize}
+	if partSize <= 0 {
+		candidates = make([]int64, 0, len(commonPartSizes))
+		for _, size := range commonPartSizes {
+			if partCount(info.Size(), size) == parts {
+				candidates = append(candidates, size)
+			}
+		}
+		if len(candidates) == 0 {
+			return false, fmt.Errorf("unable to guess the part size of ETag %q for %s, none of the common ones gives %d parts of %d bytes", etag, localPath, parts, info.Size())
+		}
+	}
+	for _, size := range candidates {
+		local, err := MultipartETag(localPath, size)
+		if err != nil {
+			return false, err
+		}
+		if local == etag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// partCount returns the number of parts of size partSize a file of size
+// bytes is uploaded in
+func partCount(size int64, partSize int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + partSize - 1) / partSize
+}
@@ -0,0 +1,93 @@
+package s3wrapper
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeTemp writes content to a file of a temporary directory and returns
+// its path
+func writeTemp(t *testing.T, content []byte) string {
+	localPath := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return localPath
+}
+
+// fiveMiBAndOne is one byte larger than the default part size of 5MiB, so it
+// is uploaded in two parts of that size or in a single part of 8MiB
+func fiveMiBAndOne() []byte {
+	content := make([]byte, 5*1024*1024+1)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return content
+}
+
+func TestMultipartETag(t *testing.T) {
+	// the ETags S3 gives these uploads, computed independently as the MD5 of
+	// the concatenated MD5s of the parts
+	tests := []struct {
+		name     string
+		content  []byte
+		partSize int64
+		want     string
+	}{
+		{"three parts, short last one", []byte("0123456789"), 4, "61e3716e3a7767581863b67c4e785584-3"},
+		{"two full parts", []byte("0123456789"), 5, "9a6dbec798b1bfe66cc7659d2bb41720-2"},
+		{"single part", []byte("0123456789"), 10, "8e938564cd1410f0ec1c1781466a6738-1"},
+		{"empty", nil, 4, "59adb24ef3cdbe0297f05b395827453f-1"},
+		{"default part size", fiveMiBAndOne(), 5 * 1024 * 1024, "285c0ca0352736b999524a583a2c9659-2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := MultipartETag(writeTemp(t, test.content), test.partSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	if _, err := MultipartETag(writeTemp(t, []byte("a")), 0); err == nil {
+		t.Error("expected an error with a part size of 0")
+	}
+}
+
+func TestMatchesETag(t *testing.T) {
+	big := writeTemp(t, fiveMiBAndOne())
+	small := writeTemp(t, []byte("0123456789"))
+	tests := []struct {
+		name      string
+		localPath string
+		etag      string
+		partSize  int64
+		want      bool
+		wantErr   bool
+	}{
+		{"single part", small, `"781e5e245d69b566979b86e28d23f2c7"`, 0, true, false},
+		{"single part mismatch", small, "00000000000000000000000000000000", 0, false, false},
+		{"given part size", small, "61e3716e3a7767581863b67c4e785584-3", 4, true, false},
+		{"wrong part size", small, "61e3716e3a7767581863b67c4e785584-3", 5, false, false},
+		// 2 parts of 5MiB is the default part size, 1 part of 8MiB the AWS
+		// CLI one
+		{"guessed part size", big, "285c0ca0352736b999524a583a2c9659-2", 0, true, false},
+		{"guessed other part size", big, "74ee57f173a25890f040044b17a0f5d1-1", 0, true, false},
+		{"no common part size", small, "61e3716e3a7767581863b67c4e785584-3", 0, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := MatchesETag(test.localPath, test.etag, test.partSize)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want one: %t", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package s3wrapper
+
+import (
+	"sync"
+)
+
+// Failures collects the keys the wrapper's downloads and copies failed on,
+// so that they can be retried without going through all the keys again
+type Failures struct {
+	sync.Mutex
+	keys []*ListOutput
+}
+
+// add records k
+func (f *Failures) add(k *ListOutput) {
+	f.Lock()
+	defer f.Unlock()
+	f.keys = append(f.keys, k)
+}
+
+// Keys returns the keys recorded so far
+func (f *Failures) Keys() []*ListOutput {
+	f.Lock()
+	defer f.Unlock()
+	return append([]*ListOutput(nil), f.keys...)
+}
+
+// WithFailures makes the wrapper record the keys GetAll and CopyAll fail on
+// in failures
+func (w *S3Wrapper) WithFailures(failures *Failures) *S3Wrapper {
+	w.failures = failures
+	return w
+}
+
+// fail records err, which made the operation on k fail
+func (w *S3Wrapper) fail(k *ListOutput, err error) {
+	w.errs.add(err)
+	if w.failures != nil {
+		w.failures.add(k)
+	}
+}
@@ -0,0 +1,137 @@
+package s3wrapper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// GrepOutput is a key along with the lines of it which matched
+type GrepOutput struct {
+	*ListOutput
+	// Count is the number of matching lines, only 1 when the search stopped
+	// at the first match
+	Count int64
+	// Lines are the matching lines and their context lines with their line
+	// numbers in order, only collected when asked for
+	Lines []*GrepLine
+}
+
+// GrepLine is a single matching or context line
+type GrepLine struct {
+	Number  int64
+	Text    string
+	Context bool
+}
+
+// Grep searches the lines of every key in the given keys channel for re, only
+// keys with at least one match are sent to the returned channel, each with
+// all of its matches so the output of a key is never mixed with others.
+// collectLines keeps the matching lines instead of only counting them, along
+// with up to before lines preceding and after lines following each of them,
+// firstMatch stops reading a key as soon as it matched once
+func (w *S3Wrapper) Grep(keys chan *ListOutput, re *regexp.Regexp, collectLines bool, firstMatch bool, before int, after int) chan *GrepOutput {
+	grepOut := make(chan *GrepOutput, 10000)
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(grepOut)
+		}()
+		defer w.recoverPanic()
+		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
+			wg.Add(1)
+			go func(k *ListOutput) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+
+				reader, err := w.GetReader(k.Bucket, k.Key)
+				if err != nil {
+					w.errs.add(fmt.Errorf("unable to get %s: %s", k.FullKey, err))
+					return
+				}
+				// closing the body early on a first match drops the rest of
+				// the download
+				defer reader.Close()
+				extReader, err := getReaderByExt(reader, k.Key)
+				if err != nil {
+					w.errs.add(fmt.Errorf("unable to read %s: %s", k.FullKey, err))
+					return
+				}
+
+				out := &GrepOutput{ListOutput: k}
+				bufExtReader := bufio.NewReader(extReader)
+				var lineNumber int64
+				// beforeLines is a ring buffer of the last lines which
+				// didn't match, afterLeft counts down the context lines
+				// still to keep after the last match, and lastKept is the
+				// number of the last line kept so context lines shared by
+				// nearby matches are only kept once
+				beforeLines := make([]*GrepLine, before)
+				afterLeft := 0
+				var lastKept int64
+				for !w.draining() {
+					line, err := bufExtReader.ReadBytes('\n')
+					if err != nil && err != io.EOF {
+						w.errs.add(fmt.Errorf("unable to read %s: %s", k.FullKey, err))
+						return
+					}
+
+					// the last read of a key ending in a newline is empty
+					if len(line) > 0 {
+						lineNumber++
+						// the line is matched without its terminator so
+						// patterns can be anchored to its end, and kept
+						// with it for the output
+						text := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+						if re.Match(text) {
+							out.Count++
+							if collectLines {
+								for i := int64(0); i < int64(before); i++ {
+									n := lineNumber - int64(before) + i
+									if n < 1 || n <= lastKept {
+										continue
+									}
+									if prev := beforeLines[n%int64(before)]; prev != nil && prev.Number == n {
+										out.Lines = append(out.Lines, prev)
+									}
+								}
+								out.Lines = append(out.Lines, &GrepLine{Number: lineNumber, Text: string(line)})
+								lastKept = lineNumber
+								afterLeft = after
+							}
+							if firstMatch {
+								break
+							}
+						} else if collectLines && afterLeft > 0 {
+							out.Lines = append(out.Lines, &GrepLine{Number: lineNumber, Text: string(line), Context: true})
+							lastKept = lineNumber
+							afterLeft--
+						} else if collectLines && before > 0 {
+							beforeLines[lineNumber%int64(before)] = &GrepLine{Number: lineNumber, Text: string(line), Context: true}
+						}
+					}
+					if err != nil {
+						break
+					}
+				}
+				if out.Count > 0 {
+					grepOut <- out
+				}
+			}(key)
+		}
+	}()
+
+	return grepOut
+}
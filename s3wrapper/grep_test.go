@@ -0,0 +1,68 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestGrepAnchoredToLineEnd(t *testing.T) {
+	w := New(stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "an error\nno errors here\r\nanother error\r\nlast error")
+	})), 1)
+	var got []string
+	for out := range w.Grep(keysOf("bk", "a.txt"), regexp.MustCompile(`error$`), true, false, 0, 0) {
+		for _, line := range out.Lines {
+			got = append(got, fmt.Sprintf("%d:%q", line.Number, line.Text))
+		}
+	}
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// the lines are output with their terminator
+	want := fmt.Sprint([]string{`1:"an error\n"`, `3:"another error\r\n"`, `4:"last error"`})
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGrepContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		before int
+		after  int
+		want   []string
+	}{
+		{"before", "a\nb\nMATCH\nc\n", 2, 0, []string{"1-", "2-", "3:"}},
+		{"after", "MATCH\na\nb\nc\n", 0, 2, []string{"1:", "2-", "3-"}},
+		{"adjacent matches", "a\nb\nMATCH\nMATCH\n", 2, 0, []string{"1-", "2-", "3:", "4:"}},
+		{"overlapping before", "a\nMATCH\nb\nMATCH\n", 2, 0, []string{"1-", "2:", "3-", "4:"}},
+		{"overlapping before and after", "a\nMATCH\nb\nc\nMATCH\nd\ne\n", 2, 2, []string{"1-", "2:", "3-", "4-", "5:", "6-", "7-"}},
+		{"separate groups", "MATCH\na\nb\nc\nd\nMATCH\n", 1, 1, []string{"1:", "2-", "5-", "6:"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := New(stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, test.body)
+			})), 1)
+			var got []string
+			for out := range w.Grep(keysOf("bk", "a.txt"), regexp.MustCompile(`MATCH`), true, false, test.before, test.after) {
+				for _, line := range out.Lines {
+					sep := ":"
+					if line.Context {
+						sep = "-"
+					}
+					got = append(got, fmt.Sprintf("%d%s", line.Number, sep))
+				}
+			}
+			if err := w.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package s3wrapper
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Headers are the response headers stored with an object when it is
+// written, empty fields aren't set
+type Headers struct {
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	Expires            time.Time
+}
+
+// IsZero tells whether none of the headers are set
+func (h Headers) IsZero() bool {
+	return h == Headers{}
+}
+
+// applyToUpload sets the headers which are set on params
+func (h Headers) applyToUpload(params *s3manager.UploadInput) {
+	if h.CacheControl != "" {
+		params.CacheControl = aws.String(h.CacheControl)
+	}
+	if h.ContentDisposition != "" {
+		params.ContentDisposition = aws.String(h.ContentDisposition)
+	}
+	if h.ContentEncoding != "" {
+		params.ContentEncoding = aws.String(h.ContentEncoding)
+	}
+	if !h.Expires.IsZero() {
+		params.Expires = aws.Time(h.Expires)
+	}
+}
+
+// applyToCopy sets the headers which are set on params, which only takes
+// effect with the REPLACE metadata directive
+func (h Headers) applyToCopy(params *s3.CopyObjectInput) {
+	if h.CacheControl != "" {
+		params.CacheControl = aws.String(h.CacheControl)
+	}
+	if h.ContentDisposition != "" {
+		params.ContentDisposition = aws.String(h.ContentDisposition)
+	}
+	if h.ContentEncoding != "" {
+		params.ContentEncoding = aws.String(h.ContentEncoding)
+	}
+	if !h.Expires.IsZero() {
+		params.Expires = aws.Time(h.Expires)
+	}
+}
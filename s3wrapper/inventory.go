@@ -0,0 +1,173 @@
+package s3wrapper
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inventoryManifest is the manifest.json of an S3 Inventory report
+type inventoryManifest struct {
+	SourceBucket string `json:"sourceBucket"`
+	FileFormat   string `json:"fileFormat"`
+	FileSchema   string `json:"fileSchema"`
+	Files        []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// ListInventory lists the objects of the S3 Inventory report whose
+// manifest.json is at manifestUri instead of listing the bucket, the data
+// files of the report are read in parallel. Only CSV reports are supported.
+// keyRegex is a regex filter on keys.
+func (w *S3Wrapper) ListInventory(manifestUri string, keyRegex string) chan *ListOutput {
+	ch := make(chan *ListOutput, 10000)
+	var keyRegexFilter *regexp.Regexp
+	if keyRegex != "" {
+		var err error
+		keyRegexFilter, err = regexp.Compile(keyRegex)
+		if err != nil {
+			w.errs.add(err)
+			close(ch)
+			return ch
+		}
+	}
+
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(ch)
+		}()
+		defer w.recoverPanic()
+
+		manifest, err := w.readInventoryManifest(manifestUri)
+		if err != nil {
+			w.errs.add(fmt.Errorf("unable to read inventory manifest %s: %s", manifestUri, err))
+			return
+		}
+		columns := make(map[string]int)
+		for i, column := range strings.Split(manifest.FileSchema, ",") {
+			columns[strings.TrimSpace(column)] = i
+		}
+		if _, ok := columns["Key"]; !ok {
+			w.errs.add(fmt.Errorf("unable to read inventory manifest %s: its schema has no Key column", manifestUri))
+			return
+		}
+
+		// the data files are stored in the same bucket as the manifest
+		destBucket, _ := ParseS3Uri(manifestUri)
+		for _, file := range manifest.Files {
+			if w.draining() {
+				return
+			}
+			wg.Add(1)
+			go func(dataKey string) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+
+				if err := w.readInventoryFile(destBucket, dataKey, manifest.SourceBucket, columns, keyRegexFilter, ch); err != nil {
+					w.errs.add(fmt.Errorf("unable to read inventory file %s: %s", FormatS3Uri(destBucket, dataKey), err))
+				}
+			}(file.Key)
+		}
+	}()
+
+	return ch
+}
+
+// readInventoryManifest downloads and parses the manifest at manifestUri
+func (w *S3Wrapper) readInventoryManifest(manifestUri string) (*inventoryManifest, error) {
+	bucket, key := ParseS3Uri(manifestUri)
+	reader, err := w.GetReader(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	manifest := &inventoryManifest{}
+	if err := json.NewDecoder(reader).Decode(manifest); err != nil {
+		return nil, err
+	}
+	if manifest.FileFormat != "CSV" {
+		return nil, fmt.Errorf("only CSV inventories are supported, this one is %s", manifest.FileFormat)
+	}
+	return manifest, nil
+}
+
+// readInventoryFile sends the objects of the gzipped CSV data file at
+// dataKey which match keyRegexFilter to ch, columns maps the names of the
+// columns of the file to their index
+func (w *S3Wrapper) readInventoryFile(bucket string, dataKey string, sourceBucket string, columns map[string]int, keyRegexFilter *regexp.Regexp, ch chan *ListOutput) error {
+	reader, err := w.GetReader(bucket, dataKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	extReader, err := getReaderByExt(reader, dataKey)
+	if err != nil {
+		return err
+	}
+
+	csvReader := csv.NewReader(extReader)
+	csvReader.FieldsPerRecord = -1
+	for !w.draining() {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		// a key with versions is listed once per version, only the latest
+		// one is an object a listing would return
+		if field("IsLatest") == "false" || field("IsDeleteMarker") == "true" {
+			continue
+		}
+
+		// keys are URL encoded in inventory reports
+		key, err := url.QueryUnescape(field("Key"))
+		if err != nil {
+			key = field("Key")
+		}
+		objBucket := field("Bucket")
+		if objBucket == "" {
+			objBucket = sourceBucket
+		}
+		formattedKey := FormatS3Uri(objBucket, key)
+		if keyRegexFilter != nil && !keyRegexFilter.MatchString(formattedKey) {
+			continue
+		}
+		size, _ := strconv.ParseInt(field("Size"), 10, 64)
+		lastModified, _ := time.Parse(time.RFC3339, field("LastModifiedDate"))
+		ch <- &ListOutput{
+			IsPrefix:     false,
+			Key:          key,
+			FullKey:      formattedKey,
+			LastModified: lastModified,
+			Size:         size,
+			Bucket:       objBucket,
+			ETag:         field("ETag"),
+		}
+	}
+	return nil
+}
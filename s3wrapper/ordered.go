@@ -0,0 +1,137 @@
+package s3wrapper
+
+import (
+	"strings"
+	"sync"
+)
+
+// orderedKey is a key being streamed by StreamOrdered along with its data
+type orderedKey struct {
+	key  *ListOutput
+	data chan string
+}
+
+// StreamOrdered provides a channel with data from the keys like Stream, the
+// data of a key is only sent once all the data of the keys listed before it
+// was. Keys are still downloaded concurrently, the data of the keys which
+// aren't the next to send is buffered up to bufferSize bytes, the next key is
+// never held back by the buffer. opts.Separator is sent between the data of
+// consecutive keys.
+func (w *S3Wrapper) StreamOrdered(keys chan *ListOutput, opts StreamOptions, bufferSize int64) chan string {
+	lines := make(chan string, 10000)
+	buffer := newOrderedBuffer(bufferSize)
+	totals := &wcTotals{}
+	// pending holds every key being downloaded, with its data channel, in
+	// listing order
+	pending := make(chan *orderedKey, cap(w.concurrencySemaphore))
+
+	go func() {
+		defer close(pending)
+		defer w.recoverPanic()
+		var index int64
+		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
+			// the semaphore is acquired here, in listing order, so the next
+			// key to send always gets a slot before the ones after it
+			w.acquire()
+			data := make(chan string, 1000)
+			pending <- &orderedKey{key: key, data: data}
+			go func(key *ListOutput, index int64) {
+				defer w.release()
+				defer close(data)
+				defer w.recoverPanic()
+				if w.draining() {
+					return
+				}
+
+				w.streamKey(key, opts, totals, func(chunk string) {
+					buffer.reserve(index, int64(len(chunk)))
+					data <- chunk
+				})
+			}(key, index)
+			index++
+		}
+	}()
+
+	go func() {
+		defer close(lines)
+		first := true
+		for next := range pending {
+			if separator := separatorFor(opts.Separator, next.key, first); separator != "" {
+				lines <- separator
+			}
+			first = false
+			for chunk := range next.data {
+				buffer.release(int64(len(chunk)))
+				lines <- chunk
+			}
+			buffer.advance()
+		}
+		// every key added its counts before closing its data channel
+		if opts.Stats {
+			totals.emit(func(line string) { lines <- line })
+		}
+	}()
+
+	return lines
+}
+
+// separatorFor returns the separator to send before the data of key, which
+// is the first key when first is set. A separator containing {key}, which is
+// replaced by the uri of key, is a header sent before every key, any other
+// separator is only sent between keys.
+func separatorFor(separator string, key *ListOutput, first bool) string {
+	if !strings.Contains(separator, "{key}") {
+		if first {
+			return ""
+		}
+		return separator
+	}
+	return strings.Replace(separator, "{key}", key.FullKey, -1)
+}
+
+// orderedBuffer bounds the bytes buffered by StreamOrdered for the keys
+// after the one being sent
+type orderedBuffer struct {
+	sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+	// next is the index of the key being sent
+	next int64
+}
+
+func newOrderedBuffer(limit int64) *orderedBuffer {
+	b := &orderedBuffer{limit: limit}
+	b.cond = sync.NewCond(b)
+	return b
+}
+
+// reserve waits until size bytes of the key at index can be buffered, the
+// key being sent and a first chunk when nothing is buffered never wait
+func (b *orderedBuffer) reserve(index int64, size int64) {
+	b.Lock()
+	defer b.Unlock()
+	for index != b.next && b.used > 0 && b.used+size > b.limit {
+		b.cond.Wait()
+	}
+	b.used += size
+}
+
+// release frees size bytes which were sent
+func (b *orderedBuffer) release(size int64) {
+	b.Lock()
+	defer b.Unlock()
+	b.used -= size
+	b.cond.Broadcast()
+}
+
+// advance moves on to sending the next key
+func (b *orderedBuffer) advance() {
+	b.Lock()
+	defer b.Unlock()
+	b.next++
+	b.cond.Broadcast()
+}
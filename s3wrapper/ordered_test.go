@@ -0,0 +1,67 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowS3 returns a client of a stub S3 serving the keys of bodies, the keys
+// listed first are served last
+func slowS3(t *testing.T, keys []string, bodies map[string]string) *S3Wrapper {
+	delays := make(map[string]time.Duration)
+	for i, key := range keys {
+		delays["/bk/"+key] = time.Duration(len(keys)-i) * time.Millisecond
+	}
+	return New(stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delays[r.URL.Path])
+		fmt.Fprint(w, bodies[strings.TrimPrefix(r.URL.Path, "/bk/")])
+	})), 1)
+}
+
+func TestStreamOrderedParallel(t *testing.T) {
+	var keys []string
+	bodies := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("logs/%02d.txt", i)
+		keys = append(keys, key)
+		bodies[key] = strings.Repeat(fmt.Sprintf("line of %s\n", key), i*50)
+	}
+	tests := []struct {
+		name       string
+		opts       StreamOptions
+		bufferSize int64
+	}{
+		{"lines", StreamOptions{}, 1 << 20},
+		{"raw", StreamOptions{Raw: true}, 1 << 20},
+		{"key names", StreamOptions{IncludeKeyName: true, LineNumbers: true}, 1 << 20},
+		{"separator", StreamOptions{Separator: "==> {key} <==\n"}, 1 << 20},
+		// keys are held back once the data buffered ahead of the next one
+		// reaches the buffer
+		{"small buffer", StreamOptions{}, 100},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream := func(concurrency int) string {
+				w := slowS3(t, keys, bodies).WithMaxConcurrency(concurrency)
+				var out strings.Builder
+				for chunk := range w.StreamOrdered(keysOf("bk", keys...), test.opts, test.bufferSize) {
+					out.WriteString(chunk)
+				}
+				if err := w.Err(); err != nil {
+					t.Fatal(err)
+				}
+				return out.String()
+			}
+			sequential := stream(1)
+			if sequential == "" {
+				t.Fatal("streamed nothing")
+			}
+			if parallel := stream(10); parallel != sequential {
+				t.Errorf("got %d bytes streaming 10 keys at a time which differ from the %d bytes streaming them one at a time", len(parallel), len(sequential))
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package s3wrapper
+
+// Plan is what a sync would change: the objects which don't exist at the
+// destination yet, the ones which exist but changed and the destination
+// objects which aren't at the source anymore
+type Plan struct {
+	Create []*ListOutput
+	Update []*ListOutput
+	Delete []*ListOutput
+}
+
+// Pending tells whether the plan changes anything
+func (p *Plan) Pending() bool {
+	return len(p.Create) > 0 || len(p.Update) > 0 || len(p.Delete) > 0
+}
+
+// Bytes returns the bytes transferred to create and update objects and the
+// bytes of the objects deleted
+func (p *Plan) Bytes() (transferred int64, deleted int64) {
+	for _, k := range p.Create {
+		transferred += k.Size
+	}
+	for _, k := range p.Update {
+		transferred += k.Size
+	}
+	for _, k := range p.Delete {
+		deleted += k.Size
+	}
+	return transferred, deleted
+}
@@ -0,0 +1,374 @@
+package s3wrapper
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// defaultContentType is the content type of uploads whose type couldn't be
+// detected
+const defaultContentType = "application/octet-stream"
+
+// PutOptions are the options applied to every uploaded object
+type PutOptions struct {
+	// ContentType overrides the detected content type of every object
+	ContentType string
+	// ContentTypes maps extensions, including their leading dot, to the
+	// content type of the files with that extension, it takes precedence
+	// over mime.TypeByExtension
+	ContentTypes map[string]string
+	Headers      Headers
+	// Encryption is how the uploaded objects are encrypted
+	Encryption ServerSideEncryption
+	// ContentMD5 hashes every file before uploading it, files uploaded in a
+	// single part send their MD5 so S3 rejects the upload when the content
+	// it receives differs, files uploaded in several parts are checked
+	// against the ETag of the object afterwards
+	ContentMD5 bool
+	// FollowSymlinks uploads the targets of the symlinks found walking
+	// directories, they are skipped otherwise
+	FollowSymlinks bool
+	// Dedup skips the files whose key already has their content, and copies
+	// the files with the content of another object under the directory of
+	// the destination from it rather than upload them
+	Dedup bool
+	// Filter is called with every file and the key it would be uploaded to
+	// when set, only the files it returns true for are uploaded
+	Filter func(localPath string, key string, size int64) bool
+}
+
+// PutOutput is an uploaded key along with the local file it was read from
+type PutOutput struct {
+	*ListOutput
+	LocalPath string
+	// DedupSource is the uri of the object which already had the content of
+	// the file with Dedup, the file was copied from it, or not uploaded at
+	// all when it is the uri of the file's own key
+	DedupSource string
+}
+
+// PutAll uploads the local files at localPaths under dest, directories are
+// walked when recurse is set and their files are uploaded under dest keeping
+// their path relative to the directory, a single file is uploaded to dest
+// itself unless dest ends with delimiter
+func (w *S3Wrapper) PutAll(localPaths []string, dest string, delimiter string, recurse bool, opts PutOptions) chan *PutOutput {
+	destBucket, destPrefix := ParseS3Uri(dest)
+	uploader := s3manager.NewUploaderWithClient(w.client(destBucket))
+
+	putOut := make(chan *PutOutput, 10000)
+	var wg sync.WaitGroup
+	var index *dedupIndex
+	upload := func(localPath string, key string, size int64) {
+		if opts.Filter != nil && !opts.Filter(localPath, key, size) {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer w.recoverPanic()
+			w.acquire()
+			defer w.release()
+			if w.draining() {
+				return
+			}
+
+			if index != nil {
+				existing, etag, err := index.lookup(localPath, size, key)
+				if err != nil {
+					w.errs.add(fmt.Errorf("unable to upload %s: %s", localPath, err))
+					return
+				}
+				if existing == key || (existing != "" && size <= maxCopySize) {
+					if existing != key {
+						if err := w.dedupCopy(destBucket, existing, key, localPath, opts); err != nil {
+							w.errs.add(fmt.Errorf("unable to copy %s to %s: %s", FormatS3Uri(destBucket, existing), FormatS3Uri(destBucket, key), err))
+							return
+						}
+					}
+					putOut <- &PutOutput{
+						ListOutput: &ListOutput{
+							Size:    size,
+							Key:     key,
+							Bucket:  destBucket,
+							FullKey: FormatS3Uri(destBucket, key),
+							ETag:    etag,
+						},
+						LocalPath:   localPath,
+						DedupSource: FormatS3Uri(destBucket, existing),
+					}
+					return
+				}
+			}
+
+			if !w.dryRun {
+				var contentMD5 *string
+				if opts.ContentMD5 && size <= uploader.PartSize {
+					sum, err := fileContentMD5(localPath)
+					if err != nil {
+						w.errs.add(fmt.Errorf("unable to upload %s: %s", localPath, err))
+						return
+					}
+					contentMD5 = aws.String(sum)
+				}
+				err := w.retryWrite(func() error {
+					// the file is opened on every attempt so a retry reads it
+					// from the start
+					file, err := os.Open(localPath)
+					if err != nil {
+						return err
+					}
+					defer file.Close()
+					params := &s3manager.UploadInput{
+						Body:         file,
+						Bucket:       aws.String(destBucket),
+						ContentType:  aws.String(contentTypeFor(localPath, opts)),
+						Key:          aws.String(key),
+						RequestPayer: w.requestPayer,
+						ContentMD5:   contentMD5,
+					}
+					opts.Headers.applyToUpload(params)
+					opts.Encryption.applyToUpload(params)
+					w.sseCustomerKey.applyToUpload(params)
+					_, err = uploader.UploadWithContext(w.ctx, params, s3manager.WithUploaderRequestOptions(opts.Encryption.requestOptions()...))
+					return err
+				})
+				if err != nil {
+					w.errs.add(fmt.Errorf("unable to upload %s: %s", localPath, err))
+					return
+				}
+				partSize := uploadPartSize(size, uploader.PartSize)
+				if opts.ContentMD5 && size > partSize {
+					if err := w.verifyUpload(localPath, destBucket, key, partSize, opts); err != nil {
+						w.errs.add(err)
+						return
+					}
+				}
+				if index != nil {
+					// the later files with the same content are copied
+					// from this one
+					etag, err := uploadETag(localPath, size, partSize)
+					if err != nil {
+						w.errs.add(fmt.Errorf("unable to hash %s: %s", localPath, err))
+						return
+					}
+					index.add(size, etag, key)
+				}
+			}
+			putOut <- &PutOutput{
+				ListOutput: &ListOutput{
+					Size:    size,
+					Key:     key,
+					Bucket:  destBucket,
+					FullKey: FormatS3Uri(destBucket, key),
+				},
+				LocalPath: localPath,
+			}
+		}()
+	}
+
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(putOut)
+		}()
+		defer w.recoverPanic()
+		if opts.Dedup {
+			index = w.newDedupIndex(destBucket, dedupPrefix(localPaths, destPrefix, delimiter))
+		}
+		for _, localPath := range localPaths {
+			if w.draining() {
+				break
+			}
+			info, err := os.Stat(localPath)
+			if err != nil {
+				w.errs.add(fmt.Errorf("unable to upload %s: %s", localPath, err))
+				continue
+			}
+			if !info.IsDir() {
+				key := destPrefix
+				if key == "" || strings.HasSuffix(key, delimiter) || len(localPaths) > 1 {
+					key += filepath.Base(localPath)
+				}
+				upload(localPath, key, info.Size())
+				continue
+			}
+			if !recurse {
+				w.errs.add(fmt.Errorf("unable to upload %s: it is a directory, use --recursive", localPath))
+				continue
+			}
+
+			prefix := destPrefix
+			if prefix != "" && !strings.HasSuffix(prefix, delimiter) {
+				prefix += delimiter
+			}
+			err = w.walkFiles(localPath, "", []os.FileInfo{info}, opts.FollowSymlinks, func(filePath string, rel string, size int64) {
+				upload(filePath, prefix+strings.Join(strings.Split(rel, string(filepath.Separator)), delimiter), size)
+			})
+			if err != nil {
+				w.errs.add(fmt.Errorf("unable to walk %s: %s", localPath, err))
+			}
+		}
+	}()
+
+	return putOut
+}
+
+// walkFiles calls upload with every regular file under dir along with its
+// path relative to the directory walked, relDir is the path of dir relative
+// to it. Symlinks are skipped with a warning unless follow is set, in which
+// case the files they point to are uploaded and the directories they point
+// to walked, ancestors are the directories followed so far which a symlink
+// pointing back to would make the walk loop.
+func (w *S3Wrapper) walkFiles(dir string, relDir string, ancestors []os.FileInfo, follow bool, upload func(filePath string, rel string, size int64)) error {
+	return filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if w.draining() {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.Join(relDir, rel)
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			if !follow {
+				w.logger.Printf("WARN: skipping symlink %s, use --follow-symlinks to upload its target\n", filePath)
+				return nil
+			}
+			target, err := os.Stat(filePath)
+			if err != nil {
+				w.errs.add(fmt.Errorf("unable to upload %s: %s", filePath, err))
+				return nil
+			}
+			if target.Mode().IsRegular() {
+				upload(filePath, rel, target.Size())
+				return nil
+			}
+			if !target.IsDir() {
+				return nil
+			}
+			parents, err := parentDirs(dir, filePath)
+			if err != nil {
+				return err
+			}
+			followed := append(parents, ancestors...)
+			for _, ancestor := range followed {
+				if os.SameFile(ancestor, target) {
+					w.logger.Printf("WARN: skipping symlink %s, it points to a directory it is in\n", filePath)
+					return nil
+				}
+			}
+			// the trailing separator makes WalkDir walk the directory the
+			// symlink points to rather than the symlink itself
+			return w.walkFiles(filePath+string(filepath.Separator), rel, append(followed, target), follow, upload)
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		upload(filePath, rel, info.Size())
+		return nil
+	})
+}
+
+// parentDirs returns the directories from filePath's parent up to dir
+func parentDirs(dir string, filePath string) ([]os.FileInfo, error) {
+	var parents []os.FileInfo
+	for parent := filepath.Dir(filePath); ; parent = filepath.Dir(parent) {
+		info, err := os.Stat(parent)
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, info)
+		if parent == filepath.Clean(dir) || parent == filepath.Dir(parent) {
+			return parents, nil
+		}
+	}
+}
+
+// fileContentMD5 returns the base64 encoded MD5 of the file at localPath,
+// which is the Content-MD5 of its upload
+func fileContentMD5(localPath string) (string, error) {
+	etag, err := FileETag(localPath)
+	if err != nil {
+		return "", err
+	}
+	sum, err := hex.DecodeString(etag)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sum), nil
+}
+
+// uploadPartSize returns the part size s3manager uploads a file of size
+// bytes in when configured with partSize, it raises the part size of files
+// that would otherwise need more than s3manager.MaxUploadParts parts
+func uploadPartSize(size int64, partSize int64) int64 {
+	if size/partSize >= s3manager.MaxUploadParts {
+		return size/s3manager.MaxUploadParts + 1
+	}
+	return partSize
+}
+
+// verifyUpload checks the object uploaded from localPath in parts of
+// partSize bytes has the ETag of the file, the parts were each sent with
+// their MD5 by the SDK but the file may have changed while it was uploaded.
+// The ETags of encrypted objects other than with AES256 aren't MD5s and
+// aren't checked.
+func (w *S3Wrapper) verifyUpload(localPath string, bucket string, key string, partSize int64, opts PutOptions) error {
+	if opts.Encryption.Algorithm == s3.ServerSideEncryptionAwsKms || w.sseCustomerKey != nil {
+		return nil
+	}
+	uri := FormatS3Uri(bucket, key)
+	head, err := w.headObject(bucket, key)
+	if err != nil {
+		return fmt.Errorf("unable to verify the upload of %s to %s: %s", localPath, uri, err)
+	}
+	etag := aws.StringValue(head.ETag)
+	matches, err := MatchesETag(localPath, etag, partSize)
+	if err != nil {
+		return fmt.Errorf("unable to verify the upload of %s to %s: %s", localPath, uri, err)
+	}
+	if !matches {
+		return fmt.Errorf("upload of %s to %s doesn't match the file, its ETag is %s", localPath, uri, etag)
+	}
+	return nil
+}
+
+// contentTypeFor returns the content type of the file at localPath, this is
+// the override of opts if any, then the type opts maps its extension to, then
+// the type registered for its extension and finally application/octet-stream
+func contentTypeFor(localPath string, opts PutOptions) string {
+	if opts.ContentType != "" {
+		return opts.ContentType
+	}
+	ext := strings.ToLower(filepath.Ext(localPath))
+	if ext == "" {
+		return defaultContentType
+	}
+	if contentType, ok := opts.ContentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return defaultContentType
+}
@@ -0,0 +1,130 @@
+package s3wrapper
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// corruptingS3 returns a client of a stub S3 storing the bodies of uploads
+// in objects, with their first byte flipped when corrupt is set as if they
+// were corrupted in transit. Like S3 it rejects the uploads whose body
+// doesn't have the MD5 they were sent with.
+func corruptingS3(t *testing.T, corrupt bool, objects map[string][]byte) *S3Wrapper {
+	var mu sync.Mutex
+	return New(stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeStubError(w, http.StatusNotImplemented, "NotImplemented")
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeStubError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if corrupt && len(body) > 0 {
+			body[0] ^= 0xff
+		}
+		sum := md5.Sum(body)
+		if want := r.Header.Get("Content-MD5"); want != "" && want != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeStubError(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		mu.Lock()
+		objects[strings.TrimPrefix(r.URL.Path, "/")] = body
+		mu.Unlock()
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	})), 1)
+}
+
+func TestPutContentMD5(t *testing.T) {
+	tests := []struct {
+		name       string
+		corrupt    bool
+		wantStored string
+		wantErr    string
+	}{
+		{"intact", false, "0123456789", ""},
+		{"corrupted", true, "", "BadDigest"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			localPath := filepath.Join(t.TempDir(), "a.txt")
+			if err := ioutil.WriteFile(localPath, []byte("0123456789"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			objects := make(map[string][]byte)
+			w := corruptingS3(t, test.corrupt, objects)
+			for range w.PutAll([]string{localPath}, "s3://bk/a.txt", "/", false, PutOptions{ContentMD5: true}) {
+			}
+			err := w.Err()
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("got error %v, want %s", err, test.wantErr)
+			}
+			stored, ok := objects["bk/a.txt"]
+			if test.wantStored == "" && ok {
+				t.Errorf("stored %q, want the upload rejected", stored)
+			}
+			if test.wantStored != "" && string(stored) != test.wantStored {
+				t.Errorf("stored %q, want %q", stored, test.wantStored)
+			}
+		})
+	}
+}
+
+func TestDedupPrefix(t *testing.T) {
+	file := writeTemp(t, []byte("a"))
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		localPaths []string
+		destPrefix string
+		delimiter  string
+		want       string
+	}{
+		{"file", []string{file}, "backup/a.txt", "/", "backup/"},
+		{"file with multi character delimiter", []string{file}, "backup::a.txt", "::", "backup::"},
+		{"file at the root", []string{file}, "a.txt", "::", ""},
+		{"directory", []string{dir}, "backup", "::", "backup::"},
+		{"trailing delimiter", []string{file}, "backup::", "::", "backup::"},
+	}
+	for _, test := range tests {
+		if got := dedupPrefix(test.localPaths, test.destPrefix, test.delimiter); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUploadPartSize(t *testing.T) {
+	const partSize = s3manager.DefaultUploadPartSize
+	tests := []struct {
+		name string
+		size int64
+		want int64
+	}{
+		{"single part", 1, partSize},
+		{"fewer parts than the maximum", partSize * (s3manager.MaxUploadParts - 1), partSize},
+		{"the maximum number of parts", partSize * s3manager.MaxUploadParts, partSize + 1},
+		{"more parts than the maximum", partSize*s3manager.MaxUploadParts*2 + 1, partSize*2 + 1},
+	}
+	for _, test := range tests {
+		got := uploadPartSize(test.size, partSize)
+		if got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+		if parts := (test.size + got - 1) / got; parts > s3manager.MaxUploadParts {
+			t.Errorf("%s: %d parts of %d bytes, more than %d", test.name, parts, got, s3manager.MaxUploadParts)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package s3wrapper
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// retryBaseDelay is the ceiling of the delay before the first retry, it
+// doubles with every following retry
+const retryBaseDelay = 100 * time.Millisecond
+
+// DefaultMaxBackoff is the default cap on the delay between two retries
+const DefaultMaxBackoff = 20 * time.Second
+
+// jitter picks random delays for the retries, it is safe for concurrent use
+type jitter struct {
+	sync.Mutex
+	rand *rand.Rand
+}
+
+// delay returns a random delay between 0 and ceiling
+func (j *jitter) delay(ceiling time.Duration) time.Duration {
+	if ceiling <= 0 {
+		return 0
+	}
+	j.Lock()
+	defer j.Unlock()
+	return time.Duration(j.rand.Int63n(int64(ceiling)))
+}
+
+// WithRetries makes the wrapper retry its calls up to maxRetries times when
+// they fail with a retryable error such as throttling. Reads (ListObjectsV2,
+// GetObject, HeadObject, GetObjectTagging and GetObjectAcl) are always
+// idempotent and retried. Writes (CopyObject, DeleteObjects,
+// PutObjectTagging and PutObjectAcl) are idempotent too, since repeating one
+// leaves the object in the same state, but are only retried when
+// retryWrites is set.
+func (w *S3Wrapper) WithRetries(maxRetries int, retryWrites bool) *S3Wrapper {
+	w.maxRetries = maxRetries
+	w.retryWrites = retryWrites
+	return w
+}
+
+// WithMaxBackoff caps the delay between two retries to maxBackoff
+func (w *S3Wrapper) WithMaxBackoff(maxBackoff time.Duration) *S3Wrapper {
+	w.maxBackoff = maxBackoff
+	return w
+}
+
+// WithRandSource makes the retries pick their delays from src instead of a
+// time seeded source, which makes them deterministic
+func (w *S3Wrapper) WithRandSource(src rand.Source) *S3Wrapper {
+	w.jitter = &jitter{rand: rand.New(src)}
+	return w
+}
+
+// backoff returns the delay before the given retry, using full jitter so
+// goroutines throttled at the same time don't all retry in lockstep
+func (w *S3Wrapper) backoff(attempt int) time.Duration {
+	ceiling := w.maxBackoff
+	// stop shifting once the ceiling is reached so it can't overflow
+	if attempt < 32 && retryBaseDelay<<uint(attempt) < ceiling {
+		ceiling = retryBaseDelay << uint(attempt)
+	}
+	return w.jitter.delay(ceiling)
+}
+
+// retry calls fn until it succeeds, fails with an error which isn't
+// retryable or the retries run out, calls which aren't idempotent are never
+// retried
+func (w *S3Wrapper) retry(idempotent bool, fn func() error) error {
+	err := fn()
+	w.record(err)
+	if !idempotent {
+		return err
+	}
+	for attempt := 0; err != nil && attempt < w.maxRetries; attempt++ {
+		if !request.IsErrorRetryable(err) && !isThrottle(err) && !isServerError(err) {
+			return err
+		}
+		select {
+		case <-time.After(w.backoff(attempt)):
+		case <-w.ctx.Done():
+			return err
+		}
+		err = fn()
+		w.record(err)
+	}
+	return err
+}
+
+// isThrottle tells whether err is S3 asking to slow down, which the SDK
+// doesn't count as throttling since S3 uses its own SlowDown code
+func isThrottle(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	}
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		return aerr.Code() == "SlowDown" || aerr.StatusCode() == http.StatusServiceUnavailable || aerr.StatusCode() == http.StatusTooManyRequests
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == "SlowDown"
+	}
+	return false
+}
+
+// isServerError tells whether err is a 5xx, which S3 answers when it failed
+// to serve a call that may succeed when made again
+func isServerError(err error) bool {
+	aerr, ok := err.(awserr.RequestFailure)
+	return ok && aerr.StatusCode() >= http.StatusInternalServerError
+}
+
+// retryWrite is retry for calls which write to S3, they are only retried
+// when the wrapper was configured to retry writes
+func (w *S3Wrapper) retryWrite(fn func() error) error {
+	return w.retry(w.retryWrites, fn)
+}
@@ -0,0 +1,212 @@
+package s3wrapper
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	w := New(nil, 1).WithRandSource(rand.NewSource(1))
+	for attempt := 0; attempt < 8; attempt++ {
+		ceiling := retryBaseDelay << uint(attempt)
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			delay := w.backoff(attempt)
+			if delay < 0 || delay >= ceiling {
+				t.Fatalf("retry %d waited %s, want between 0 and %s", attempt, delay, ceiling)
+			}
+			if delay > longest {
+				longest = delay
+			}
+		}
+		// the jitter spreads the delays up to the ceiling, which doubles
+		// with every retry
+		if longest < ceiling/2 {
+			t.Errorf("retry %d waited at most %s, want close to %s", attempt, longest, ceiling)
+		}
+	}
+}
+
+func TestBackoffMaxBackoff(t *testing.T) {
+	maxBackoff := 300 * time.Millisecond
+	w := New(nil, 1).WithMaxBackoff(maxBackoff).WithRandSource(rand.NewSource(1))
+	// the ceiling would be 400ms from the third retry, and overflow past
+	// the 63rd without the cap
+	for _, attempt := range []int{2, 3, 10, 31, 32, 63, 64, 1000} {
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			delay := w.backoff(attempt)
+			if delay < 0 || delay >= maxBackoff {
+				t.Fatalf("retry %d waited %s, want between 0 and %s", attempt, delay, maxBackoff)
+			}
+			if delay > longest {
+				longest = delay
+			}
+		}
+		if longest < maxBackoff/2 {
+			t.Errorf("retry %d waited at most %s, want close to %s", attempt, longest, maxBackoff)
+		}
+	}
+
+	if delay := New(nil, 1).WithMaxBackoff(0).backoff(3); delay != 0 {
+		t.Errorf("got %s with no backoff, want 0", delay)
+	}
+}
+
+func TestBackoffRandSource(t *testing.T) {
+	a := New(nil, 1).WithRandSource(rand.NewSource(42))
+	b := New(nil, 1).WithRandSource(rand.NewSource(42))
+	for attempt := 0; attempt < 10; attempt++ {
+		if delayA, delayB := a.backoff(attempt), b.backoff(attempt); delayA != delayB {
+			t.Errorf("retry %d: got %s and %s from the same source", attempt, delayA, delayB)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	throttled := awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), http.StatusServiceUnavailable, "")
+	denied := awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), http.StatusForbidden, "")
+	badGateway := awserr.NewRequestFailure(awserr.New("BadGateway", "bad gateway", nil), http.StatusBadGateway, "")
+	internal := awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), http.StatusInternalServerError, "")
+	tests := []struct {
+		name       string
+		err        error
+		idempotent bool
+		wantCalls  int
+	}{
+		{"throttled", throttled, true, 4},
+		{"throttled write not retried", throttled, false, 1},
+		{"bad gateway", badGateway, true, 4},
+		{"internal error", internal, true, 4},
+		{"internal error write not retried", internal, false, 1},
+		{"not retryable", denied, true, 1},
+		{"other error", errors.New("boom"), true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := New(nil, 1).WithRetries(3, true).WithMaxBackoff(time.Millisecond).WithRandSource(rand.NewSource(1))
+			calls := 0
+			err := w.retry(test.idempotent, func() error {
+				calls++
+				return test.err
+			})
+			if err != test.err {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+
+	w := New(nil, 1).WithRetries(3, true).WithMaxBackoff(time.Millisecond)
+	calls := 0
+	err := w.retry(true, func() error {
+		calls++
+		if calls < 3 {
+			return throttled
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("got error %v after %d calls, want success after 3", err, calls)
+	}
+}
+
+// throttlingS3 returns a client of a stub S3 throttling the first throttled
+// calls, it then serves hello to GETs and deletes whatever is asked, calls
+// counts every call received
+func throttlingS3(t *testing.T, throttled int, calls *int32) *s3.S3 {
+	return stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(calls, 1)) <= throttled {
+			writeStubError(w, http.StatusServiceUnavailable, "SlowDown")
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, "<DeleteResult><Deleted><Key>a.txt</Key></Deleted></DeleteResult>")
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+}
+
+func TestThrottledCalls(t *testing.T) {
+	tests := []struct {
+		name        string
+		retryWrites bool
+		run         func(t *testing.T, w *S3Wrapper)
+		wantCalls   int32
+		wantErr     bool
+	}{
+		{"get", false, func(t *testing.T, w *S3Wrapper) {
+			reader, err := w.GetReader("bk", "a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			if body, err := ioutil.ReadAll(reader); err != nil || string(body) != "hello" {
+				t.Errorf("got %q, %v, want hello", body, err)
+			}
+		}, 3, false},
+		{"delete", true, func(t *testing.T, w *S3Wrapper) {
+			drain(t, w.DeleteObjects(keysOf("bk", "a.txt")))
+		}, 3, false},
+		{"delete with --no-retry-writes", false, func(t *testing.T, w *S3Wrapper) {
+			drain(t, w.DeleteObjects(keysOf("bk", "a.txt")))
+		}, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			w := New(throttlingS3(t, 2, &calls), 1).
+				WithRetries(3, test.retryWrites).
+				WithMaxBackoff(time.Millisecond).
+				WithLogger(discardLogger{})
+			test.run(t, w)
+			if got := atomic.LoadInt32(&calls); got != test.wantCalls {
+				t.Errorf("got %d calls, want %d", got, test.wantCalls)
+			}
+			if err := w.Err(); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerErrorsRetried(t *testing.T) {
+	var calls int32
+	svc := stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1)%2 == 1 {
+			writeStubError(w, http.StatusBadGateway, "BadGateway")
+			return
+		}
+		if r.Method == http.MethodHead {
+			w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, "<ListAllMyBucketsResult><Buckets><Bucket><Name>bk</Name></Bucket></Buckets></ListAllMyBucketsResult>")
+	}))
+	w := New(svc, 1).WithRetries(3, true).WithMaxBackoff(time.Millisecond)
+	buckets, err := w.ListBuckets("s3://")
+	if err != nil || len(buckets) != 1 || buckets[0] != "bk" {
+		t.Errorf("got %v, %v, want [bk]", buckets, err)
+	}
+	region, err := w.bucketRegion("retried-region")
+	if err != nil || region != "eu-west-1" {
+		t.Errorf("got %q, %v, want eu-west-1", region, err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 4 {
+		t.Errorf("got %d calls, want 4", got)
+	}
+}
@@ -2,23 +2,47 @@ package s3wrapper
 
 import (
 	"bufio"
+	"compress/bzip2"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CollisionOverwrite lets the last key copied to a destination win
+	CollisionOverwrite = "overwrite"
+	// CollisionSkip keeps the first key copied to a destination
+	CollisionSkip = "skip"
+	// CollisionRename copies the later keys to a destination with a numeric
+	// suffix
+	CollisionRename = "rename"
+	// CollisionError stops copying on the first collision
+	CollisionError = "error"
 )
 
 // ListOutput represents the pruned and
@@ -32,6 +56,21 @@ type ListOutput struct {
 	LastModified time.Time
 	Bucket       string
 	FullKey      string
+	// ETag is set for listed objects, without its quotes
+	ETag string
+	// SHA256 is the hex SHA-256 of the file GetAll downloaded, when asked to
+	// hash files
+	SHA256 string
+	// OwnerID and OwnerName are only set by wrappers fetching owners
+	OwnerID   string
+	OwnerName string
+	// VersionID is only set for the delete markers of ListDeleteMarkers,
+	// DeleteObjects deletes that version instead of the current one
+	VersionID string
+	// ChecksumAlgorithm and Checksum are the additional checksum CopyAll
+	// had S3 compute for a copy, base64 encoded
+	ChecksumAlgorithm string
+	Checksum          string
 }
 
 // S3Wrapper is a wrapper for the S3
@@ -40,10 +79,84 @@ type ListOutput struct {
 type S3Wrapper struct {
 	concurrencySemaphore chan struct{}
 	svc                  *s3.S3
+	requestPayer         *string
+	ctx                  context.Context
+	errs                 *Errors
+	logger               Logger
+	dryRun               bool
+	maxRetries           int
+	retryWrites          bool
+	maxBackoff           time.Duration
+	jitter               *jitter
+	checksumMode         bool
+	fetchOwner           bool
+	sseCustomerKey       *sseCustomerKey
+	failures             *Failures
+	adaptive             *adaptiveConcurrency
+	regions              *regionCache
+	// prefetch is the number of listed keys buffered ahead of their
+	// consumer
+	prefetch int
+	// maxListPages is the number of pages after which a listing is aborted,
+	// 0 means no limit
+	maxListPages int
+}
+
+// Logger is used by the wrapper for its diagnostics, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger is the default Logger which writes to the standard logger
+type stdLogger struct{}
+
+// Printf writes to the standard logger, reporting the caller of Printf
+// as the source of the message
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+}
+
+// Errors collects the errors encountered by the wrapper's operations, most
+// of which run in background goroutines and can't return them directly
+type Errors struct {
+	sync.Mutex
+	errs []error
+}
+
+// add records err
+func (e *Errors) add(err error) {
+	e.Lock()
+	defer e.Unlock()
+	e.errs = append(e.errs, err)
 }
 
-// parseS3Uri parses a s3 uri into its bucket and prefix
-func parseS3Uri(s3Uri string) (bucket string, prefix string) {
+// Len returns the number of errors recorded so far
+func (e *Errors) Len() int {
+	e.Lock()
+	defer e.Unlock()
+	return len(e.errs)
+}
+
+// Err returns the errors recorded so far as a single error, or nil if there
+// were none
+func (e *Errors) Err() error {
+	e.Lock()
+	defer e.Unlock()
+	switch len(e.errs) {
+	case 0:
+		return nil
+	case 1:
+		return e.errs[0]
+	}
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("%d errors occurred: %s", len(e.errs), strings.Join(msgs, "; "))
+}
+
+// ParseS3Uri parses a s3 uri into its bucket and prefix
+func ParseS3Uri(s3Uri string) (bucket string, prefix string) {
 	s3UriParts := strings.Split(s3Uri, "/")
 	prefix = strings.Join(s3UriParts[3:], "/")
 	bucket = s3UriParts[2]
@@ -61,38 +174,250 @@ func New(svc *s3.S3, maxParallel int) *S3Wrapper {
 	return &S3Wrapper{
 		svc:                  svc,
 		concurrencySemaphore: make(chan struct{}, maxParallel),
+		ctx:                  context.Background(),
+		errs:                 &Errors{},
+		logger:               stdLogger{},
+		retryWrites:          true,
+		maxBackoff:           DefaultMaxBackoff,
+		jitter:               &jitter{rand: rand.New(rand.NewSource(time.Now().UnixNano()))},
+		prefetch:             DefaultPrefetch,
+		regions:              &regionCache{},
 	}
 }
 
+// WithRegionFrom autodetects the region of the bucket in uri and points the
+// wrapper at it, this is a no-op when a custom endpoint is configured since
+// S3 compatible stores don't reliably support region lookups
 func (w *S3Wrapper) WithRegionFrom(uri string) (*S3Wrapper, error) {
-	bucket, _ := parseS3Uri(uri)
-	region, err := s3manager.GetBucketRegionWithClient(context.Background(), w.svc, bucket)
+	if aws.StringValue(w.svc.Client.Config.Endpoint) != "" {
+		return w, nil
+	}
+	bucket, _ := ParseS3Uri(uri)
+	region, err := w.bucketRegion(bucket)
 	if err != nil {
-		log.Printf("WARN: unable to autodetect region, falling back to default. Cause: '%s'\n", err)
+		w.regions.warned.Store(bucket, true)
+		w.logger.Printf("WARN: unable to autodetect region, falling back to default. Cause: '%s'\n", err)
 		return w, nil
 	}
-	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	svc, err := w.regionClient(region)
 	if err != nil {
 		return nil, err
 	}
-	w.svc = s3.New(sess, aws.NewConfig().WithRegion(region))
+	w.svc = svc
 	return w, nil
 }
 
+// regionCache holds the regions of the buckets known to a wrapper and its
+// copies, either looked up or given with WithBucketRegion
+type regionCache struct {
+	regions sync.Map
+	// warned holds the buckets whose region couldn't be looked up, which
+	// are only warned about once
+	warned sync.Map
+}
+
+// WithBucketRegion makes the requests about bucket go to region without
+// looking its region up, which needs the s3:GetBucketLocation permission
+func (w *S3Wrapper) WithBucketRegion(bucket string, region string) *S3Wrapper {
+	w.regions.regions.Store(bucket, region)
+	return w
+}
+
+// bucketRegion returns the region of bucket, looking it up until a lookup
+// succeeds
+func (w *S3Wrapper) bucketRegion(bucket string) (string, error) {
+	if region, ok := w.regions.regions.Load(bucket); ok {
+		return region.(string), nil
+	}
+	var region string
+	err := w.retry(true, func() error {
+		var err error
+		region, err = s3manager.GetBucketRegionWithClient(context.Background(), w.svc, bucket)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	w.regions.regions.Store(bucket, region)
+	return region, nil
+}
+
+// regionClientKey identifies a client derived from another one for a region
+type regionClientKey struct {
+	base   *s3.S3
+	region string
+}
+
+// regionClients caches the clients created by regionClient, so that the
+// requests about buckets of the same region share their connections
+var regionClients sync.Map
+
+// regionClient returns a client with the config of the wrapper's client
+// pointed at region
+func (w *S3Wrapper) regionClient(region string) (*s3.S3, error) {
+	if aws.StringValue(w.svc.Client.Config.Region) == region {
+		return w.svc, nil
+	}
+	key := regionClientKey{base: w.svc, region: region}
+	if svc, ok := regionClients.Load(key); ok {
+		return svc.(*s3.S3), nil
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	// keep the rest of the client's config (e.g. its credentials) and only
+	// swap out the region
+	svc, _ := regionClients.LoadOrStore(key, s3.New(sess, w.svc.Client.Config.Copy(aws.NewConfig().WithRegion(region))))
+	return svc.(*s3.S3), nil
+}
+
+// forBucket returns a copy of the wrapper, sharing its concurrency, errors
+// and options, whose client is pointed at the region of bucket. The wrapper
+// itself is returned when a custom endpoint is configured or the region
+// can't be detected, it is then looked up again the next time.
+func (w *S3Wrapper) forBucket(bucket string) *S3Wrapper {
+	if aws.StringValue(w.svc.Client.Config.Endpoint) != "" {
+		return w
+	}
+	region, err := w.bucketRegion(bucket)
+	if err != nil {
+		if _, warned := w.regions.warned.LoadOrStore(bucket, true); !warned {
+			w.logger.Printf("WARN: unable to autodetect the region of %s, falling back to default. Cause: '%s'\n", bucket, err)
+		}
+		return w
+	}
+	svc, err := w.regionClient(region)
+	if err != nil || svc == w.svc {
+		return w
+	}
+	bucketWrap := *w
+	bucketWrap.svc = svc
+	return &bucketWrap
+}
+
+// client returns the client to make requests about bucket with, which is
+// pointed at the region of bucket
+func (w *S3Wrapper) client(bucket string) *s3.S3 {
+	return w.forBucket(bucket).svc
+}
+
 // WithMaxConcurrency sets the maximum concurrency for the S3 operations
 func (w *S3Wrapper) WithMaxConcurrency(maxConcurrency int) *S3Wrapper {
 	w.concurrencySemaphore = make(chan struct{}, maxConcurrency)
+	w.adaptive = nil
 	return w
 }
 
+// WithRequestPayer sets the RequestPayer on the list, get, copy and delete
+// requests made by the wrapper, an empty payer leaves the requests unchanged
+func (w *S3Wrapper) WithRequestPayer(payer string) *S3Wrapper {
+	if payer == "" {
+		w.requestPayer = nil
+	} else {
+		w.requestPayer = aws.String(payer)
+	}
+	return w
+}
+
+// WithContext sets a context which stops the wrapper from starting any new
+// work once it is done, operations already in flight are left to finish and
+// their results are still sent on the output channels
+func (w *S3Wrapper) WithContext(ctx context.Context) *S3Wrapper {
+	w.ctx = ctx
+	return w
+}
+
+// WithDryRun makes the copy and delete operations skip their mutating
+// requests while still sending the keys they would have changed on their
+// output channels
+func (w *S3Wrapper) WithDryRun(dryRun bool) *S3Wrapper {
+	w.dryRun = dryRun
+	return w
+}
+
+// DefaultPrefetch is the number of listed keys buffered ahead of their
+// consumer by default
+const DefaultPrefetch = 10000
+
+// WithPrefetch makes listings buffer up to prefetch keys ahead of their
+// consumer, so listing keeps going while the keys already listed are
+// transferred
+func (w *S3Wrapper) WithPrefetch(prefetch int) *S3Wrapper {
+	if prefetch < 0 {
+		prefetch = 0
+	}
+	w.prefetch = prefetch
+	return w
+}
+
+// Prefetch returns the number of listed keys buffered ahead of their
+// consumer
+func (w *S3Wrapper) Prefetch() int {
+	return w.prefetch
+}
+
+// WithMaxListPages makes every List abort with an error once it has listed
+// maxListPages pages of keys rather than list all of them, 0 means no limit
+func (w *S3Wrapper) WithMaxListPages(maxListPages int) *S3Wrapper {
+	w.maxListPages = maxListPages
+	return w
+}
+
+// WithFetchOwner makes listings and Head fill in the owner of objects, this
+// makes every listing request slower so it's off by default
+func (w *S3Wrapper) WithFetchOwner(fetchOwner bool) *S3Wrapper {
+	w.fetchOwner = fetchOwner
+	return w
+}
+
+// WithLogger makes the wrapper write its diagnostics to logger
+func (w *S3Wrapper) WithLogger(logger Logger) *S3Wrapper {
+	w.logger = logger
+	return w
+}
+
+// WithErrors makes the wrapper record its errors in errs, this allows several
+// wrappers working on the same pipeline to share their errors
+func (w *S3Wrapper) WithErrors(errs *Errors) *S3Wrapper {
+	w.errs = errs
+	return w
+}
+
+// Err returns the errors encountered by the wrapper's operations, it should
+// be checked once their output channels are closed
+func (w *S3Wrapper) Err() error {
+	return w.errs.Err()
+}
+
+// recoverPanic is a safety net deferred in every goroutine the wrapper starts
+// so a panic is recorded as an error rather than killing the process
+func (w *S3Wrapper) recoverPanic() {
+	if r := recover(); r != nil {
+		w.errs.add(fmt.Errorf("recovered from panic: %v", r))
+	}
+}
+
+// draining tells whether the wrapper's context is done and no new work
+// should be started
+func (w *S3Wrapper) draining() bool {
+	select {
+	case <-w.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
 // ListAll is a convienience function for listing and collating all the results for multiple S3 URIs
 func (w *S3Wrapper) ListAll(s3Uris []string, recursive bool, delimiter string, keyRegex string) chan *ListOutput {
-	ch := make(chan *ListOutput, 10000)
+	ch := make(chan *ListOutput, w.prefetch)
 	var wg sync.WaitGroup
 	for _, s3Uri := range s3Uris {
 		wg.Add(1)
 		go func(s3Uri string) {
 			defer wg.Done()
+			defer w.recoverPanic()
 			for itm := range w.List(s3Uri, recursive, delimiter, keyRegex) {
 				ch <- itm
 			}
@@ -108,31 +433,57 @@ func (w *S3Wrapper) ListAll(s3Uris []string, recursive bool, delimiter string, k
 
 // List is a wrapping function to parallelize listings and normalize the results from the API
 func (w *S3Wrapper) List(s3Uri string, recursive bool, delimiter string, keyRegex string) chan *ListOutput {
-	bucket, prefix := parseS3Uri(s3Uri)
+	bucket, prefix := ParseS3Uri(s3Uri)
 	if recursive {
 		delimiter = ""
 	}
 	var keyRegexFilter *regexp.Regexp
 	if keyRegex != "" {
-		keyRegexFilter = regexp.MustCompile(keyRegex)
+		var err error
+		keyRegexFilter, err = regexp.Compile(keyRegex)
+		if err != nil {
+			w.errs.add(err)
+			ch := make(chan *ListOutput)
+			close(ch)
+			return ch
+		}
 	}
 
 	params := &s3.ListObjectsV2Input{
 		Bucket:       aws.String(bucket), // Required
 		Delimiter:    aws.String(delimiter),
 		EncodingType: aws.String(s3.EncodingTypeUrl),
-		FetchOwner:   aws.Bool(false),
+		FetchOwner:   aws.Bool(w.fetchOwner),
 		MaxKeys:      aws.Int64(1000),
 		Prefix:       aws.String(prefix),
+		RequestPayer: w.requestPayer,
 	}
 
-	ch := make(chan *ListOutput, 10000)
+	ch := make(chan *ListOutput, w.prefetch)
 	go func() {
 		defer close(ch)
-		w.concurrencySemaphore <- struct{}{}
-		defer func() { <-w.concurrencySemaphore }()
+		defer w.recoverPanic()
+		w.acquire()
+		defer w.release()
+		if w.draining() {
+			return
+		}
+
+		svc := w.client(bucket)
+		// pages are requested one at a time so a failed page can be retried
+		// without listing the previous ones again
+		for pages := 1; ; pages++ {
+			var page *s3.ListObjectsV2Output
+			err := w.retry(true, func() error {
+				var err error
+				page, err = svc.ListObjectsV2(params)
+				return err
+			})
+			if err != nil {
+				w.errs.add(fmt.Errorf("unable to list %s: %s", s3Uri, err))
+				return
+			}
 
-		err := w.svc.ListObjectsV2Pages(params, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
 			for _, prefix := range page.CommonPrefixes {
 				if *prefix.Prefix != delimiter {
 					escapedPrefix, err := url.QueryUnescape(*prefix.Prefix)
@@ -160,143 +511,457 @@ func (w *S3Wrapper) List(s3Uri string, recursive bool, delimiter string, keyRege
 				if keyRegexFilter != nil && !keyRegexFilter.MatchString(formattedKey) {
 					continue
 				}
-				ch <- &ListOutput{
+				obj := &ListOutput{
 					IsPrefix:     false,
 					Key:          escapedKey,
 					FullKey:      formattedKey,
 					LastModified: *key.LastModified,
 					Size:         *key.Size,
 					Bucket:       bucket,
+					ETag:         strings.Trim(aws.StringValue(key.ETag), `"`),
 				}
+				if key.Owner != nil {
+					obj.OwnerID = aws.StringValue(key.Owner.ID)
+					obj.OwnerName = aws.StringValue(key.Owner.DisplayName)
+				}
+				ch <- obj
 			}
-			return true
-		})
-		if err != nil {
-			panic(err)
+			if !aws.BoolValue(page.IsTruncated) || w.draining() {
+				return
+			}
+			if w.maxListPages > 0 && pages >= w.maxListPages {
+				hint := ""
+				if delimiter == "" {
+					hint = ", list it without --recursive"
+				}
+				w.errs.add(fmt.Errorf("listing of %s stopped after %d pages, use a more specific prefix%s, or raise --max-list-pages", s3Uri, pages, hint))
+				return
+			}
+			params.ContinuationToken = page.NextContinuationToken
 		}
 	}()
 
 	return ch
 }
 
-// GetReader retrieves an appropriate reader for the given bucket and key
+// Head retrieves the metadata of the object at s3Uri, a nil ListOutput is
+// returned when no object exists with exactly that key
+func (w *S3Wrapper) Head(s3Uri string) (*ListOutput, error) {
+	bucket, key := ParseS3Uri(s3Uri)
+	if key == "" {
+		return nil, nil
+	}
+
+	w.acquire()
+	defer w.release()
+
+	resp, err := w.headObject(bucket, key)
+	// a uri we may not HEAD may still be listed as a prefix, which fails
+	// on its own if it may not be listed either
+	if isNotFound(err) || isForbidden(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &ListOutput{
+		IsPrefix:     false,
+		Key:          key,
+		FullKey:      FormatS3Uri(bucket, key),
+		LastModified: aws.TimeValue(resp.LastModified),
+		Size:         aws.Int64Value(resp.ContentLength),
+		Bucket:       bucket,
+		ETag:         strings.Trim(aws.StringValue(resp.ETag), `"`),
+	}
+	if w.fetchOwner {
+		// HEAD responses don't include the owner, listing the key as a
+		// prefix returns it first since no other key sorts before it
+		var page *s3.ListObjectsV2Output
+		err := w.retry(true, func() error {
+			var err error
+			page, err = w.client(bucket).ListObjectsV2(&s3.ListObjectsV2Input{
+				Bucket:       aws.String(bucket),
+				FetchOwner:   aws.Bool(true),
+				MaxKeys:      aws.Int64(1),
+				Prefix:       aws.String(key),
+				RequestPayer: w.requestPayer,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Contents) > 0 && aws.StringValue(page.Contents[0].Key) == key && page.Contents[0].Owner != nil {
+			obj.OwnerID = aws.StringValue(page.Contents[0].Owner.ID)
+			obj.OwnerName = aws.StringValue(page.Contents[0].Owner.DisplayName)
+		}
+	}
+	return obj, nil
+}
+
+// exists tells whether an object exists with exactly the given bucket and key
+func (w *S3Wrapper) exists(bucket string, key string) (bool, error) {
+	_, err := w.headObject(bucket, key)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isNotFound tells whether err is the one of a HEAD request of a key which
+// doesn't exist. HEAD responses have no body so a missing key only surfaces
+// as a status code. A key we can't see without list permissions is denied
+// rather than not found, since it may exist.
+func isNotFound(err error) bool {
+	aerr, ok := err.(awserr.RequestFailure)
+	return ok && aerr.StatusCode() == http.StatusNotFound
+}
+
+// isForbidden tells whether err is the one of a request we aren't allowed
+// to make
+func isForbidden(err error) bool {
+	aerr, ok := err.(awserr.RequestFailure)
+	return ok && aerr.StatusCode() == http.StatusForbidden
+}
+
+// headObject makes the HEAD request of the given bucket and key
+func (w *S3Wrapper) headObject(bucket string, key string) (*s3.HeadObjectOutput, error) {
+	var resp *s3.HeadObjectOutput
+	err := w.retry(true, func() error {
+		var err error
+		params := &s3.HeadObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			RequestPayer: w.requestPayer,
+		}
+		w.sseCustomerKey.applyToHead(params)
+		resp, err = w.client(bucket).HeadObject(params)
+		return err
+	})
+	return resp, err
+}
+
+// GetReader retrieves an appropriate reader for the given bucket and key, in
+// checksum mode reading it fails with a ChecksumError at the end of a body
+// which doesn't match its checksum
 func (w *S3Wrapper) GetReader(bucket string, key string) (io.ReadCloser, error) {
 	params := &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: w.requestPayer,
 	}
-	resp, err := w.svc.GetObject(params)
+	w.sseCustomerKey.applyToGet(params)
+	var resp *s3.GetObjectOutput
+	var header http.Header
+	err := w.retry(true, func() error {
+		req, out := w.client(bucket).GetObjectRequest(params)
+		if w.checksumMode {
+			// the SDK predates additional checksums, so the header is set
+			// by hand
+			req.HTTPRequest.Header.Set("X-Amz-Checksum-Mode", "ENABLED")
+		}
+		if err := req.Send(); err != nil {
+			return err
+		}
+		resp, header = out, req.HTTPResponse.Header
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	if w.checksumMode {
+		return newChecksumReader(resp.Body, header), nil
+	}
 	return resp.Body, nil
 }
 
+// StreamOptions are the options of Stream and StreamOrdered
+type StreamOptions struct {
+	// IncludeKeyName prefixes each line, or chunk when Raw, with its key
+	IncludeKeyName bool
+	// Raw streams the bytes of the keys as is instead of their decompressed
+	// lines
+	Raw bool
+	// LineNumbers prefixes each line with its number within its key
+	LineNumbers bool
+	// JSONL turns each line into a JSON object holding the line and its key,
+	// along with its number when LineNumbers is set, it is ignored when Raw
+	JSONL bool
+	// Stats counts the lines, words and bytes of every key instead of
+	// streaming it and streams one line of counts per key like wc, followed
+	// by their total when there are several keys
+	Stats bool
+	// Tar reads every key as a tar archive, decompressed first when it is a
+	// .tar.gz or .tgz, and streams the lines of its files
+	Tar bool
+	// TarList streams the names of the members of the tar archives instead
+	// of their content
+	TarList bool
+	// Separator is sent between the data of consecutive keys, or before
+	// the data of every key when it contains {key}, by StreamOrdered
+	Separator string
+}
+
+// streamLine is the JSON representation of a line streamed with JSONL
+type streamLine struct {
+	Source string `json:"source"`
+	Number int    `json:"number,omitempty"`
+	Line   string `json:"line"`
+}
+
 // Stream provides a channel with data from the keys
-func (w *S3Wrapper) Stream(keys chan *ListOutput, includeKeyName bool, raw bool) chan string {
+func (w *S3Wrapper) Stream(keys chan *ListOutput, opts StreamOptions) chan string {
 	lines := make(chan string, 10000)
+	totals := &wcTotals{}
 	var wg sync.WaitGroup
 	go func() {
+		defer func() {
+			wg.Wait()
+			if opts.Stats {
+				totals.emit(func(line string) { lines <- line })
+			}
+			close(lines)
+		}()
+		defer w.recoverPanic()
 		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
 			wg.Add(1)
 			go func(key *ListOutput) {
 				defer wg.Done()
-				w.concurrencySemaphore <- struct{}{}
-				defer func() { <-w.concurrencySemaphore }()
-
-				reader, err := w.GetReader(key.Bucket, key.Key)
-				if err != nil {
-					panic(err)
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
 				}
-				defer reader.Close()
-				if !raw {
-					extReader, err := getReaderByExt(reader, key.Key)
-					if err != nil {
-						panic(err)
-					}
-					bufExtReader := bufio.NewReader(extReader)
 
-					for {
-						line, err := bufExtReader.ReadBytes('\n')
+				w.streamKey(key, opts, totals, func(line string) { lines <- line })
+			}(key)
+		}
+	}()
 
-						if err != nil && err.Error() != "EOF" {
-							log.Fatalln(err)
-						}
+	return lines
+}
 
-						if includeKeyName {
-							lines <- fmt.Sprintf("[%s] %s", key.FullKey, string(line))
-						} else {
-							lines <- string(line)
-						}
-						if err != nil {
-							break
-						}
-					}
-				} else {
-					buf := make([]byte, 64)
-					for {
-						numBytes, err := reader.Read(buf)
-						if err != nil && err.Error() != "EOF" {
-							log.Fatalln(err)
-						}
+// streamKey reads the content of key and passes it to emit either line by
+// line or, when raw, in chunks of its undecompressed bytes. With Stats only
+// its counts are passed to emit, once it was read, and added to totals. With
+// Tar or TarList key is read as a tar archive.
+func (w *S3Wrapper) streamKey(key *ListOutput, opts StreamOptions, totals *wcTotals, emit func(string)) {
+	reader, err := w.GetReader(key.Bucket, key.Key)
+	if err != nil {
+		w.errs.add(fmt.Errorf("unable to get %s: %s", key.FullKey, err))
+		return
+	}
+	defer reader.Close()
+	if opts.Tar || opts.TarList {
+		if err := w.streamTar(key, reader, opts, emit); err != nil {
+			w.errs.add(fmt.Errorf("unable to read the tar archive %s: %s", key.FullKey, err))
+		}
+	} else if opts.Stats {
+		content := io.Reader(reader)
+		if !opts.Raw {
+			if content, err = getReaderByExt(reader, key.Key); err != nil {
+				w.errs.add(fmt.Errorf("unable to read %s: %s", key.FullKey, err))
+				return
+			}
+		}
+		counts := &wcCounts{}
+		if _, err := io.Copy(counts, content); err != nil {
+			w.errs.add(fmt.Errorf("unable to read %s: %s", key.FullKey, err))
+			return
+		}
+		totals.add(counts)
+		emit(counts.format(key.FullKey))
+	} else if !opts.Raw {
+		extReader, err := getReaderByExt(reader, key.Key)
+		if err != nil {
+			w.errs.add(fmt.Errorf("unable to read %s: %s", key.FullKey, err))
+			return
+		}
+		bufExtReader := bufio.NewReader(extReader)
 
-						if includeKeyName {
-							lines <- fmt.Sprintf("[%s] %s", key.FullKey, string(buf[0:numBytes]))
-						} else {
-							lines <- string(buf[0:numBytes])
-						}
+		lineNumber := 0
+		for {
+			line, err := bufExtReader.ReadBytes('\n')
 
-						if err != nil {
-							break
-						}
+			if err != nil && err != io.EOF {
+				w.errs.add(fmt.Errorf("unable to read %s: %s", key.FullKey, err))
+			}
+
+			// the last read of a key ending in a newline is empty
+			if len(line) > 0 {
+				lineNumber++
+				if opts.JSONL {
+					emit(formatStreamLine(key, lineNumber, line, opts.LineNumbers))
+				} else {
+					out := string(line)
+					if opts.LineNumbers {
+						out = fmt.Sprintf("%d: %s", lineNumber, out)
+					}
+					if opts.IncludeKeyName {
+						out = fmt.Sprintf("[%s] %s", key.FullKey, out)
 					}
+					emit(out)
 				}
-			}(key)
+			}
+			if err != nil {
+				break
+			}
 		}
-		go func() {
-			wg.Wait()
-			close(lines)
-		}()
-	}()
+	} else {
+		buf := make([]byte, 64)
+		for {
+			numBytes, err := reader.Read(buf)
+			if err != nil && err != io.EOF {
+				w.errs.add(fmt.Errorf("unable to read %s: %s", key.FullKey, err))
+			}
 
-	return lines
+			if opts.IncludeKeyName {
+				emit(fmt.Sprintf("[%s] %s", key.FullKey, string(buf[0:numBytes])))
+			} else {
+				emit(string(buf[0:numBytes]))
+			}
+
+			if err != nil {
+				break
+			}
+		}
+	}
 }
 
+// formatStreamLine formats line, the lineNumber-th of key, as a line of JSON,
+// the number is only included when withNumber is set
+func formatStreamLine(key *ListOutput, lineNumber int, line []byte, withNumber bool) string {
+	out := streamLine{
+		Source: key.FullKey,
+		Line:   strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"),
+	}
+	if withNumber {
+		out.Number = lineNumber
+	}
+	// encoding a struct of strings and an int can't fail
+	encoded, _ := json.Marshal(out)
+	return string(encoded) + "\n"
+}
+
+// GetOptions are the options of GetAll
+type GetOptions struct {
+	// SkipExisting skips the keys whose local file already exists
+	SkipExisting bool
+	// Decompress decompresses compressed keys and drops their extension
+	// locally
+	Decompress bool
+	// HashFiles computes the SHA-256 of every file while it is written
+	HashFiles bool
+	// Atomic writes every file to a temporary file next to it, which is
+	// only renamed to the file once fully written, so an interrupted
+	// download never leaves a partial file behind
+	Atomic bool
+	// NoOverwrite skips the keys whose local file exists with a warning
+	// instead of overwriting it, or fails them when NoOverwriteError is set
+	NoOverwrite      bool
+	NoOverwriteError bool
+	// Progress returns the writer the bytes of k are copied to as they are
+	// downloaded, before any decompression, or nil to not track k. The
+	// writer is closed once the download is over.
+	Progress func(k *ListOutput) io.WriteCloser
+	// PathDelimiter is the delimiter of keys which separates the local
+	// directories of their files, keys are written to a single file named
+	// after the whole key when it is empty
+	PathDelimiter string
+	// ChecksumRetries is the number of times an object which doesn't match
+	// its checksum is downloaded again before it fails
+	ChecksumRetries int
+	// TrimPrefix is removed from the start of every key to get its local
+	// path, keys which don't start with it keep their full key
+	TrimPrefix string
+	// PathFor returns the local path of k when set, instead of the one
+	// TrimPrefix, Decompress and PathDelimiter give
+	PathFor func(k *ListOutput) string
+}
+
+// atomicSuffix is added to the local path of the temporary files of atomic
+// downloads
+const atomicSuffix = ".fasts3tmp"
+
 // GetAll retrieves all keys to the local filesystem, it repurposes ListOutput as it's
 // output which contains the local paths to the keys
-func (w *S3Wrapper) GetAll(keys chan *ListOutput, skipExisting bool) chan *ListOutput {
+func (w *S3Wrapper) GetAll(keys chan *ListOutput, opts GetOptions) chan *ListOutput {
 	listOut := make(chan *ListOutput, 10000)
 	var wg sync.WaitGroup
 	for key := range keys {
-		if _, err := os.Stat(key.Key); skipExisting == false || os.IsNotExist(err) {
+		if w.draining() {
+			continue
+		}
+		var localPath string
+		if opts.PathFor != nil {
+			localPath = opts.PathFor(key)
+		} else {
+			localPath = key.Key
+			if opts.TrimPrefix != "" {
+				if strings.HasPrefix(key.Key, opts.TrimPrefix) && key.Key != opts.TrimPrefix {
+					localPath = strings.TrimPrefix(key.Key, opts.TrimPrefix)
+				} else if !key.IsPrefix {
+					w.logger.Printf("%s doesn't start with %s, downloading it to its full key", key.FullKey, opts.TrimPrefix)
+				}
+			}
+			if opts.Decompress {
+				localPath = trimCompressionExt(localPath)
+			}
+			localPath = LocalPath(localPath, opts.PathDelimiter)
+		}
+		if _, err := os.Stat(localPath); !opts.SkipExisting || os.IsNotExist(err) {
 			wg.Add(1)
-			go func(k *ListOutput) {
+			go func(k *ListOutput, localPath string) {
 				defer wg.Done()
-				w.concurrencySemaphore <- struct{}{}
-				defer func() { <-w.concurrencySemaphore }()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
 
 				if !k.IsPrefix {
-					// TODO: this assumes '/' as a delimiter
-					parts := strings.Split(k.Key, "/")
-					dir := strings.Join(parts[0:len(parts)-1], "/")
-					createPathIfNotExists(dir)
-					reader, err := w.GetReader(k.Bucket, k.Key)
-					if err != nil {
-						panic(err)
+					dir := filepath.Dir(localPath)
+					if err := createPathIfNotExists(dir); err != nil {
+						w.fail(k, fmt.Errorf("unable to create %s: %s", dir, err))
+						return
 					}
-					defer reader.Close()
-					outFile, err := os.Create(k.Key)
-					if err != nil {
-						panic(err)
+					if opts.NoOverwrite {
+						if _, err := os.Lstat(localPath); err == nil {
+							if opts.NoOverwriteError {
+								w.fail(k, fmt.Errorf("unable to download %s: %s already exists", k.FullKey, localPath))
+							} else {
+								w.logger.Printf("Skipping %s, %s already exists\n", k.FullKey, localPath)
+							}
+							return
+						}
+					}
+					var sum string
+					var err error
+					for attempt := 0; ; attempt++ {
+						var mismatch bool
+						sum, mismatch, err = w.downloadFile(k, localPath, opts)
+						if err == nil || !mismatch || attempt >= opts.ChecksumRetries || w.draining() {
+							break
+						}
+						w.logger.Printf("WARN: %s, downloading it again (retry %d of %d)\n", err, attempt+1, opts.ChecksumRetries)
 					}
-					defer outFile.Close()
-					_, err = io.Copy(outFile, reader)
 					if err != nil {
-						panic(err)
+						w.fail(k, err)
+						return
+					}
+					k.Key = localPath
+					if opts.HashFiles {
+						k.SHA256 = sum
 					}
 					listOut <- k
 				}
-			}(key)
+			}(key, localPath)
 		}
 	}
 
@@ -308,53 +973,221 @@ func (w *S3Wrapper) GetAll(keys chan *ListOutput, skipExisting bool) chan *ListO
 	return listOut
 }
 
+// downloadFile writes the object k to localPath as GetAll does, returning
+// the SHA-256 of the file when opts.HashFiles is set. mismatch tells whether
+// the download failed because the object didn't match its checksum, no file
+// is left behind when it fails.
+func (w *S3Wrapper) downloadFile(k *ListOutput, localPath string, opts GetOptions) (sum string, mismatch bool, err error) {
+	reader, err := w.GetReader(k.Bucket, k.Key)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to get %s: %s", k.FullKey, err)
+	}
+	defer reader.Close()
+	if opts.Progress != nil {
+		if progress := opts.Progress(k); progress != nil {
+			defer progress.Close()
+			reader = ioutil.NopCloser(io.TeeReader(reader, progress))
+		}
+	}
+	if opts.Decompress {
+		reader, err = getReaderByExt(reader, k.Key)
+		if err != nil {
+			return "", false, fmt.Errorf("unable to decompress %s: %s", k.FullKey, err)
+		}
+		defer reader.Close()
+	}
+	// the temporary file is in the same directory so it is renamed within
+	// the same filesystem
+	writePath := localPath
+	if opts.Atomic {
+		writePath = localPath + atomicSuffix
+	}
+	outFile, err := os.Create(writePath)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to create %s: %s", writePath, err)
+	}
+	defer outFile.Close()
+	hash := sha256.New()
+	if opts.HashFiles {
+		reader = ioutil.NopCloser(io.TeeReader(reader, hash))
+	}
+	_, err = io.Copy(outFile, reader)
+	// the checksum is validated while reading, decompressing passes its
+	// errors on as is
+	_, mismatch = err.(*ChecksumError)
+	if err == nil && opts.Atomic {
+		// the data must be on disk before the file appears complete under
+		// its name
+		if err = outFile.Sync(); err == nil {
+			err = outFile.Close()
+		}
+		if err == nil {
+			err = os.Rename(writePath, localPath)
+		}
+	}
+	if err != nil {
+		// don't leave a partial or corrupt file behind
+		outFile.Close()
+		os.Remove(writePath)
+		return "", mismatch, fmt.Errorf("unable to download %s: %s", k.FullKey, err)
+	}
+	if opts.HashFiles {
+		sum = hex.EncodeToString(hash.Sum(nil))
+	}
+	return sum, false, nil
+}
+
+// CopyOptions are the options of CopyAll
+type CopyOptions struct {
+	// Flat copies every key directly under the dest, dropping its prefix
+	Flat bool
+	// Headers which are set replace those of the copies, which keep the rest
+	// of the metadata of their source
+	Headers Headers
+	// OnCollision tells what to do with a key whose destination an earlier
+	// key was already copied to when Flat is set
+	OnCollision string
+	// VerifyMetadata checks every copy has the size, content type and, when
+	// comparable, ETag of its source
+	VerifyMetadata bool
+	// NoClobber skips the keys whose destination already exists
+	NoClobber bool
+	// TrimPrefix is removed from the start of every key to get its path
+	// under the dest instead of the source prefix, keys which don't start
+	// with it are copied under the dest with their full key
+	TrimPrefix string
+	// Encryption is how the copies are encrypted
+	Encryption ServerSideEncryption
+	// ChecksumAlgorithm makes S3 compute and store the additional checksum
+	// of every copy with this algorithm, which is then read back
+	ChecksumAlgorithm string
+}
+
 // CopyAll copies keys to the dest, source defines what the base prefix is
-func (w *S3Wrapper) CopyAll(keys chan *ListOutput, source, dest string, delimiter string, recurse, flat bool) chan *ListOutput {
-	_, sourcePrefix := parseS3Uri(source)
-	destBucket, destPrefix := parseS3Uri(dest)
+func (w *S3Wrapper) CopyAll(keys chan *ListOutput, source, dest string, delimiter string, recurse bool, opts CopyOptions) chan *ListOutput {
+	_, sourcePrefix := ParseS3Uri(source)
+	destBucket, destPrefix := ParseS3Uri(dest)
+	// the copies, and every other request about the destination, are sent
+	// to the region of the destination bucket, which S3 copies across
+	// regions from
+	destWrap := w.forBucket(destBucket)
 
 	listOut := make(chan *ListOutput, 1e4)
 	var wg sync.WaitGroup
+	// seen maps the destination keys claimed so far to their source when
+	// flattening, collisions are resolved in listing order
+	seen := make(map[string]string)
+	aborted := false
 	for key := range keys {
+		if key.IsPrefix || w.draining() || aborted {
+			continue
+		}
+
+		relativeKey := key.Key
+		if opts.TrimPrefix != "" {
+			if strings.HasPrefix(key.Key, opts.TrimPrefix) {
+				relativeKey = strings.TrimPrefix(key.Key, opts.TrimPrefix)
+			} else {
+				w.logger.Printf("%s doesn't start with %s, copying it with its full key", key.FullKey, opts.TrimPrefix)
+			}
+		} else if opts.Flat {
+			segments := strings.Split(key.Key, delimiter)
+			relativeKey = segments[len(segments)-1]
+		} else if recurse {
+			relativeKey = RelativeKey(sourcePrefix, key.Key, delimiter)
+		}
+		fullDest := destPrefix + relativeKey
+
+		if opts.Flat {
+			if first, ok := seen[fullDest]; ok {
+				switch opts.OnCollision {
+				case CollisionSkip:
+					w.logger.Printf("Skipping %s, %s is already copied to %s", key.FullKey, first, FormatS3Uri(destBucket, fullDest))
+					continue
+				case CollisionRename:
+					fullDest = renameCollision(fullDest, seen)
+				case CollisionError:
+					w.errs.add(fmt.Errorf("unable to copy %s: %s is already copied to %s, aborting", key.FullKey, first, FormatS3Uri(destBucket, fullDest)))
+					aborted = true
+					continue
+				}
+			}
+			seen[fullDest] = key.FullKey
+		}
+
 		wg.Add(1)
-		go func(k *ListOutput) {
+		go func(k *ListOutput, fullDest string) {
 			defer wg.Done()
-			w.concurrencySemaphore <- struct{}{}
-			defer func() { <-w.concurrencySemaphore }()
-
-			if !k.IsPrefix {
-				keyBucket, keyPrefix := parseS3Uri(k.FullKey)
-				sourcePath := "/" + path.Join(keyBucket, keyPrefix)
-
-				// trim common path prefixes from k.Key and sourcePrefix
-				trimDest := strings.Split(k.Key, delimiter)
-				if flat {
-					trimDest = trimDest[len(trimDest)-1:]
-				} else if recurse {
-					trimSource := strings.Split(sourcePrefix, delimiter)
-					for len(trimDest) > 1 && len(trimSource) > 1 {
-						if trimDest[0] != trimSource[0] {
-							break
-						}
-						trimDest = trimDest[1:]
-						trimSource = trimSource[1:]
-					}
+			defer w.recoverPanic()
+			w.acquire()
+			defer w.release()
+			if w.draining() {
+				return
+			}
+
+			keyBucket, keyPrefix := ParseS3Uri(k.FullKey)
+			// path.Join would clean keys containing // or ./ into other keys
+			sourcePath := "/" + keyBucket + "/" + keyPrefix
+
+			if opts.NoClobber {
+				exists, err := destWrap.exists(destBucket, fullDest)
+				if err != nil {
+					w.fail(k, fmt.Errorf("unable to copy %s: %s", k.FullKey, err))
+					return
+				}
+				if exists {
+					w.logger.Printf("Skipping %s, %s already exists", k.FullKey, FormatS3Uri(destBucket, fullDest))
+					return
 				}
-				fullDest := destPrefix + strings.Join(trimDest, delimiter)
+			}
 
-				_, err := w.svc.CopyObject(&s3.CopyObjectInput{
-					Bucket:     &destBucket,
-					CopySource: &sourcePath,
-					Key:        &fullDest,
-				})
+			params := &s3.CopyObjectInput{}
+			if !opts.Headers.IsZero() {
+				// headers can only be changed by replacing all of the
+				// metadata, so the metadata of the source is read first
+				head, err := w.headObject(k.Bucket, k.Key)
 				if err != nil {
-					fmt.Println("error:", err)
-				} else {
-					k.Key = fullDest
-					listOut <- k
+					w.fail(k, fmt.Errorf("unable to copy %s: %s", k.FullKey, err))
+					return
 				}
+				params = replaceCopyInput(head)
+				// copies go to the default storage class like they do
+				// without headers
+				params.StorageClass = nil
+				opts.Headers.applyToCopy(params)
 			}
-		}(key)
+			params.Bucket = &destBucket
+			params.CopySource = &sourcePath
+			params.Key = &fullDest
+			opts.Encryption.applyToCopy(params)
+
+			options := opts.Encryption.requestOptions()
+			if opts.ChecksumAlgorithm != "" {
+				options = append(options, checksumAlgorithmOption(opts.ChecksumAlgorithm))
+			}
+			err := destWrap.copyObject(params, options...)
+			if err != nil {
+				w.fail(k, fmt.Errorf("unable to copy %s: %s", k.FullKey, err))
+				return
+			}
+			if opts.ChecksumAlgorithm != "" && !w.dryRun {
+				// the SDK doesn't parse the checksum out of the copy result
+				checksum, err := destWrap.headChecksum(destBucket, fullDest, opts.ChecksumAlgorithm)
+				if err != nil {
+					w.fail(k, fmt.Errorf("unable to read the checksum of the copy of %s: %s", k.FullKey, err))
+					return
+				}
+				k.ChecksumAlgorithm, k.Checksum = opts.ChecksumAlgorithm, checksum
+			}
+			if opts.VerifyMetadata && !w.dryRun {
+				if err := w.verifyCopy(k, destWrap, destBucket, fullDest); err != nil {
+					w.fail(k, err)
+					return
+				}
+			}
+			k.Key = fullDest
+			listOut <- k
+		}(key, fullDest)
 	}
 
 	go func() {
@@ -365,12 +1198,96 @@ func (w *S3Wrapper) CopyAll(keys chan *ListOutput, source, dest string, delimite
 	return listOut
 }
 
+// RelativeKey returns key relative to sourcePrefix, which is the prefix it
+// was listed under, so that it can be appended to a destination prefix. The
+// path segments, split by delimiter, key has in common with sourcePrefix are
+// trimmed, a sourcePrefix without a trailing delimiter is kept as the first
+// segment of the relative key (s3://b/logs copies logs/ itself) and the last
+// segment of key, its name, is always kept.
+func RelativeKey(sourcePrefix string, key string, delimiter string) string {
+	trimKey := strings.Split(key, delimiter)
+	trimSource := strings.Split(sourcePrefix, delimiter)
+	for len(trimKey) > 1 && len(trimSource) > 1 {
+		if trimKey[0] != trimSource[0] {
+			break
+		}
+		trimKey = trimKey[1:]
+		trimSource = trimSource[1:]
+	}
+	return strings.Join(trimKey, delimiter)
+}
+
+// verifyCopy HEADs the source k and its copy at destKey using destWrap and
+// checks they have the same size, content type and ETag, ETags are only
+// compared when the source's isn't the one of a multipart upload, since a
+// copy is never one, nor with SSE-C or when either is encrypted with
+// SSE-KMS since those ETags aren't MD5s
+func (w *S3Wrapper) verifyCopy(k *ListOutput, destWrap *S3Wrapper, destBucket string, destKey string) error {
+	destUri := FormatS3Uri(destBucket, destKey)
+	source, err := w.headObject(k.Bucket, k.Key)
+	if err != nil {
+		return fmt.Errorf("unable to verify the copy of %s: %s", k.FullKey, err)
+	}
+	copied, err := destWrap.headObject(destBucket, destKey)
+	if err != nil {
+		return fmt.Errorf("unable to verify the copy %s: %s", destUri, err)
+	}
+
+	mismatches := make([]string, 0)
+	if aws.Int64Value(source.ContentLength) != aws.Int64Value(copied.ContentLength) {
+		mismatches = append(mismatches, fmt.Sprintf("size %d != %d", aws.Int64Value(source.ContentLength), aws.Int64Value(copied.ContentLength)))
+	}
+	if aws.StringValue(source.ContentType) != aws.StringValue(copied.ContentType) {
+		mismatches = append(mismatches, fmt.Sprintf("content type %q != %q", aws.StringValue(source.ContentType), aws.StringValue(copied.ContentType)))
+	}
+	kms := aws.StringValue(source.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms || aws.StringValue(copied.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms
+	if etag := aws.StringValue(source.ETag); etag != "" && !strings.Contains(etag, "-") && w.sseCustomerKey == nil && !kms && etag != aws.StringValue(copied.ETag) {
+		mismatches = append(mismatches, fmt.Sprintf("ETag %s != %s", etag, aws.StringValue(copied.ETag)))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("copy %s doesn't match its source %s: %s", destUri, k.FullKey, strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// renameCollision returns fullDest with the lowest numeric suffix, inserted
+// before its extension, which isn't in seen
+func renameCollision(fullDest string, seen map[string]string) string {
+	ext := path.Ext(fullDest)
+	base := strings.TrimSuffix(fullDest, ext)
+	for i := 1; ; i++ {
+		renamed := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if _, ok := seen[renamed]; !ok {
+			return renamed
+		}
+	}
+}
+
+// copyObject makes the CopyObject request of params with the wrapper's
+// options and options applied, nothing is copied in dry-run mode
+func (w *S3Wrapper) copyObject(params *s3.CopyObjectInput, options ...request.Option) error {
+	if w.dryRun {
+		return nil
+	}
+	params.RequestPayer = w.requestPayer
+	w.sseCustomerKey.applyToCopy(params)
+	return w.retryWrite(func() error {
+		_, err := w.client(aws.StringValue(params.Bucket)).CopyObjectWithContext(w.ctx, params, options...)
+		return err
+	})
+}
+
 // ListBuckets returns a list of bucket names and does a prefix
 // filter based on s3Uri (of the form s3://<bucket-prefix>)
 func (w *S3Wrapper) ListBuckets(s3Uri string) ([]string, error) {
 
-	bucketPrefix, _ := parseS3Uri(s3Uri)
-	results, err := w.svc.ListBuckets(&s3.ListBucketsInput{})
+	bucketPrefix, _ := ParseS3Uri(s3Uri)
+	var results *s3.ListBucketsOutput
+	err := w.retry(true, func() error {
+		var err error
+		results, err = w.svc.ListBuckets(&s3.ListBucketsInput{})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -387,7 +1304,10 @@ func (w *S3Wrapper) ListBuckets(s3Uri string) ([]string, error) {
 
 const maxKeysPerDeleteObjectsRequest = 1000
 
-// DeleteObjects deletes all keys in the given keys channel
+// DeleteObjects deletes all keys in the given keys channel. The batches are
+// filled without holding a slot of the wrapper's concurrency, which is only
+// taken for each request, so keys may be fed by other operations of the
+// same wrapper.
 func (w *S3Wrapper) DeleteObjects(keys chan *ListOutput) chan *ListOutput {
 	listOut := make(chan *ListOutput, 1e4)
 	var wg sync.WaitGroup
@@ -395,64 +1315,33 @@ func (w *S3Wrapper) DeleteObjects(keys chan *ListOutput) chan *ListOutput {
 	for i := 0; i < cap(w.concurrencySemaphore); i++ {
 		wg.Add(1)
 		go func() {
-			w.concurrencySemaphore <- struct{}{}
-			defer func() { <-w.concurrencySemaphore }()
 			defer wg.Done()
-			objects := make([]*s3.ObjectIdentifier, 0, maxKeysPerDeleteObjectsRequest)
-			listOutCache := make([]*ListOutput, 0, maxKeysPerDeleteObjectsRequest)
-			params := &s3.DeleteObjectsInput{
-				Bucket: aws.String(""),
-				Delete: &s3.Delete{},
-			}
+			defer w.recoverPanic()
+			bucket := ""
+			batch := make([]*ListOutput, 0, maxKeysPerDeleteObjectsRequest)
 			for item := range keys {
-				if item.IsPrefix {
+				if item.IsPrefix || w.draining() {
 					continue
 				}
 
-				if *params.Bucket == "" {
-					params.Bucket = aws.String(item.Bucket)
+				if bucket == "" {
+					bucket = item.Bucket
 				}
 				// only maxKeysPerDeleteObjectsRequest objects can fit in
 				// one DeleteObjects request also if the bucket changes we cannot
 				// put it in the same request so we flush and start a new one
-				if len(objects) >= maxKeysPerDeleteObjectsRequest || *params.Bucket != item.Bucket {
-					// flush
-					params.Delete = &s3.Delete{
-						Objects: objects,
-					}
-					_, err := w.svc.DeleteObjects(params)
-					if err != nil {
-						panic(err)
-					}
-
-					// write the keys deleted to the results channel
-					for _, cacheItem := range listOutCache {
-						listOut <- cacheItem
-					}
+				if len(batch) >= maxKeysPerDeleteObjectsRequest || bucket != item.Bucket {
+					w.deleteBatch(bucket, batch, listOut)
 
 					// reset
-					listOutCache = make([]*ListOutput, 0, maxKeysPerDeleteObjectsRequest)
-					params.Bucket = aws.String(item.Bucket)
-					objects = make([]*s3.ObjectIdentifier, 0, maxKeysPerDeleteObjectsRequest)
+					bucket = item.Bucket
+					batch = make([]*ListOutput, 0, maxKeysPerDeleteObjectsRequest)
 				}
-				objects = append(objects, &s3.ObjectIdentifier{
-					Key: aws.String(item.Key),
-				})
-				listOutCache = append(listOutCache, item)
+				batch = append(batch, item)
 			}
-			if len(objects) > 0 {
+			if len(batch) > 0 && !w.draining() {
 				// flush again for any remaining keys
-				params.Delete = &s3.Delete{
-					Objects: objects,
-				}
-				_, err := w.svc.DeleteObjects(params)
-				if err != nil {
-					panic(err)
-				}
-
-				for _, cacheItem := range listOutCache {
-					listOut <- cacheItem
-				}
+				w.deleteBatch(bucket, batch, listOut)
 			}
 		}()
 	}
@@ -465,9 +1354,70 @@ func (w *S3Wrapper) DeleteObjects(keys chan *ListOutput) chan *ListOutput {
 	return listOut
 }
 
-// getReaderByExt is a factory for reader based on the extension of the key
+// deleteBatch deletes the keys in batch from bucket with a single request and
+// writes the keys which were deleted to listOut
+func (w *S3Wrapper) deleteBatch(bucket string, batch []*ListOutput, listOut chan *ListOutput) {
+	if w.dryRun {
+		for _, item := range batch {
+			listOut <- item
+		}
+		return
+	}
+
+	objects := make([]*s3.ObjectIdentifier, 0, len(batch))
+	for _, item := range batch {
+		object := &s3.ObjectIdentifier{
+			Key: aws.String(item.Key),
+		}
+		if item.VersionID != "" {
+			object.VersionId = aws.String(item.VersionID)
+		}
+		objects = append(objects, object)
+	}
+	var resp *s3.DeleteObjectsOutput
+	w.acquire()
+	err := w.retryWrite(func() error {
+		var err error
+		resp, err = w.client(bucket).DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket:       aws.String(bucket),
+			Delete:       &s3.Delete{Objects: objects},
+			RequestPayer: w.requestPayer,
+		})
+		return err
+	})
+	w.release()
+	if err != nil {
+		w.errs.add(fmt.Errorf("unable to delete %d keys from %s: %s", len(batch), bucket, err))
+		return
+	}
+
+	// keys can fail individually even when the request succeeds
+	failed := make(map[string]bool, len(resp.Errors))
+	for _, deleteErr := range resp.Errors {
+		key := aws.StringValue(deleteErr.Key)
+		failed[key] = true
+		w.errs.add(fmt.Errorf("unable to delete %s: %s", FormatS3Uri(bucket, key), aws.StringValue(deleteErr.Message)))
+	}
+
+	// write the keys deleted to the results channel
+	for _, item := range batch {
+		if !failed[item.Key] {
+			listOut <- item
+		}
+	}
+}
+
+// bzip2Magic starts every bzip2 stream and zstdMagic every zstd frame
+const (
+	bzip2Magic = "BZh"
+	zstdMagic  = "\x28\xb5\x2f\xfd"
+)
+
+// getReaderByExt is a factory for reader based on the extension of the key,
+// whatever its case. Keys which aren't actually compressed the way their
+// extension says are read as they are.
 func getReaderByExt(reader io.ReadCloser, key string) (io.ReadCloser, error) {
-	ext := path.Ext(key)
+	ext := strings.ToLower(path.Ext(key))
 	if ext == ".gz" || ext == ".gzip" {
 		gzReader, err := gzip.NewReader(reader)
 		if err != nil {
@@ -475,10 +1425,57 @@ func getReaderByExt(reader io.ReadCloser, key string) (io.ReadCloser, error) {
 		}
 		return gzReader, nil
 	}
+	if ext == ".bz2" {
+		// bzip2 only fails once read, so the header is checked beforehand
+		buffered := bufio.NewReader(reader)
+		if magic, err := buffered.Peek(len(bzip2Magic)); err != nil || string(magic) != bzip2Magic {
+			return ioutil.NopCloser(buffered), nil
+		}
+		return ioutil.NopCloser(bzip2.NewReader(buffered)), nil
+	}
+	if ext == ".zst" {
+		buffered := bufio.NewReader(reader)
+		if magic, err := buffered.Peek(len(zstdMagic)); err != nil || string(magic) != zstdMagic {
+			return ioutil.NopCloser(buffered), nil
+		}
+		// keys are already decoded concurrently, one goroutine each is enough
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
 
 	return reader, nil
 }
 
+// LocalPath returns the local path of the file key is downloaded to, its
+// directories are the segments of key separated by pathDelimiter. When
+// pathDelimiter is empty the whole key is the name of the file, with the
+// characters which can't be in file names replaced by _.
+func LocalPath(key string, pathDelimiter string) string {
+	if pathDelimiter == "" {
+		return strings.Map(func(r rune) rune {
+			if r == 0 || r == '/' || r == filepath.Separator || (runtime.GOOS == "windows" && strings.ContainsRune(`<>:"|?*`, r)) {
+				return '_'
+			}
+			return r
+		}, key)
+	}
+	// the segments are joined as they are, filepath.Join would clean them
+	return strings.Join(strings.Split(key, pathDelimiter), string(filepath.Separator))
+}
+
+// trimCompressionExt drops the extension of key if it is one getReaderByExt
+// decompresses
+func trimCompressionExt(key string) string {
+	ext := path.Ext(key)
+	if lower := strings.ToLower(ext); lower == ".gz" || lower == ".gzip" || lower == ".bz2" || lower == ".zst" {
+		return strings.TrimSuffix(key, ext)
+	}
+	return key
+}
+
 // createPathIfNotExists takes a path and creates
 // it if it doesn't exist
 func createPathIfNotExists(path string) error {
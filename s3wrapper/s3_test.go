@@ -0,0 +1,223 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRelativeKey(t *testing.T) {
+	tests := []struct {
+		name         string
+		sourcePrefix string
+		key          string
+		delimiter    string
+		want         string
+	}{
+		{"trailing delimiter", "logs/", "logs/2020/a.gz", "/", "2020/a.gz"},
+		{"nested trailing delimiter", "logs/2020/", "logs/2020/01/a.gz", "/", "01/a.gz"},
+		{"no trailing delimiter keeps the last segment", "logs", "logs/2020/a.gz", "/", "logs/2020/a.gz"},
+		{"nested without trailing delimiter", "logs/2020", "logs/2020/01/a.gz", "/", "2020/01/a.gz"},
+		// a prefix ending in the middle of a segment keeps the whole segment
+		{"partial segment", "logs/20", "logs/2020/a.gz", "/", "2020/a.gz"},
+		{"partial first segment", "lo", "logs/a.gz", "/", "logs/a.gz"},
+		{"diverging segments", "logs/2020/", "logs/2021/a.gz", "/", "2021/a.gz"},
+		{"whole bucket", "", "logs/a.gz", "/", "logs/a.gz"},
+		// the name of the key is kept even when it is the source
+		{"single object", "logs/a.gz", "logs/a.gz", "/", "a.gz"},
+		// flat keys have no delimiter to split on
+		{"flat key", "", "a.gz", "/", "a.gz"},
+		{"flat key under a prefix", "a", "a.gz", "/", "a.gz"},
+		{"flat listing of a nested key", "logs/", "logs/a.gz", "/", "a.gz"},
+		{"empty segment", "logs/", "logs//a.gz", "/", "/a.gz"},
+		{"other delimiter", "logs|2020|", "logs|2020|01|a.gz", "|", "01|a.gz"},
+		{"slashes with another delimiter", "logs|", "logs|2020/01|a.gz", "|", "2020/01|a.gz"},
+		{"multi character delimiter", "logs::", "logs::2020::a.gz", "::", "2020::a.gz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := RelativeKey(test.sourcePrefix, test.key, test.delimiter); got != test.want {
+				t.Errorf("RelativeKey(%q, %q, %q) = %q, want %q", test.sourcePrefix, test.key, test.delimiter, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	sep := string(filepath.Separator)
+	tests := []struct {
+		name          string
+		key           string
+		pathDelimiter string
+		want          string
+	}{
+		{"slash", "logs/2020/a.gz", "/", "logs" + sep + "2020" + sep + "a.gz"},
+		{"pipe", "logs|2020|a.gz", "|", "logs" + sep + "2020" + sep + "a.gz"},
+		{"pipe keeps slashes in segments", "logs|2020/01|a.gz", "|", "logs" + sep + "2020/01" + sep + "a.gz"},
+		{"multi character delimiter", "logs::a.gz", "::", "logs" + sep + "a.gz"},
+		// segments are joined as they are rather than cleaned
+		{"empty segment", "logs||a.gz", "|", "logs" + sep + sep + "a.gz"},
+		{"trailing delimiter", "logs|", "|", "logs" + sep},
+		{"no delimiter in key", "a.gz", "|", "a.gz"},
+		// the whole key is the name of the file without delimiter
+		{"empty delimiter", "logs|2020|a.gz", "", "logs|2020|a.gz"},
+		{"empty delimiter replaces slashes", "logs/2020/a.gz", "", "logs_2020_a.gz"},
+		{"empty delimiter replaces NUL", "a\x00b", "", "a_b"},
+		{"empty delimiter keeps other characters", "a b:c*?.gz", "", map[bool]string{true: "a b_c__.gz", false: "a b:c*?.gz"}[runtime.GOOS == "windows"]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := LocalPath(test.key, test.pathDelimiter); got != test.want {
+				t.Errorf("LocalPath(%q, %q) = %q, want %q", test.key, test.pathDelimiter, got, test.want)
+			}
+		})
+	}
+}
+
+// versionedS3 returns a wrapper of a stub versioned S3 with the given
+// concurrency, every key listed has a version and a delete marker as its
+// latest version, and every delete succeeds
+func versionedS3(t *testing.T, concurrency int) *S3Wrapper {
+	return New(stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		if r.Method == http.MethodPost {
+			fmt.Fprint(w, "<DeleteResult></DeleteResult>")
+			return
+		}
+		key := r.URL.Query().Get("prefix")
+		fmt.Fprintf(w, "<ListVersionsResult><Name>bk</Name><IsTruncated>false</IsTruncated>"+
+			"<Version><Key>%s</Key><VersionId>v1</VersionId><IsLatest>false</IsLatest><Size>1</Size></Version>"+
+			"<DeleteMarker><Key>%s</Key><VersionId>m1</VersionId><IsLatest>true</IsLatest></DeleteMarker>"+
+			"</ListVersionsResult>", key, key)
+	})), concurrency).WithLogger(discardLogger{})
+}
+
+// TestDeleteObjectsFedBySameWrapper checks DeleteObjects doesn't hold the
+// wrapper's slots while waiting for keys, which the operations feeding it
+// need to produce them
+func TestDeleteObjectsFedBySameWrapper(t *testing.T) {
+	tests := []struct {
+		name string
+		keys func(w *S3Wrapper) chan *ListOutput
+		want int
+	}{
+		// rm --include-versions
+		{"ListKeyVersions", func(w *S3Wrapper) chan *ListOutput {
+			keys := make(chan *ListOutput)
+			go func() {
+				defer close(keys)
+				for _, key := range []string{"a", "b", "c"} {
+					time.Sleep(100 * time.Millisecond)
+					keys <- &ListOutput{Bucket: "bk", Key: key, FullKey: FormatS3Uri("bk", key)}
+				}
+			}()
+			return w.ListKeyVersions(keys)
+		}, 6},
+		// undelete
+		{"ListDeleteMarkers", func(w *S3Wrapper) chan *ListOutput {
+			markers := make(chan *ListOutput)
+			go func() {
+				defer close(markers)
+				time.Sleep(100 * time.Millisecond)
+				for _, key := range []string{"a", "b", "c"} {
+					for marker := range w.ListDeleteMarkers("s3://bk/"+key, true, "/", "") {
+						markers <- marker
+					}
+				}
+			}()
+			return markers
+		}, 3},
+	}
+	for _, test := range tests {
+		for _, concurrency := range []int{1, 2} {
+			t.Run(fmt.Sprintf("%s concurrency %d", test.name, concurrency), func(t *testing.T) {
+				w := versionedS3(t, concurrency)
+				if n := drain(t, w.DeleteObjects(test.keys(w))); n != test.want {
+					t.Errorf("deleted %d versions, want %d", n, test.want)
+				}
+				if err := w.Err(); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
+
+func TestBucketRegionFailuresNotCached(t *testing.T) {
+	lookups := 0
+	svc := stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		if lookups == 1 {
+			writeStubError(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+	}))
+	w := New(svc, 1)
+	if _, err := w.bucketRegion("bk"); err == nil {
+		t.Fatal("the first lookup didn't fail")
+	}
+	// the failed lookup is made again, the successful one isn't
+	for i := 0; i < 2; i++ {
+		if region, err := w.bucketRegion("bk"); err != nil || region != "eu-west-1" {
+			t.Errorf("got %q, %v, want eu-west-1", region, err)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("got %d lookups, want 2", lookups)
+	}
+
+	// the regions are only known to the wrapper which looked them up or was
+	// given them
+	other := New(svc, 1).WithBucketRegion("given", "ap-south-1")
+	if region, err := other.bucketRegion("given"); err != nil || region != "ap-south-1" {
+		t.Errorf("got %q, %v, want ap-south-1", region, err)
+	}
+	if _, err := other.bucketRegion("bk"); err != nil || lookups != 3 {
+		t.Errorf("got %v after %d lookups, want another lookup of bk", err, lookups)
+	}
+	if _, ok := w.regions.regions.Load("given"); ok {
+		t.Error("the region given to another wrapper leaked")
+	}
+}
+
+func TestVerifyCopyETags(t *testing.T) {
+	tests := []struct {
+		name      string
+		sourceSSE string
+		copiedSSE string
+		wantErr   bool
+	}{
+		{"unencrypted", "", "", true},
+		{"AES256", "AES256", "AES256", true},
+		// SSE-KMS ETags aren't MD5s and differ on every copy
+		{"KMS source", "aws:kms", "aws:kms", false},
+		{"KMS copy", "", "aws:kms", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := New(stubS3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				etag, sse := `"aaa"`, test.sourceSSE
+				if r.URL.Path == "/bk/copy" {
+					etag, sse = `"bbb"`, test.copiedSSE
+				}
+				w.Header().Set("ETag", etag)
+				w.Header().Set("Content-Length", "1")
+				if sse != "" {
+					w.Header().Set("X-Amz-Server-Side-Encryption", sse)
+				}
+			})), 1)
+			k := &ListOutput{Bucket: "bk", Key: "source", FullKey: "s3://bk/source"}
+			err := w.verifyCopy(k, w, "bk", "copy")
+			if test.wantErr && err == nil {
+				t.Error("got no error, want an ETag mismatch")
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("got %s, want no error", err)
+			}
+		})
+	}
+}
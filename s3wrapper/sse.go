@@ -0,0 +1,85 @@
+package s3wrapper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// sseContextHeader is the header of the KMS encryption context, which the
+// SDK has no field for
+const sseContextHeader = "X-Amz-Server-Side-Encryption-Context"
+
+// ServerSideEncryption is how S3 encrypts the objects written, the zero
+// value leaves it to the default encryption of the bucket
+type ServerSideEncryption struct {
+	// Algorithm is AES256 or aws:kms
+	Algorithm string
+	// KMSKeyID is the KMS key of aws:kms, S3 uses the aws/s3 key when empty
+	KMSKeyID string
+	// KMSEncryptionContext is the encryption context of aws:kms, it is
+	// checked against the key policy and logged with every use of the key
+	KMSEncryptionContext map[string]string
+}
+
+// Validate checks the KMS options are only set with aws:kms
+func (e ServerSideEncryption) Validate() error {
+	switch e.Algorithm {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return fmt.Errorf("unknown server side encryption %q, expected %s or %s", e.Algorithm, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+	if e.Algorithm != s3.ServerSideEncryptionAwsKms && (e.KMSKeyID != "" || len(e.KMSEncryptionContext) > 0) {
+		return fmt.Errorf("a KMS key id or encryption context requires %s encryption", s3.ServerSideEncryptionAwsKms)
+	}
+	return nil
+}
+
+// encodedContext returns the encryption context as S3 expects it, the
+// base64 encoded JSON of its pairs
+func (e ServerSideEncryption) encodedContext() string {
+	if len(e.KMSEncryptionContext) == 0 {
+		return ""
+	}
+	// a map of strings always encodes
+	encoded, _ := json.Marshal(e.KMSEncryptionContext)
+	return base64.StdEncoding.EncodeToString(encoded)
+}
+
+func (e ServerSideEncryption) applyToCopy(params *s3.CopyObjectInput) {
+	if e.Algorithm != "" {
+		params.ServerSideEncryption = aws.String(e.Algorithm)
+	}
+	if e.KMSKeyID != "" {
+		params.SSEKMSKeyId = aws.String(e.KMSKeyID)
+	}
+}
+
+func (e ServerSideEncryption) applyToUpload(params *s3manager.UploadInput) {
+	if e.Algorithm != "" {
+		params.ServerSideEncryption = aws.String(e.Algorithm)
+	}
+	if e.KMSKeyID != "" {
+		params.SSEKMSKeyId = aws.String(e.KMSKeyID)
+	}
+}
+
+// requestOptions returns the options setting the encryption context on the
+// requests creating objects, S3 rejects it on the parts of multipart uploads
+func (e ServerSideEncryption) requestOptions() []request.Option {
+	encoded := e.encodedContext()
+	if encoded == "" {
+		return nil
+	}
+	return []request.Option{func(r *request.Request) {
+		switch r.Operation.Name {
+		case "PutObject", "CopyObject", "CreateMultipartUpload":
+			r.HTTPRequest.Header.Set(sseContextHeader, encoded)
+		}
+	}}
+}
@@ -0,0 +1,66 @@
+package s3wrapper
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// sseCustomerKey holds the parameters S3 requires on every request reading
+// or writing an object encrypted with a customer provided key (SSE-C), a nil
+// sseCustomerKey leaves requests untouched
+type sseCustomerKey struct {
+	algorithm *string
+	key       *string
+	keyMD5    *string
+}
+
+// WithSSECustomerKey makes the wrapper read and write objects encrypted with
+// key, a 256 bit AES key (SSE-C), a nil key disables SSE-C. Copies use key
+// for both their source and destination.
+func (w *S3Wrapper) WithSSECustomerKey(key []byte) *S3Wrapper {
+	if key == nil {
+		w.sseCustomerKey = nil
+		return w
+	}
+	sum := md5.Sum(key)
+	w.sseCustomerKey = &sseCustomerKey{
+		algorithm: aws.String(s3.ServerSideEncryptionAes256),
+		// the SDK base64 encodes the key itself
+		key:    aws.String(string(key)),
+		keyMD5: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+	}
+	return w
+}
+
+func (k *sseCustomerKey) applyToGet(params *s3.GetObjectInput) {
+	if k != nil {
+		params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = k.algorithm, k.key, k.keyMD5
+	}
+}
+
+func (k *sseCustomerKey) applyToHead(params *s3.HeadObjectInput) {
+	if k != nil {
+		params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = k.algorithm, k.key, k.keyMD5
+	}
+}
+
+// applyToCopy sets the key of both the source and the destination, S3 needs
+// the former to decrypt the source and encrypts the copy with the latter
+func (k *sseCustomerKey) applyToCopy(params *s3.CopyObjectInput) {
+	if k != nil {
+		params.CopySourceSSECustomerAlgorithm, params.CopySourceSSECustomerKey, params.CopySourceSSECustomerKeyMD5 = k.algorithm, k.key, k.keyMD5
+		params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = k.algorithm, k.key, k.keyMD5
+	}
+}
+
+// applyToUpload sets the key of an upload, the uploader passes it on to the
+// parts of multipart uploads
+func (k *sseCustomerKey) applyToUpload(params *s3manager.UploadInput) {
+	if k != nil {
+		params.SSECustomerAlgorithm, params.SSECustomerKey, params.SSECustomerKeyMD5 = k.algorithm, k.key, k.keyMD5
+	}
+}
@@ -0,0 +1,125 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// RestoreNotArchived is the restore status of objects which aren't archived
+	RestoreNotArchived = "not-archived"
+	// RestoreArchived is the restore status of archived objects with no restore
+	RestoreArchived = "archived"
+	// RestoreOngoing is the restore status of objects being restored
+	RestoreOngoing = "ongoing"
+	// RestoreRestored is the restore status of restored objects, until their
+	// RestoreExpiry
+	RestoreRestored = "restored"
+)
+
+var (
+	ongoingRequestRegex = regexp.MustCompile(`ongoing-request="(true|false)"`)
+	expiryDateRegex     = regexp.MustCompile(`expiry-date="([^"]+)"`)
+)
+
+// StatOutput is a key along with the metadata returned by a HEAD request
+type StatOutput struct {
+	*ListOutput
+	ContentType   string
+	ETag          string
+	StorageClass  string
+	RestoreStatus string
+	RestoreExpiry time.Time
+	// ServerSideEncryption is AES256 or aws:kms, SSE-C for objects encrypted
+	// with a customer provided key and empty for unencrypted objects
+	ServerSideEncryption string
+	// VersionID is empty for objects of buckets without versioning
+	VersionID string
+}
+
+// StatAll retrieves the metadata of every key in the given keys channel,
+// prefixes are passed through as is
+func (w *S3Wrapper) StatAll(keys chan *ListOutput) chan *StatOutput {
+	statOut := make(chan *StatOutput, 10000)
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(statOut)
+		}()
+		defer w.recoverPanic()
+		for key := range keys {
+			if w.draining() {
+				continue
+			}
+			if key.IsPrefix {
+				statOut <- &StatOutput{ListOutput: key}
+				continue
+			}
+			wg.Add(1)
+			go func(k *ListOutput) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+
+				resp, err := w.headObject(k.Bucket, k.Key)
+				if err != nil {
+					w.errs.add(fmt.Errorf("unable to stat %s: %s", k.FullKey, err))
+					return
+				}
+
+				// HEAD omits the storage class of STANDARD objects
+				storageClass := aws.StringValue(resp.StorageClass)
+				if storageClass == "" {
+					storageClass = s3.ObjectStorageClassStandard
+				}
+				restoreStatus, restoreExpiry := parseRestore(storageClass, aws.StringValue(resp.Restore))
+				sse := aws.StringValue(resp.ServerSideEncryption)
+				if resp.SSECustomerAlgorithm != nil {
+					sse = "SSE-C"
+				}
+				statOut <- &StatOutput{
+					ListOutput:           k,
+					ContentType:          aws.StringValue(resp.ContentType),
+					ETag:                 strings.Trim(aws.StringValue(resp.ETag), `"`),
+					StorageClass:         storageClass,
+					RestoreStatus:        restoreStatus,
+					RestoreExpiry:        restoreExpiry,
+					ServerSideEncryption: sse,
+					VersionID:            aws.StringValue(resp.VersionId),
+				}
+			}(key)
+		}
+	}()
+
+	return statOut
+}
+
+// parseRestore parses the x-amz-restore header of an object into its restore
+// status and, once restored, when the restored copy expires
+func parseRestore(storageClass string, restore string) (status string, expiry time.Time) {
+	if restore == "" {
+		if storageClass == s3.ObjectStorageClassGlacier || storageClass == "DEEP_ARCHIVE" {
+			return RestoreArchived, time.Time{}
+		}
+		return RestoreNotArchived, time.Time{}
+	}
+
+	if match := ongoingRequestRegex.FindStringSubmatch(restore); match != nil && match[1] == "true" {
+		return RestoreOngoing, time.Time{}
+	}
+	if match := expiryDateRegex.FindStringSubmatch(restore); match != nil {
+		expiry, _ = time.Parse(time.RFC1123, match[1])
+	}
+	return RestoreRestored, expiry
+}
@@ -0,0 +1,116 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// TagOutput is a key along with its tag set
+type TagOutput struct {
+	*ListOutput
+	Tags map[string]string
+}
+
+// GetTags retrieves the tag set of every key in the given keys channel
+func (w *S3Wrapper) GetTags(keys chan *ListOutput) chan *TagOutput {
+	tagOut := make(chan *TagOutput, 10000)
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(tagOut)
+		}()
+		defer w.recoverPanic()
+		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
+			wg.Add(1)
+			go func(k *ListOutput) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+
+				var resp *s3.GetObjectTaggingOutput
+				err := w.retry(true, func() error {
+					var err error
+					resp, err = w.client(k.Bucket).GetObjectTagging(&s3.GetObjectTaggingInput{
+						Bucket: aws.String(k.Bucket),
+						Key:    aws.String(k.Key),
+					})
+					return err
+				})
+				if err != nil {
+					w.errs.add(fmt.Errorf("unable to get tags of %s: %s", k.FullKey, err))
+					return
+				}
+				tags := make(map[string]string, len(resp.TagSet))
+				for _, tag := range resp.TagSet {
+					tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
+				tagOut <- &TagOutput{ListOutput: k, Tags: tags}
+			}(key)
+		}
+	}()
+
+	return tagOut
+}
+
+// PutTags replaces the tag set of every key in the given keys channel
+// with tags
+func (w *S3Wrapper) PutTags(keys chan *ListOutput, tags map[string]string) chan *ListOutput {
+	tagSet := make([]*s3.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	listOut := make(chan *ListOutput, 10000)
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(listOut)
+		}()
+		defer w.recoverPanic()
+		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
+			wg.Add(1)
+			go func(k *ListOutput) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+
+				if !w.dryRun {
+					err := w.retryWrite(func() error {
+						_, err := w.client(k.Bucket).PutObjectTagging(&s3.PutObjectTaggingInput{
+							Bucket:  aws.String(k.Bucket),
+							Key:     aws.String(k.Key),
+							Tagging: &s3.Tagging{TagSet: tagSet},
+						})
+						return err
+					})
+					if err != nil {
+						w.errs.add(fmt.Errorf("unable to set tags of %s: %s", k.FullKey, err))
+						return
+					}
+				}
+				listOut <- k
+			}(key)
+		}
+	}()
+
+	return listOut
+}
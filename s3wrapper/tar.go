@@ -0,0 +1,80 @@
+package s3wrapper
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// tarReaderFor returns the tar archive read from reader, decompressed first
+// when key is a .tar.gz or .tgz
+func tarReaderFor(reader io.ReadCloser, key string) (*tar.Reader, error) {
+	if strings.ToLower(path.Ext(key)) == ".tgz" {
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(gzReader), nil
+	}
+	extReader, err := getReaderByExt(reader, key)
+	if err != nil {
+		return nil, err
+	}
+	return tar.NewReader(extReader), nil
+}
+
+// streamTar reads key as a tar archive and passes the names of its members
+// to emit when TarList is set, or else the lines of its regular files one
+// member after the other. Members are read as they come so only a line is
+// held in memory at a time.
+func (w *S3Wrapper) streamTar(key *ListOutput, reader io.ReadCloser, opts StreamOptions, emit func(string)) error {
+	archive, err := tarReaderFor(reader, key.Key)
+	if err != nil {
+		return err
+	}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if w.draining() {
+			return nil
+		}
+
+		if opts.TarList {
+			if opts.IncludeKeyName {
+				emit(fmt.Sprintf("[%s] %s\n", key.FullKey, header.Name))
+			} else {
+				emit(header.Name + "\n")
+			}
+			continue
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		member := bufio.NewReader(archive)
+		for {
+			line, err := member.ReadBytes('\n')
+			if len(line) > 0 {
+				if opts.IncludeKeyName {
+					emit(fmt.Sprintf("[%s:%s] %s", key.FullKey, header.Name, line))
+				} else {
+					emit(string(line))
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
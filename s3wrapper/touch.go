@@ -0,0 +1,108 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// TouchOptions are the changes TouchAll makes to every key, empty fields
+// keep the key's current values
+type TouchOptions struct {
+	StorageClass string
+	ContentType  string
+	// Metadata is merged into the key's current user metadata
+	Metadata map[string]string
+}
+
+// TouchAll copies every key in the given keys channel onto itself, which
+// resets its last modified time, applying opts along the way. The current
+// metadata of each key is read first since a self-copy replaces all of it.
+func (w *S3Wrapper) TouchAll(keys chan *ListOutput, opts TouchOptions) chan *ListOutput {
+	listOut := make(chan *ListOutput, 10000)
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(listOut)
+		}()
+		defer w.recoverPanic()
+		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
+			wg.Add(1)
+			go func(k *ListOutput) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+
+				head, err := w.headObject(k.Bucket, k.Key)
+				if err != nil {
+					w.errs.add(fmt.Errorf("unable to touch %s: %s", k.FullKey, err))
+					return
+				}
+
+				// REPLACE is what makes S3 accept copying a key onto itself
+				params := replaceCopyInput(head)
+				params.Bucket = aws.String(k.Bucket)
+				params.Key = aws.String(k.Key)
+				params.CopySource = aws.String("/" + k.Bucket + "/" + k.Key)
+				// the key is updated in place, so it keeps its encryption
+				// and redirect rather than the defaults of the bucket
+				params.ServerSideEncryption = head.ServerSideEncryption
+				params.SSEKMSKeyId = head.SSEKMSKeyId
+				params.WebsiteRedirectLocation = head.WebsiteRedirectLocation
+				if params.Metadata == nil {
+					params.Metadata = make(map[string]*string, len(opts.Metadata))
+				}
+				for mk, mv := range opts.Metadata {
+					params.Metadata[mk] = aws.String(mv)
+				}
+				if opts.ContentType != "" {
+					params.ContentType = aws.String(opts.ContentType)
+				}
+				if opts.StorageClass != "" {
+					params.StorageClass = aws.String(opts.StorageClass)
+				}
+
+				if err := w.copyObject(params); err != nil {
+					w.errs.add(fmt.Errorf("unable to touch %s: %s", k.FullKey, err))
+					return
+				}
+				listOut <- k
+			}(key)
+		}
+	}()
+
+	return listOut
+}
+
+// replaceCopyInput creates the input of a copy with the REPLACE metadata
+// directive which keeps the metadata and headers head returned for the
+// source, since REPLACE drops everything which isn't set on the copy
+func replaceCopyInput(head *s3.HeadObjectOutput) *s3.CopyObjectInput {
+	params := &s3.CopyObjectInput{
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+		Metadata:           head.Metadata,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		ContentType:        head.ContentType,
+		StorageClass:       head.StorageClass,
+	}
+	if head.Expires != nil {
+		if expires, err := http.ParseTime(*head.Expires); err == nil {
+			params.Expires = &expires
+		}
+	}
+	return params
+}
@@ -0,0 +1,184 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ListDeleteMarkers lists the keys under s3Uri whose latest version is a
+// delete marker, i.e. the keys deleted from a versioned bucket whose data
+// is still there. The VersionID of the outputs is the one of the marker, so
+// DeleteObjects removes the marker and the previous version becomes the
+// current one again.
+func (w *S3Wrapper) ListDeleteMarkers(s3Uri string, recursive bool, delimiter string, keyRegex string) chan *ListOutput {
+	bucket, prefix := ParseS3Uri(s3Uri)
+	if recursive {
+		delimiter = ""
+	}
+	ch := make(chan *ListOutput, 10000)
+	var keyRegexFilter *regexp.Regexp
+	if keyRegex != "" {
+		var err error
+		keyRegexFilter, err = regexp.Compile(keyRegex)
+		if err != nil {
+			w.errs.add(err)
+			close(ch)
+			return ch
+		}
+	}
+
+	params := &s3.ListObjectVersionsInput{
+		Bucket:       aws.String(bucket),
+		Delimiter:    aws.String(delimiter),
+		EncodingType: aws.String(s3.EncodingTypeUrl),
+		MaxKeys:      aws.Int64(1000),
+		Prefix:       aws.String(prefix),
+	}
+
+	go func() {
+		defer close(ch)
+		defer w.recoverPanic()
+		w.acquire()
+		defer w.release()
+		if w.draining() {
+			return
+		}
+
+		svc := w.client(bucket)
+		for {
+			var page *s3.ListObjectVersionsOutput
+			err := w.retry(true, func() error {
+				var err error
+				page, err = svc.ListObjectVersions(params)
+				return err
+			})
+			if err != nil {
+				w.errs.add(fmt.Errorf("unable to list the versions of %s: %s", s3Uri, err))
+				return
+			}
+
+			for _, marker := range page.DeleteMarkers {
+				if !aws.BoolValue(marker.IsLatest) {
+					continue
+				}
+				escapedKey, err := url.QueryUnescape(aws.StringValue(marker.Key))
+				if err != nil {
+					escapedKey = aws.StringValue(marker.Key)
+				}
+				formattedKey := FormatS3Uri(bucket, escapedKey)
+				if keyRegexFilter != nil && !keyRegexFilter.MatchString(formattedKey) {
+					continue
+				}
+				ch <- &ListOutput{
+					Key:          escapedKey,
+					FullKey:      formattedKey,
+					LastModified: aws.TimeValue(marker.LastModified),
+					Bucket:       bucket,
+					VersionID:    aws.StringValue(marker.VersionId),
+				}
+			}
+			if !aws.BoolValue(page.IsTruncated) || w.draining() {
+				return
+			}
+			params.KeyMarker = page.NextKeyMarker
+			params.VersionIdMarker = page.NextVersionIdMarker
+		}
+	}()
+
+	return ch
+}
+
+// ListKeyVersions lists every version of the keys, delete markers included,
+// with their VersionID set so DeleteObjects removes them permanently.
+// Prefixes are dropped.
+func (w *S3Wrapper) ListKeyVersions(keys chan *ListOutput) chan *ListOutput {
+	ch := make(chan *ListOutput, 10000)
+	var wg sync.WaitGroup
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(ch)
+		}()
+		defer w.recoverPanic()
+		for key := range keys {
+			if key.IsPrefix || w.draining() {
+				continue
+			}
+			wg.Add(1)
+			go func(k *ListOutput) {
+				defer wg.Done()
+				defer w.recoverPanic()
+				w.acquire()
+				defer w.release()
+				if w.draining() {
+					return
+				}
+				if err := w.listKeyVersions(k, ch); err != nil {
+					w.errs.add(fmt.Errorf("unable to list the versions of %s: %s", k.FullKey, err))
+				}
+			}(key)
+		}
+	}()
+	return ch
+}
+
+// listKeyVersions sends the versions and delete markers of k to ch
+func (w *S3Wrapper) listKeyVersions(k *ListOutput, ch chan *ListOutput) error {
+	params := &s3.ListObjectVersionsInput{
+		Bucket:       aws.String(k.Bucket),
+		EncodingType: aws.String(s3.EncodingTypeUrl),
+		MaxKeys:      aws.Int64(1000),
+		Prefix:       aws.String(k.Key),
+	}
+	svc := w.client(k.Bucket)
+	for {
+		var page *s3.ListObjectVersionsOutput
+		err := w.retry(true, func() error {
+			var err error
+			page, err = svc.ListObjectVersions(params)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		// the prefix also matches the keys k is a prefix of, which are
+		// listed after it
+		past := false
+		version := func(key *string, versionID *string, size int64, lastModified *time.Time) {
+			escapedKey, err := url.QueryUnescape(aws.StringValue(key))
+			if err != nil {
+				escapedKey = aws.StringValue(key)
+			}
+			if escapedKey != k.Key {
+				past = past || escapedKey > k.Key
+				return
+			}
+			ch <- &ListOutput{
+				Key:          escapedKey,
+				FullKey:      k.FullKey,
+				Size:         size,
+				LastModified: aws.TimeValue(lastModified),
+				Bucket:       k.Bucket,
+				VersionID:    aws.StringValue(versionID),
+			}
+		}
+		for _, v := range page.Versions {
+			version(v.Key, v.VersionId, aws.Int64Value(v.Size), v.LastModified)
+		}
+		for _, marker := range page.DeleteMarkers {
+			version(marker.Key, marker.VersionId, 0, marker.LastModified)
+		}
+		if past || !aws.BoolValue(page.IsTruncated) || w.draining() {
+			return nil
+		}
+		params.KeyMarker = page.NextKeyMarker
+		params.VersionIdMarker = page.NextVersionIdMarker
+	}
+}
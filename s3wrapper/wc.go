@@ -0,0 +1,68 @@
+package s3wrapper
+
+import (
+	"fmt"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// wcCounts counts the lines, words and bytes written to it like wc does,
+// words are runs of non space characters
+type wcCounts struct {
+	lines int64
+	words int64
+	bytes int64
+	// inWord tells whether the last byte written was part of a word, which
+	// may go on in the next write
+	inWord bool
+}
+
+func (c *wcCounts) Write(p []byte) (int, error) {
+	c.bytes += int64(len(p))
+	for i := 0; i < len(p); {
+		r, size := utf8.DecodeRune(p[i:])
+		i += size
+		if r == '\n' {
+			c.lines++
+		}
+		if unicode.IsSpace(r) {
+			c.inWord = false
+		} else if !c.inWord {
+			c.inWord = true
+			c.words++
+		}
+	}
+	return len(p), nil
+}
+
+// format formats the counts as a line of wc's output
+func (c *wcCounts) format(name string) string {
+	return fmt.Sprintf("%8d %8d %10d %s\n", c.lines, c.words, c.bytes, name)
+}
+
+// wcTotals adds up the counts of the keys streamed with Stats
+type wcTotals struct {
+	sync.Mutex
+	wcCounts
+	keys int
+}
+
+func (t *wcTotals) add(c *wcCounts) {
+	t.Lock()
+	defer t.Unlock()
+	t.lines += c.lines
+	t.words += c.words
+	t.bytes += c.bytes
+	t.keys++
+}
+
+// emit passes the total line to emit when more than one key was counted,
+// like wc does
+func (t *wcTotals) emit(emit func(string)) {
+	t.Lock()
+	defer t.Unlock()
+	if t.keys > 1 {
+		emit(t.format("total"))
+	}
+}